@@ -14,6 +14,7 @@ import (
 	"github.com/usekuro/usekuro/internal/extensions"
 	"github.com/usekuro/usekuro/internal/loader"
 	runtimepkg "github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/store"
 	"github.com/usekuro/usekuro/internal/template"
 	"github.com/usekuro/usekuro/internal/web"
 )
@@ -41,6 +42,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The global store, shared by every mock, survives restarts when it has
+	// a file
+	if file := os.Getenv("USEKURO_STORE_FILE"); file != "" {
+		if err := store.Global.Load(file); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Dev mode returns template diagnostics in the mocks' responses
+	if dev, err := strconv.ParseBool(os.Getenv("USEKURO_DEV")); err == nil {
+		runtimepkg.DevMode = dev
+	}
+
 	switch os.Args[1] {
 	case "run":
 		if len(os.Args) < 3 {
@@ -83,6 +97,10 @@ func printUsage() {
 	fmt.Println("  usekuro boot folder/           # Run multiple mocks from backup folder")
 	fmt.Println("  usekuro validate file.kuro     # Validate schema without running")
 	fmt.Println("  usekuro web [port]             # Start web interface (default port 8798)")
+	fmt.Println()
+	fmt.Println("Environment:")
+	fmt.Println("  USEKURO_STORE_FILE=file.json   # Keep the global store between runs")
+	fmt.Println("  USEKURO_DEV=true               # Return template errors in responses")
 }
 
 func runMock(path string) {
@@ -100,8 +118,11 @@ func runMock(path string) {
 		"routes":   len(mock.Routes),
 	}).Info("Mock loaded successfully")
 
-	// Register imports
+	// Register inline functions and imports
 	reg := extensions.NewRegistry()
+	if err := reg.RegisterFunctions(mock.Functions); err != nil {
+		logger.Fatalf("Invalid functions: %v", err)
+	}
 	for _, src := range mock.Import {
 		logger.Debugf("Loading import: %s", src)
 		code, err := extensions.LoadKurof(src)
@@ -113,8 +134,7 @@ func runMock(path string) {
 		}
 	}
 
-	ctx := template.MergeContext(nil, nil, mock.Context.Variables)
-	if _, err := template.NewRuntime(ctx, reg); err != nil {
+	if _, err := template.NewSet(reg, nil); err != nil {
 		logger.Errorf("Template runtime initialization failed: %v", err)
 	}
 
@@ -127,8 +147,10 @@ func runMock(path string) {
 		handler = runtimepkg.NewTCPHandler()
 	case "ws":
 		handler = runtimepkg.NewWSHandler()
-	case "sftp":
+	case "sftp", "ssh":
 		handler = runtimepkg.NewSFTPHandler()
+	case "ftp":
+		handler = runtimepkg.NewFTPHandler()
 	default:
 		logger.Fatalf("Unsupported protocol: %s", mock.Protocol)
 	}
@@ -158,11 +180,29 @@ func runMock(path string) {
 }
 
 func validateMock(path string) {
-	_, err := loader.LoadMockFromFile(path)
+	mock, err := loader.LoadMockFromFile(path)
 	if err != nil {
 		log.Fatalf("❌ Loading error: %v", err)
 	}
 	log.Println("✅ Valid file:", path)
+
+	reg, _ := template.CheckFunctions(mock.Functions)
+	if fns := reg.FunctionList(); len(fns) > 0 {
+		fmt.Println("🔧 Functions:")
+		for _, fn := range fns {
+			if fn.Doc != "" {
+				fmt.Printf("  %-28s %s\n", fn.Signature(), fn.Doc)
+			} else {
+				fmt.Printf("  %s\n", fn.Signature())
+			}
+		}
+	}
+	if len(mock.Import) > 0 {
+		fmt.Println("📦 Imports:")
+		for _, src := range mock.Import {
+			fmt.Printf("  %s\n", src)
+		}
+	}
 }
 
 func waitForExit() {
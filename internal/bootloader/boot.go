@@ -47,8 +47,10 @@ func BootFromFolder(path string) {
 				handler = runtime.NewTCPHandler()
 			case "ws":
 				handler = runtime.NewWSHandler()
-			case "sftp":
+			case "sftp", "ssh":
 				handler = runtime.NewSFTPHandler()
+			case "ftp":
+				handler = runtime.NewFTPHandler()
 			default:
 				log.Printf("⚠️ Protocolo no reconocido: %s", mock.Protocol)
 				return nil
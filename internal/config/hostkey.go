@@ -0,0 +1,74 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultHostKeyPaths are the SSH host keys shared by mocks that do not
+// configure their own, keyed by type. The RSA key is the one Initialize
+// generates.
+var DefaultHostKeyPaths = map[string]string{
+	"rsa":     "settings/host_key",
+	"ed25519": "settings/host_key_ed25519",
+}
+
+// LoadOrGenerateHostKey reads the SSH private key at path, generating and
+// persisting a new one of keyType (ed25519 or rsa) when the file does not
+// exist yet. The public key is written next to it with a .pub suffix.
+func LoadOrGenerateHostKey(path, keyType string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read host key %s: %w", path, err)
+	}
+
+	var key crypto.Signer
+	switch keyType {
+	case "", "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unsupported host key type %q", keyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "usekuro host key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode host key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for host key: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write host key: %w", err)
+	}
+	if err := os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write public host key: %w", err)
+	}
+
+	fmt.Printf("✅ SSH host key generated at %s (%s)\n", path, ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
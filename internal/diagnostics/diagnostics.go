@@ -0,0 +1,239 @@
+package diagnostics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Position is where the value of a field starts in a mock file
+type Position struct {
+	Line   int
+	Column int
+	Block  bool // a | or > block, whose text starts on the next line
+	Quoted bool
+}
+
+// Source maps the fields of a mock, e.g. routes[3].response.body, to their
+// place in the .kuro (YAML) or JSON file it was loaded from
+type Source struct {
+	File   string
+	fields map[string]Position
+	lines  []string
+}
+
+// Parse reads the position of every field of a mock file
+func Parse(file string, data []byte) (*Source, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	s := &Source{
+		File:   file,
+		fields: make(map[string]Position),
+		lines:  strings.Split(string(data), "\n"),
+	}
+	if len(root.Content) > 0 {
+		s.walk("", root.Content[0])
+	}
+	return s, nil
+}
+
+func (s *Source) walk(field string, n *yaml.Node) {
+	if field != "" {
+		s.fields[field] = Position{
+			Line:   n.Line,
+			Column: n.Column,
+			Block:  n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0,
+			Quoted: n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0,
+		}
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if field != "" {
+				key = field + "." + key
+			}
+			s.walk(key, n.Content[i+1])
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			s.walk(fmt.Sprintf("%s[%d]", field, i), item)
+		}
+	}
+}
+
+// Field returns the position of a field's value
+func (s *Source) Field(field string) (Position, bool) {
+	if s == nil {
+		return Position{}, false
+	}
+	p, ok := s.fields[field]
+	return p, ok
+}
+
+func (s *Source) has(field string) bool {
+	_, ok := s.Field(field)
+	return ok
+}
+
+// At returns the place in the file of line and column (0-based, as
+// text/template reports them) of a field's text
+func (s *Source) At(field string, line, col int) (int, int, bool) {
+	p, ok := s.Field(field)
+	if !ok {
+		return 0, 0, false
+	}
+	switch {
+	case line <= 0:
+		return p.Line, p.Column, true
+	case p.Block:
+		// the block drops the indentation of its first line from every line
+		return p.Line + line, s.blockIndent(p.Line) + col + 1, true
+	case line == 1:
+		c := p.Column + col
+		if p.Quoted {
+			c++
+		}
+		return p.Line, c, true
+	default:
+		// a plain or quoted scalar continued on the lines below
+		l := p.Line + line - 1
+		return l, s.indent(l) + col + 1, true
+	}
+}
+
+// blockIndent returns the indentation of a block starting after line
+func (s *Source) blockIndent(line int) int {
+	for l := line + 1; l <= len(s.lines); l++ {
+		if strings.TrimSpace(s.lines[l-1]) != "" {
+			return s.indent(l)
+		}
+	}
+	return 0
+}
+
+func (s *Source) indent(line int) int {
+	if line < 1 || line > len(s.lines) {
+		return 0
+	}
+	text := s.lines[line-1]
+	return len(text) - len(strings.TrimLeft(text, " \t"))
+}
+
+// Diagnostic is a template error placed in its mock: the file, line and
+// column when the mock was loaded from a file, the field and the position
+// text/template reported
+type Diagnostic struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Field    string `json:"field"`
+	Template string `json:"template,omitempty"` // name:line:column of text/template
+	Message  string `json:"message"`
+	Err      error  `json:"-"`
+}
+
+// Error reads file:line:column: field: message, or the original error when
+// the mock has no file
+func (d *Diagnostic) Error() string {
+	if d.File == "" {
+		return d.Err.Error()
+	}
+	var b strings.Builder
+	b.WriteString(d.File)
+	if d.Line > 0 {
+		fmt.Fprintf(&b, ":%d", d.Line)
+		if d.Column > 0 {
+			fmt.Fprintf(&b, ":%d", d.Column)
+		}
+	}
+	fmt.Fprintf(&b, ": %s: %s", d.Field, d.Message)
+	return b.String()
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// templateErrRe finds the position text/template puts in its errors. The
+// first one is the outermost: the call site of a failing inline function.
+var templateErrRe = regexp.MustCompile(`template: (\S+?):(\d+):(?:(\d+):)? `)
+
+// numberedRe is the suffix a set adds to a template name already taken
+var numberedRe = regexp.MustCompile(`#\d+$`)
+
+// Locate places err, raised rendering the template of field, in the mock.
+// names maps other template names, like those of inline functions, to their
+// fields. A nil source only reads the template position.
+func (s *Source) Locate(field string, err error, names map[string]string) *Diagnostic {
+	if err == nil {
+		return nil
+	}
+	if d, ok := err.(*Diagnostic); ok {
+		return d
+	}
+	d := &Diagnostic{Field: field, Message: err.Error(), Err: err}
+	if s != nil {
+		d.File = s.File
+	}
+
+	text := err.Error()
+	m := templateErrRe.FindStringSubmatchIndex(text)
+	if m == nil {
+		if s != nil {
+			d.Line, d.Column, _ = s.At(field, 0, 0)
+		}
+		return d
+	}
+	name := numberedRe.ReplaceAllString(text[m[2]:m[3]], "")
+	line, _ := strconv.Atoi(text[m[4]:m[5]])
+	col := -1
+	if m[6] >= 0 {
+		col, _ = strconv.Atoi(text[m[6]:m[7]])
+	}
+	d.Template = strings.TrimSuffix(text[m[2]:m[1]], ": ")
+	d.Message = text[m[1]:]
+
+	at := field
+	switch {
+	case name == field:
+	case names[name] != "":
+		at = names[name]
+	case s.has(name):
+		at = name
+	case strings.HasSuffix(name, ".kurof"):
+		// an extension, named after its path
+		d.File, d.Line = name, line
+		if col >= 0 {
+			d.Column = col + 1
+		}
+		return d
+	default:
+		// a template defined elsewhere, e.g. by a define block
+		if s != nil {
+			d.Line, d.Column, _ = s.At(field, 0, 0)
+		}
+		return d
+	}
+	d.Field = at
+	if s == nil {
+		return d
+	}
+	if col < 0 {
+		// parse errors only give the line
+		if l, c, ok := s.At(at, line, 0); ok {
+			d.Line, d.Column = l, 0
+			if line <= 1 {
+				d.Column = c
+			}
+		}
+		return d
+	}
+	d.Line, d.Column, _ = s.At(at, line, col)
+	return d
+}
@@ -0,0 +1,96 @@
+package diagnostics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mock = `protocol: http
+routes:
+  - path: /a
+    response:
+      headers:
+        X-A: "{{ .x }}"
+      body: |
+        {
+          "a": {{ div 1 0 }}
+        }
+  - path: /b
+    response:
+      body: {{ oops }}
+functions:
+  greet(name): Hi {{ .name }}
+`
+
+func TestParseFields(t *testing.T) {
+	s, err := Parse("orders.kuro", []byte(mock))
+	require.NoError(t, err)
+
+	p, ok := s.Field("routes[0].response.body")
+	require.True(t, ok)
+	assert.Equal(t, Position{Line: 7, Column: 13, Block: true}, p)
+
+	p, ok = s.Field("routes[0].response.headers.X-A")
+	require.True(t, ok)
+	assert.Equal(t, Position{Line: 6, Column: 14, Quoted: true}, p)
+
+	_, ok = s.Field("functions.greet(name)")
+	assert.True(t, ok)
+	_, ok = s.Field("routes[2]")
+	assert.False(t, ok)
+}
+
+func TestLocate(t *testing.T) {
+	s, err := Parse("orders.kuro", []byte(mock))
+	require.NoError(t, err)
+
+	// text/template reports 0-based columns
+	d := s.Locate("routes[0].response.body", errors.New(`template: routes[0].response.body:2:10: executing "routes[0].response.body" at <div 1 0>: error calling div: division by zero`), nil)
+	assert.Equal(t, "orders.kuro", d.File)
+	assert.Equal(t, 9, d.Line)
+	assert.Equal(t, 19, d.Column, "the column of div in the file")
+	assert.Equal(t, "routes[0].response.body", d.Field)
+	assert.Equal(t, "routes[0].response.body:2:10", d.Template)
+	assert.Equal(t, `orders.kuro:9:19: routes[0].response.body: executing "routes[0].response.body" at <div 1 0>: error calling div: division by zero`, d.Error())
+
+	d = s.Locate("routes[0].response.headers.X-A", errors.New(`template: routes[0].response.headers.X-A#2:1:3: executing "x" at <.x>: boom`), nil)
+	assert.Equal(t, 6, d.Line)
+	assert.Equal(t, 18, d.Column, "after the opening quote")
+
+	d = s.Locate("routes[1].response.body", errors.New(`template: routes[1].response.body:1: function "oops" not defined`), nil)
+	assert.Equal(t, 13, d.Line)
+	assert.Equal(t, 13, d.Column, "parse errors point at the start of the line")
+	assert.Equal(t, `function "oops" not defined`, d.Message)
+}
+
+func TestLocateElsewhere(t *testing.T) {
+	s, err := Parse("orders.kuro", []byte(mock))
+	require.NoError(t, err)
+
+	// an inline function, reached from the field that called it
+	d := s.Locate("routes[1].response.body", errors.New(`template: func.greet:1:6: executing "func.greet" at <.name>: boom`), map[string]string{"func.greet": "functions.greet(name)"})
+	assert.Equal(t, "functions.greet(name)", d.Field)
+	assert.Equal(t, 15, d.Line)
+	assert.Equal(t, 22, d.Column)
+
+	// an imported extension
+	d = s.Locate("routes[1].response.body", errors.New(`template: lib/helpers.kurof:3:4: executing "stars" at <x>: boom`), nil)
+	assert.Equal(t, "lib/helpers.kurof", d.File)
+	assert.Equal(t, 3, d.Line)
+	assert.Equal(t, 5, d.Column)
+	assert.Equal(t, "routes[1].response.body", d.Field)
+}
+
+func TestLocateWithoutSource(t *testing.T) {
+	var s *Source
+	err := errors.New(`template: body:1:3: executing "body" at <div 1 0>: error calling div: division by zero`)
+	d := s.Locate("body", err, nil)
+	assert.Equal(t, "", d.File)
+	assert.Equal(t, "body:1:3", d.Template)
+	assert.Equal(t, err.Error(), d.Error(), "without a file the error reads as before")
+	assert.ErrorIs(t, d, err)
+	assert.Nil(t, s.Locate("body", nil, nil))
+}
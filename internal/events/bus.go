@@ -0,0 +1,141 @@
+package events
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Default is the bus shared by every mock of the process
+var Default = NewBus()
+
+// historySize is how many recent events a bus keeps for inspection
+const historySize = 100
+
+// queueSize is how many events a subscriber can have pending before new ones
+// are dropped
+const queueSize = 256
+
+// Event is a message a mock publishes for the other mocks of the process,
+// e.g. orders.created with the created order as data
+type Event struct {
+	Name   string    `json:"name"`
+	Data   any       `json:"data,omitempty"`
+	Source string    `json:"source,omitempty"` // mock that published it
+	Time   time.Time `json:"time"`
+	Depth  int       `json:"-"` // subscriptions that led to the event, to stop loops
+}
+
+// Bus delivers events to the subscribers whose pattern matches their name.
+// Every subscriber receives its events in order on its own goroutine, so a
+// slow or publishing subscriber never blocks the publisher.
+type Bus struct {
+	mu      sync.RWMutex
+	subs    map[int]*subscriber
+	next    int
+	history []Event
+}
+
+type subscriber struct {
+	pattern string
+	queue   chan Event
+	done    chan struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]*subscriber)}
+}
+
+// Match reports whether an event name matches a subscription pattern: the
+// name itself, or a glob such as orders.* or *
+func Match(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// Publish sends an event to the matching subscribers, setting its time when
+// it has none
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	b.history = append(b.history, e)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+	targets := make([]*subscriber, 0, len(b.subs))
+	for _, s := range b.subs {
+		if Match(s.pattern, e.Name) {
+			targets = append(targets, s)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, s := range targets {
+		select {
+		case s.queue <- e:
+		case <-s.done:
+		default:
+			logrus.WithFields(logrus.Fields{
+				"event":   e.Name,
+				"pattern": s.pattern,
+			}).Warn("⚠️ event dropped, subscriber queue is full")
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"event":       e.Name,
+		"source":      e.Source,
+		"subscribers": len(targets),
+	}).Debug("📣 event published")
+}
+
+// Subscribe calls fn with every event whose name matches pattern until the
+// returned function is called
+func (b *Bus) Subscribe(pattern string, fn func(Event)) (cancel func()) {
+	s := &subscriber{
+		pattern: pattern,
+		queue:   make(chan Event, queueSize),
+		done:    make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.subs[id] = s
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case e := <-s.queue:
+				fn(e)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+// Recent returns the last events published, oldest first
+func (b *Bus) Recent() []Event {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]Event(nil), b.history...)
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("orders.created", "orders.created"))
+	assert.True(t, Match("orders.*", "orders.created"))
+	assert.True(t, Match("*", "orders"))
+	assert.False(t, Match("orders.*", "users.created"))
+	assert.False(t, Match("orders.created", "orders.updated"))
+}
+
+func TestBusDelivery(t *testing.T) {
+	bus := NewBus()
+	got := make(chan Event, 10)
+	cancel := bus.Subscribe("orders.*", func(e Event) { got <- e })
+
+	bus.Publish(Event{Name: "orders.created", Data: 1})
+	bus.Publish(Event{Name: "users.created", Data: 2})
+	bus.Publish(Event{Name: "orders.shipped", Data: 3})
+
+	for _, want := range []any{1, 3} {
+		select {
+		case e := <-got:
+			assert.Equal(t, want, e.Data)
+			assert.False(t, e.Time.IsZero())
+		case <-time.After(time.Second):
+			t.Fatalf("event %v not delivered", want)
+		}
+	}
+
+	cancel()
+	cancel()
+	bus.Publish(Event{Name: "orders.cancelled"})
+	select {
+	case e := <-got:
+		t.Fatalf("event %s delivered after cancel", e.Name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	recent := bus.Recent()
+	require.Len(t, recent, 4)
+	assert.Equal(t, "orders.created", recent[0].Name)
+	assert.Equal(t, "orders.cancelled", recent[3].Name)
+}
+
+func TestBusSubscriberCanPublish(t *testing.T) {
+	bus := NewBus()
+	done := make(chan Event, 1)
+	defer bus.Subscribe("a", func(e Event) { bus.Publish(Event{Name: "b", Data: e.Data}) })()
+	defer bus.Subscribe("b", func(e Event) { done <- e })()
+
+	bus.Publish(Event{Name: "a", Data: "x"})
+	select {
+	case e := <-done:
+		assert.Equal(t, "x", e.Data)
+	case <-time.After(time.Second):
+		t.Fatal("chained event not delivered")
+	}
+}
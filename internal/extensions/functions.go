@@ -0,0 +1,109 @@
+package extensions
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Function is an inline function of a mock. Its key in the functions block
+// is a name or a signature such as greet(name, title), and its value the
+// template rendered on each call.
+type Function struct {
+	Key    string // as written in the functions block, e.g. greet(name)
+	Name   string
+	Params []string // optional, the call must pass exactly these arguments
+	Body   string
+	Doc    string // leading {{/* comment */}} of the body
+}
+
+var (
+	identRe     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	signatureRe = regexp.MustCompile(`^\s*([^\s(]+)\s*(?:\((.*)\))?\s*$`)
+	docRe       = regexp.MustCompile(`(?s)^\s*\{\{-?\s*/\*\s*(.*?)\s*\*/\s*-?\}\}`)
+	defineRe    = regexp.MustCompile(`\{\{-?\s*define\s`)
+)
+
+// reservedParams are the context keys a parameter would hide
+var reservedParams = map[string]bool{
+	"input": true, "session": true, "context": true, "args": true, "event": true,
+}
+
+// ParseFunction reads a functions block entry
+func ParseFunction(key, body string) (Function, error) {
+	m := signatureRe.FindStringSubmatch(key)
+	if m == nil || !identRe.MatchString(m[1]) {
+		return Function{}, fmt.Errorf("⚠️ functions.%s: invalid name, expected name or name(param, ...)", key)
+	}
+	fn := Function{Key: key, Name: m[1], Body: body}
+	if strings.TrimSpace(m[2]) != "" {
+		seen := map[string]bool{}
+		for _, p := range strings.Split(m[2], ",") {
+			p = strings.TrimSpace(p)
+			switch {
+			case !identRe.MatchString(p):
+				return Function{}, fmt.Errorf("⚠️ functions.%s: invalid parameter %q", key, p)
+			case reservedParams[p]:
+				return Function{}, fmt.Errorf("⚠️ functions.%s: parameter %q would hide .%s", key, p, p)
+			case seen[p]:
+				return Function{}, fmt.Errorf("⚠️ functions.%s: duplicate parameter %q", key, p)
+			}
+			seen[p] = true
+			fn.Params = append(fn.Params, p)
+		}
+	}
+	if strings.TrimSpace(body) == "" {
+		return Function{}, fmt.Errorf("⚠️ functions.%s: empty body", key)
+	}
+	if d := docRe.FindStringSubmatch(body); d != nil {
+		fn.Doc = strings.Join(strings.Fields(d[1]), " ")
+	}
+	return fn, nil
+}
+
+// Signature returns the name and parameters of the function, e.g.
+// greet(name, title), or name(args...) when it takes any arguments
+func (f Function) Signature() string {
+	if f.Params == nil {
+		return f.Name + "(args...)"
+	}
+	return fmt.Sprintf("%s(%s)", f.Name, strings.Join(f.Params, ", "))
+}
+
+// Legacy reports whether the body wraps itself in {{ define "name" }}, the
+// format used before functions could be called with arguments
+func (f Function) Legacy() bool {
+	return defineRe.MatchString(f.Body)
+}
+
+// RegisterFunctions parses a mock's functions block into the registry
+func (r *Registry) RegisterFunctions(defs map[string]string) error {
+	keys := make([]string, 0, len(defs))
+	for k := range defs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fn, err := ParseFunction(k, defs[k])
+		if err != nil {
+			return err
+		}
+		if _, dup := r.Functions[fn.Name]; dup {
+			return fmt.Errorf("⚠️ functions.%s: %s is already defined", k, fn.Name)
+		}
+		r.Functions[fn.Name] = fn
+	}
+	return nil
+}
+
+// FunctionList returns the registered functions sorted by name
+func (r *Registry) FunctionList() []Function {
+	out := make([]Function, 0, len(r.Functions))
+	for _, fn := range r.Functions {
+		out = append(out, fn)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
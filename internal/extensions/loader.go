@@ -6,10 +6,38 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
+// remoteTTL is how long a remote .kurof is reused before fetching it again
+const remoteTTL = 5 * time.Minute
+
+// cached is a loaded .kurof: a local file while its size and modification
+// time stay the same, a remote one until expires
+type cached struct {
+	content string
+	modTime time.Time
+	size    int64
+	expires time.Time
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]cached{}
+)
+
+// LoadKurof returns the content of a .kurof from a URL or a local path.
+// Sources are cached, so mocks starting or reconnecting do not read or
+// fetch them again.
 func LoadKurof(source string) (string, error) {
 	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		cacheMu.Lock()
+		c, ok := cache[source]
+		cacheMu.Unlock()
+		if ok && time.Now().Before(c.expires) {
+			return c.content, nil
+		}
 		resp, err := http.Get(source)
 		if err != nil {
 			return "", err
@@ -19,9 +47,33 @@ func LoadKurof(source string) (string, error) {
 			return "", errors.New("failed to fetch remote kurof")
 		}
 		body, err := io.ReadAll(resp.Body)
-		return string(body), err
+		if err != nil {
+			return "", err
+		}
+		store(source, cached{content: string(body), expires: time.Now().Add(remoteTTL)})
+		return string(body), nil
 	}
 	// local file
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+	cacheMu.Lock()
+	c, ok := cache[source]
+	cacheMu.Unlock()
+	if ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.content, nil
+	}
 	data, err := os.ReadFile(source)
-	return string(data), err
+	if err != nil {
+		return "", err
+	}
+	store(source, cached{content: string(data), modTime: info.ModTime(), size: info.Size()})
+	return string(data), nil
+}
+
+func store(source string, c cached) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cache[source] = c
 }
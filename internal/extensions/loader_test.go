@@ -0,0 +1,65 @@
+package extensions
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKurofCachesLocalFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "helpers.kurof")
+	require.NoError(t, os.WriteFile(file, []byte(`{{ define "a" }}a{{ end }}`), 0644))
+	first, err := LoadKurof(file)
+	require.NoError(t, err)
+	assert.Equal(t, `{{ define "a" }}a{{ end }}`, first)
+
+	// same size and modification time: the cached content is returned
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, []byte(`{{ define "b" }}b{{ end }}`), 0644))
+	require.NoError(t, os.Chtimes(file, info.ModTime(), info.ModTime()))
+	got, err := LoadKurof(file)
+	require.NoError(t, err)
+	assert.Equal(t, first, got)
+
+	// a changed file is read again
+	later := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(file, later, later))
+	got, err = LoadKurof(file)
+	require.NoError(t, err)
+	assert.Equal(t, `{{ define "b" }}b{{ end }}`, got)
+
+	require.NoError(t, os.Remove(file))
+	_, err = LoadKurof(file)
+	assert.Error(t, err)
+}
+
+func TestLoadKurofCachesRemoteFiles(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/helpers.kurof" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{{ define "n" }}%d{{ end }}`, hits.Add(1))
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 3; i++ {
+		got, err := LoadKurof(srv.URL + "/helpers.kurof")
+		require.NoError(t, err)
+		assert.Equal(t, `{{ define "n" }}1{{ end }}`, got)
+	}
+	assert.Equal(t, int32(1), hits.Load(), "fetched once")
+
+	_, err := LoadKurof(srv.URL + "/missing.kurof")
+	assert.Error(t, err)
+}
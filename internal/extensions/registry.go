@@ -8,10 +8,14 @@ type Extension struct {
 
 type Registry struct {
 	Extensions map[string]Extension
+	Functions  map[string]Function // inline functions of the mock
 }
 
 func NewRegistry() *Registry {
-	return &Registry{Extensions: make(map[string]Extension)}
+	return &Registry{
+		Extensions: make(map[string]Extension),
+		Functions:  make(map[string]Function),
+	}
 }
 
 func (r *Registry) Register(name, content, source string) {
@@ -0,0 +1,178 @@
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Get evaluates a JSONPath expression against a decoded JSON document
+// (maps, slices and scalars as produced by encoding/json).
+//
+// Supported syntax: the root `$`, child access with `.name` or `['name']`,
+// array indexes `[0]` (negative indexes count from the end) and wildcards
+// `.*` / `[*]`. A path containing a wildcard yields a []any of every match.
+// The boolean result is false when the path does not resolve.
+func Get(doc any, path string) (any, bool, error) {
+	steps, err := parse(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	wildcard := false
+	for _, st := range steps {
+		wildcard = wildcard || st.wildcard
+	}
+
+	current := []any{doc}
+	for _, st := range steps {
+		var next []any
+		for _, node := range current {
+			next = append(next, st.apply(node)...)
+		}
+		current = next
+		if len(current) == 0 {
+			break
+		}
+	}
+
+	if wildcard {
+		if current == nil {
+			current = []any{}
+		}
+		return current, len(current) > 0, nil
+	}
+	if len(current) == 0 {
+		return nil, false, nil
+	}
+	return current[0], true, nil
+}
+
+type step struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func (s step) apply(node any) []any {
+	switch v := node.(type) {
+	case map[string]any:
+		if s.wildcard {
+			out := make([]any, 0, len(v))
+			for _, k := range sortedKeys(v) {
+				out = append(out, v[k])
+			}
+			return out
+		}
+		if s.isIndex {
+			return nil
+		}
+		if val, ok := v[s.key]; ok {
+			return []any{val}
+		}
+	case []any:
+		if s.wildcard {
+			return append([]any{}, v...)
+		}
+		if !s.isIndex {
+			return nil
+		}
+		i := s.index
+		if i < 0 {
+			i += len(v)
+		}
+		if i >= 0 && i < len(v) {
+			return []any{v[i]}
+		}
+	}
+	return nil
+}
+
+func parse(path string) ([]step, error) {
+	p := strings.TrimSpace(path)
+	if p == "" {
+		return nil, fmt.Errorf("empty JSONPath")
+	}
+	if p[0] == '$' {
+		p = p[1:]
+	} else if p[0] != '.' && p[0] != '[' {
+		// tolerate "a.b" as shorthand for "$.a.b"
+		p = "." + p
+	}
+
+	var steps []step
+	for len(p) > 0 {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			name := p[:end]
+			if name == "" {
+				return nil, fmt.Errorf("invalid JSONPath %q: empty member name", path)
+			}
+			if name == "*" {
+				steps = append(steps, step{wildcard: true})
+			} else {
+				steps = append(steps, step{key: name})
+			}
+			p = p[end:]
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath %q: missing ]", path)
+			}
+			inner := strings.TrimSpace(p[1:end])
+			p = p[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, step{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, step{key: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSONPath %q: bad index %q", path, inner)
+				}
+				steps = append(steps, step{index: i, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q: unexpected %q", path, p[0])
+		}
+	}
+	return steps, nil
+}
+
+// TypeOf returns the JSON type name of a decoded value: string, number,
+// boolean, object, array or null
+func TypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, float32, int, int64, int32, uint, uint64, uint32:
+		return "number"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, raw string) any {
+	var v any
+	require.NoError(t, json.Unmarshal([]byte(raw), &v))
+	return v
+}
+
+func TestGet(t *testing.T) {
+	doc := decode(t, `{
+		"type": "subscribe",
+		"channel": "btc",
+		"meta": {"user-id": 7},
+		"items": [{"id": "a"}, {"id": "b"}, {"id": "c"}]
+	}`)
+
+	cases := []struct {
+		path  string
+		want  any
+		found bool
+	}{
+		{"$", doc, true},
+		{"$.type", "subscribe", true},
+		{"channel", "btc", true},
+		{"$.meta['user-id']", float64(7), true},
+		{"$.items[1].id", "b", true},
+		{"$.items[-1].id", "c", true},
+		{"$.items[*].id", []any{"a", "b", "c"}, true},
+		{"$.missing", nil, false},
+		{"$.items[9]", nil, false},
+		{"$.nothing[*]", []any{}, false},
+	}
+
+	for _, tc := range cases {
+		got, found, err := Get(doc, tc.path)
+		require.NoError(t, err, tc.path)
+		require.Equal(t, tc.found, found, tc.path)
+		require.Equal(t, tc.want, got, tc.path)
+	}
+}
+
+func TestGetInvalidPath(t *testing.T) {
+	for _, p := range []string{"", "$.items[", "$.items[x]", "$..a"} {
+		_, _, err := Get(map[string]any{}, p)
+		require.Error(t, err, p)
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	doc := decode(t, `{"s": "x", "n": 1.5, "b": true, "o": {}, "a": [], "z": null}`).(map[string]any)
+	require.Equal(t, "string", TypeOf(doc["s"]))
+	require.Equal(t, "number", TypeOf(doc["n"]))
+	require.Equal(t, "boolean", TypeOf(doc["b"]))
+	require.Equal(t, "object", TypeOf(doc["o"]))
+	require.Equal(t, "array", TypeOf(doc["a"]))
+	require.Equal(t, "null", TypeOf(doc["z"]))
+}
@@ -2,11 +2,16 @@ package loader
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/usekuro/usekuro/internal/diagnostics"
+	"github.com/usekuro/usekuro/internal/extensions"
 	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/template"
 	"gopkg.in/yaml.v3"
 )
 
@@ -24,14 +29,95 @@ func LoadMockFromFile(path string) (*schema.MockDefinition, error) {
 			return nil, fmt.Errorf("invalid JSON format: %w", err)
 		}
 	} else {
-		if err := yaml.Unmarshal(data, def); err != nil {
+		// Decode generically and re-encode as JSON so the schema's json tags
+		// (onMessage, sftpAuth, ...) apply to .kuro files as well
+		var raw any
+		if err := yaml.Unmarshal(data, &raw); err != nil {
 			return nil, fmt.Errorf("invalid YAML format: %w", err)
 		}
+		converted, err := json.Marshal(stringKeys(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML format: %w", err)
+		}
+		if err := json.Unmarshal(converted, def); err != nil {
+			return nil, fmt.Errorf("invalid YAML format: %w", err)
+		}
+	}
+
+	// Relative paths inside the mock (e.g. SFTP file sources) are resolved
+	// against the mock file's directory
+	def.BaseDir = filepath.Dir(path)
+
+	// Field positions place template errors in the file, here and at runtime
+	if source, err := diagnostics.Parse(path, data); err == nil {
+		def.Source = source
 	}
 
 	if err := schema.Validate(def); err != nil {
 		return nil, fmt.Errorf("schema validation failed: %w", err)
 	}
+	if def.Limits != nil {
+		if err := template.CheckAllow(def.Limits.Allow); err != nil {
+			return nil, fmt.Errorf("schema validation failed: %w", err)
+		}
+	}
+	if err := CheckTemplates(def); err != nil {
+		return nil, fmt.Errorf("schema validation failed: %w", err)
+	}
 
 	return def, nil
 }
+
+// stringKeys turns the keys YAML decodes as other values, such as true or 1,
+// into the strings a map[string]any field would get, so the mock can be
+// encoded as JSON
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = stringKeys(item)
+		}
+		return v
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return out
+	case []any:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// CheckTemplates compiles the inline functions and templates of a mock and
+// reports every one that does not compile, placed in the mock file when it
+// was loaded from one. Imports are not loaded: the templates they define are
+// only looked up at runtime.
+func CheckTemplates(def *schema.MockDefinition) error {
+	registry := extensions.NewRegistry()
+	if err := registry.RegisterFunctions(def.Functions); err != nil {
+		return err
+	}
+	env := template.NewEnv(nil)
+	env.Source = def.Source
+	set, err := template.NewSet(registry, env)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, t := range def.Templates() {
+		if _, err := set.Compile(t.Field, t.Text); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("❌ %d template(s) do not compile:\n%w", len(errs), errors.Join(errs...))
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package loader
 import (
 	"github.com/stretchr/testify/require"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -29,3 +30,136 @@ routes:
 	require.Len(t, def.Routes, 1)
 	require.Equal(t, "/ping", def.Routes[0].Path)
 }
+
+func TestLoadMockFromFileCamelCaseKeys(t *testing.T) {
+	content := `
+protocol: tcp
+port: 9090
+onMessage:
+  match: "(?P<cmd>\\w+)"
+  conditions:
+    - if: '{{ eq .input.cmd "PING" }}'
+      respond: "PONG"
+`
+	tmp := "test_camel.kuro"
+	err := os.WriteFile(tmp, []byte(content), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tmp)
+
+	def, err := LoadMockFromFile(tmp)
+	require.NoError(t, err)
+	require.NotNil(t, def.OnMessage)
+	require.Len(t, def.OnMessage.Conditions, 1)
+	require.Equal(t, "PONG", def.OnMessage.Conditions[0].Respond)
+}
+
+func TestLoadMockFromFileNonStringKeys(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "flags.kuro")
+	content := `
+protocol: http
+port: 8081
+context:
+  variables:
+    flags: {true: on, 1: one}
+    nested:
+      - {2.5: half}
+routes:
+  - path: /ping
+    method: GET
+    response:
+      status: 200
+      body: "pong"
+`
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0644))
+
+	def, err := LoadMockFromFile(tmp)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"true": "on", "1": "one"}, def.Context.Variables["flags"])
+	require.Equal(t, []any{map[string]any{"2.5": "half"}}, def.Context.Variables["nested"])
+}
+
+func TestLoadMockFromFileSetsBaseDir(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "files.kuro")
+	content := `
+protocol: tcp
+port: 9091
+onMessage:
+  else: "ok"
+`
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0644))
+
+	def, err := LoadMockFromFile(tmp)
+	require.NoError(t, err)
+	require.Equal(t, dir, def.BaseDir)
+}
+
+func TestLoadMockFromFileChecksFunctions(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "functions.kuro")
+	content := `
+protocol: http
+port: 8081
+functions:
+  greet(name): 'Hello {{ .name | shout }}'
+routes:
+  - path: /hi
+    method: GET
+    response:
+      status: 200
+      body: '{{ greet "Ana" }}'
+`
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0644))
+
+	_, err := LoadMockFromFile(tmp)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "functions.greet")
+	require.Contains(t, err.Error(), `function "shout" not defined`)
+}
+
+func TestLoadMockFromFileLocatesTemplateErrors(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "broken.kuro")
+	content := `protocol: tcp
+port: 9090
+onMessage:
+  conditions:
+    - if: '{{ eq .input "PING" }}'
+      respond: "PONG {{ .input"
+  else: |
+    unknown
+    {{ nope }}
+`
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0644))
+
+	_, err := LoadMockFromFile(tmp)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "2 template(s) do not compile")
+	require.Contains(t, err.Error(), tmp+":6:17: onMessage.conditions[0].respond: unclosed action")
+	require.Contains(t, err.Error(), tmp+`:9: onMessage.else: function "nope" not defined`)
+}
+
+func TestLoadMockFromFileChecksWriteContent(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "upload.kuro")
+	content := `protocol: sftp
+port: 2222
+sftpAuth:
+  username: user
+  password: pass
+files:
+  - path: /in/.keep
+    content: ""
+onUpload:
+  - path: /in/*
+    write:
+      - path: /out/ack.txt
+        content: "{{ .upload.name"
+        template: false
+`
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0644))
+
+	_, err := LoadMockFromFile(tmp)
+	require.Error(t, err, "write entries always render their content")
+	require.Contains(t, err.Error(), "onUpload[0].write[0].content: unclosed action")
+}
@@ -1,11 +1,18 @@
 package runtime
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
 	"regexp"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/diagnostics"
 	"github.com/usekuro/usekuro/internal/extensions"
 	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/store"
+	"github.com/usekuro/usekuro/internal/template"
 )
 
 type ProtocolHandler interface {
@@ -13,9 +20,13 @@ type ProtocolHandler interface {
 	Stop() error
 }
 
-func loadExtensions(imports []string, logger *logrus.Entry) *extensions.Registry {
+// loadExtensions returns the .kurof imports and inline functions of a mock
+func loadExtensions(def *schema.MockDefinition, logger *logrus.Entry) *extensions.Registry {
 	registry := extensions.NewRegistry()
-	for _, src := range imports {
+	if err := registry.RegisterFunctions(def.Functions); err != nil {
+		logger.WithError(err).Warn("failed to register inline functions")
+	}
+	for _, src := range def.Import {
 		code, err := extensions.LoadKurof(src)
 		if err != nil {
 			logger.WithFields(logrus.Fields{
@@ -30,6 +41,134 @@ func loadExtensions(imports []string, logger *logrus.Entry) *extensions.Registry
 	return registry
 }
 
+// DevMode returns template diagnostics in responses, besides logging them.
+// The CLI sets it from USEKURO_DEV.
+var DevMode bool
+
+// compileTemplates compiles the templates of a mock once at start, so
+// requests only execute them. A template that does not compile is reported
+// here, with its place in the mock, and keeps failing the renders that use
+// it.
+func compileTemplates(def *schema.MockDefinition, registry *extensions.Registry, env *template.Env, logger *logrus.Entry) {
+	set, err := env.Templates(registry)
+	if err != nil {
+		logger.WithError(err).Error("❌ failed to compile extensions and functions")
+		return
+	}
+	for _, t := range def.Templates() {
+		if _, err := set.Compile(t.Field, t.Text); err != nil {
+			logger.WithFields(diagnosticFields(err)).WithError(err).Warn("⚠️ template does not compile")
+		}
+	}
+}
+
+// logTemplateError logs a failed render with its place in the mock
+func logTemplateError(err error, logger *logrus.Entry) {
+	entry := logger.WithFields(diagnosticFields(err)).WithError(err)
+	var limit *template.LimitError
+	if errors.As(err, &limit) {
+		entry.WithField("limit", limit.Limit).Error("❌ template stopped by the mock's limits")
+		return
+	}
+	entry.Warn("⚠️ template failed")
+}
+
+// diagnosticOf returns the diagnostic of a template error, if any
+func diagnosticOf(err error) *diagnostics.Diagnostic {
+	var d *diagnostics.Diagnostic
+	if errors.As(err, &d) {
+		return d
+	}
+	return nil
+}
+
+// diagnosticFields are the log fields placing a template error in its mock
+func diagnosticFields(err error) logrus.Fields {
+	d := diagnosticOf(err)
+	if d == nil {
+		return logrus.Fields{}
+	}
+	fields := logrus.Fields{"field": d.Field}
+	if d.File != "" {
+		fields["file"] = d.File
+	}
+	if d.Line > 0 {
+		fields["line"] = d.Line
+	}
+	return fields
+}
+
+// newTemplateEnv creates the environment shared by the templates of a mock,
+// with its seed, initial clock, store and limits
+func newTemplateEnv(def *schema.MockDefinition) (*template.Env, error) {
+	env := template.NewEnv(def.Seed)
+	env.Mock = mockName(def)
+	if c := def.Clock; c != nil {
+		if c.Freeze != "" {
+			t, err := time.Parse(time.RFC3339, c.Freeze)
+			if err != nil {
+				return nil, fmt.Errorf("❌ invalid clock.freeze %q: %w", c.Freeze, err)
+			}
+			env.Clock.Freeze(t)
+		}
+		if c.Offset != "" {
+			d, err := time.ParseDuration(c.Offset)
+			if err != nil {
+				return nil, fmt.Errorf("❌ invalid clock.offset %q: %w", c.Offset, err)
+			}
+			env.Clock.SetOffset(d)
+		}
+	}
+	if cfg := def.Store; cfg != nil {
+		env.Store = store.New(cfg.Initial)
+		if cfg.File != "" {
+			path := cfg.File
+			if !filepath.IsAbs(path) && def.BaseDir != "" {
+				path = filepath.Join(def.BaseDir, path)
+			}
+			if err := env.Store.Load(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if l := def.Limits; l != nil {
+		if l.Timeout != "" {
+			d, err := time.ParseDuration(l.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("❌ invalid limits.timeout %q: %w", l.Timeout, err)
+			}
+			env.Limits.Timeout = d
+		}
+		if l.MaxOutput != "" {
+			n, err := schema.ParseSize(l.MaxOutput)
+			if err != nil {
+				return nil, fmt.Errorf("❌ invalid limits.maxOutput: %w", err)
+			}
+			env.Limits.MaxOutput = n
+		}
+		if l.MaxDepth > 0 {
+			env.Limits.MaxDepth = l.MaxDepth
+		}
+		if l.Allow != nil {
+			if err := template.CheckAllow(l.Allow); err != nil {
+				return nil, err
+			}
+			env.Limits.Allow = l.Allow
+		}
+	}
+	env.Source = def.Source
+	return env, nil
+}
+
+// contextVariables returns the mock's context variables, tolerating a
+// definition without a context block
+func contextVariables(def *schema.MockDefinition) map[string]any {
+	if def.Context == nil {
+		return nil
+	}
+	return def.Context.Variables
+}
+
 func extractVars(input, pattern string) map[string]any {
 	if pattern == "" {
 		return map[string]any{"msg": input}
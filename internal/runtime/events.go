@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/events"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// maxEventDepth bounds chains of subscriptions that publish events, so two
+// mocks reacting to each other cannot loop forever
+const maxEventDepth = 8
+
+// mockName identifies a mock as the source of the events it publishes
+func mockName(def *schema.MockDefinition) string {
+	if def.Meta.Name != "" {
+		return def.Meta.Name
+	}
+	return fmt.Sprintf("%s:%d", def.Protocol, def.Port)
+}
+
+// publishEvent renders the publish action of field and sends the event to
+// the mocks of the process. depth is the number of subscriptions that led to
+// it.
+func publishEvent(tpl *template.Runtime, field string, action *schema.PublishAction, env *template.Env, depth int, logger *logrus.Entry) {
+	name, err := tpl.Render(field+".event", action.Event)
+	if err != nil || strings.TrimSpace(name) == "" {
+		logger.WithError(err).WithField("event", action.Event).Warn("⚠️ event not published, invalid name")
+		return
+	}
+	data, err := tpl.Render(field+".data", action.Data)
+	if err != nil {
+		logger.WithError(err).WithField("event", name).Warn("⚠️ event not published, data template failed")
+		return
+	}
+	env.Events.Publish(events.Event{
+		Name:   strings.TrimSpace(name),
+		Data:   eventData(data),
+		Source: env.Mock,
+		Depth:  depth,
+	})
+	logger.WithField("event", name).Info("📣 event published")
+}
+
+// eventData sends rendered JSON as structured data and anything else as text
+func eventData(raw string) any {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(trimmed), &v); err == nil {
+		return v
+	}
+	return raw
+}
+
+// subscribe registers the subscriptions of a mock on its event bus and
+// returns a function cancelling them. For every matching event it renders
+// the subscription's do template, calls react for the protocol specific
+// part and publishes the follow-up event.
+func subscribe(def *schema.MockDefinition, registry *extensions.Registry, env *template.Env, logger *logrus.Entry, react func(i int, sub schema.Subscription, tpl *template.Runtime)) func() {
+	cancels := make([]func(), 0, len(def.Subscribe))
+	for i, sub := range def.Subscribe {
+		i, sub := i, sub
+		cancels = append(cancels, env.Events.Subscribe(sub.Event, func(e events.Event) {
+			handleEvent(def, registry, env, logger, i, sub, e, react)
+		}))
+		logger.WithField("event", sub.Event).Info("👂 subscribed to event")
+	}
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+func handleEvent(def *schema.MockDefinition, registry *extensions.Registry, env *template.Env, logger *logrus.Entry, i int, sub schema.Subscription, e events.Event, react func(int, schema.Subscription, *template.Runtime)) {
+	logger = logger.WithFields(logrus.Fields{"event": e.Name, "source": e.Source})
+	if e.Depth >= maxEventDepth {
+		logger.Warnf("⚠️ subscribe[%d] ignored the event, more than %d chained events", i, maxEventDepth)
+		return
+	}
+
+	ctx := template.MergeContext(nil, nil, contextVariables(def))
+	if e.Data != nil {
+		ctx["input"] = e.Data
+	}
+	ctx["event"] = map[string]any{"name": e.Name, "source": e.Source, "time": e.Time}
+	tpl, err := template.NewRuntimeEnv(ctx, registry, env)
+	if err != nil {
+		logger.WithError(err).Error("template runtime creation failed")
+		return
+	}
+	tpl.SetEventDepth(e.Depth + 1)
+
+	if sub.If != "" {
+		result, err := tpl.Render(fmt.Sprintf("subscribe[%d].if", i), sub.If)
+		if err != nil {
+			logger.WithError(err).Warnf("⚠️ subscribe[%d].if failed", i)
+			return
+		}
+		if strings.TrimSpace(result) != "true" {
+			return
+		}
+	}
+	logger.Infof("📨 subscribe[%d] reacting to event", i)
+
+	if sub.Do != "" {
+		if _, err := tpl.Render(fmt.Sprintf("subscribe[%d].do", i), sub.Do); err != nil {
+			logger.WithError(err).Warnf("⚠️ subscribe[%d].do failed", i)
+		}
+	}
+	if react != nil {
+		react(i, sub, tpl)
+	}
+	if sub.Publish != nil {
+		publishEvent(tpl, fmt.Sprintf("subscribe[%d].publish", i), sub.Publish, env, e.Depth+1, logger)
+	}
+}
@@ -0,0 +1,251 @@
+package runtime
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// FTPHandler serves an FTP or FTPS mock. Files, credentials and storage are
+// the same as for SFTP mocks.
+type FTPHandler struct {
+	port      int
+	def       *schema.MockDefinition
+	cfg       *schema.FTPConfig
+	auth      *sftpAuthenticator
+	tlsConfig *tls.Config
+	pasvMin   int
+	pasvMax   int
+	listener  net.Listener
+	tree      fileTree
+	events    *sftpEvents
+	policy    *sftpPolicy
+	registry  *extensions.Registry
+	env       *template.Env
+	logger    *logrus.Entry
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	unsubscribe func()
+}
+
+func NewFTPHandler() *FTPHandler {
+	return &FTPHandler{
+		conns:  make(map[net.Conn]struct{}),
+		logger: logrus.WithField("protocol", "ftp"),
+	}
+}
+
+func (h *FTPHandler) Start(def *schema.MockDefinition) error {
+	h.port = def.Port
+	h.def = def
+	h.cfg = def.FTP
+	if h.cfg == nil {
+		h.cfg = &schema.FTPConfig{}
+	}
+
+	auth, err := newSFTPAuthenticator(def.SFTPAuth, def.BaseDir)
+	if err != nil {
+		return err
+	}
+	h.auth = auth
+
+	if h.tlsMode() != "none" {
+		cert, err := loadFTPCertificate(h.cfg, def.BaseDir)
+		if err != nil {
+			return fmt.Errorf("❌ failed to load FTPS certificate: %w", err)
+		}
+		h.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		sum := sha256.Sum256(cert.Certificate[0])
+		h.logger.WithField("fingerprint", "SHA256:"+hex.EncodeToString(sum[:])).Info("🔑 FTPS certificate")
+	}
+	if h.cfg.PassivePorts != "" {
+		if h.pasvMin, h.pasvMax, err = schema.ParsePortRange(h.cfg.PassivePorts); err != nil {
+			return fmt.Errorf("❌ invalid ftp.passivePorts: %w", err)
+		}
+	}
+
+	h.registry = loadExtensions(def, h.logger)
+	if h.env, err = newTemplateEnv(def); err != nil {
+		return err
+	}
+	compileTemplates(def, h.registry, h.env, h.logger)
+	tree, policy, events, err := openFileStore(def, h.registry, h.env, h.logger)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", h.port))
+	if err != nil {
+		tree.Close()
+		return fmt.Errorf("❌ failed to listen on port %d: %w", h.port, err)
+	}
+	if h.tlsMode() == "implicit" {
+		listener = tls.NewListener(listener, h.tlsConfig)
+	}
+	h.listener = listener
+	h.tree = tree
+	h.policy = policy
+	h.events = events
+	h.unsubscribe = subscribe(def, h.registry, h.env, h.logger, events.reactWrite)
+
+	h.logger.WithField("tls", h.tlsMode()).Infof("🚀 FTP server listening on port %d", h.port)
+
+	go h.acceptConnections()
+	return nil
+}
+
+// tlsMode returns none, explicit, optional or implicit
+func (h *FTPHandler) tlsMode() string {
+	if h.cfg == nil || h.cfg.TLS == "" {
+		return "none"
+	}
+	return h.cfg.TLS
+}
+
+// tlsRequired reports whether logins and data connections must be encrypted
+func (h *FTPHandler) tlsRequired() bool {
+	mode := h.tlsMode()
+	return mode == "explicit" || mode == "implicit"
+}
+
+func (h *FTPHandler) acceptConnections() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			h.logger.WithError(err).Debug("listener closed")
+			return
+		}
+		h.track(conn, true)
+		go func() {
+			defer h.track(conn, false)
+			s := newFTPSession(h, conn)
+			s.serve()
+		}()
+	}
+}
+
+// track registers the open control and data connections, so Stop can close
+// them
+func (h *FTPHandler) track(conn net.Conn, open bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if open {
+		h.conns[conn] = struct{}{}
+	} else {
+		delete(h.conns, conn)
+	}
+}
+
+// TemplateEnv returns the clock and random source of the mock's templates,
+// nil before Start
+func (h *FTPHandler) TemplateEnv() *template.Env {
+	return h.env
+}
+
+func (h *FTPHandler) Stop() error {
+	if h.listener == nil {
+		return nil
+	}
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+	}
+	h.logger.Info("🛑 Stopping FTP server")
+	err := h.listener.Close()
+
+	h.mu.Lock()
+	for conn := range h.conns {
+		conn.Close()
+	}
+	h.mu.Unlock()
+
+	if h.tree != nil {
+		if cerr := h.tree.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Operations returns the last 100 client operations recorded since Start
+func (h *FTPHandler) Operations() []SFTPOperation {
+	if h.events == nil {
+		return nil
+	}
+	return h.events.operations()
+}
+
+// Uploads returns the last 100 files uploaded by clients since Start
+func (h *FTPHandler) Uploads() []SFTPUpload {
+	if h.events == nil {
+		return nil
+	}
+	return h.events.uploadList()
+}
+
+// ReadFile returns the content of a file in the mock's root
+func (h *FTPHandler) ReadFile(name string) ([]byte, error) {
+	if h.tree == nil {
+		return nil, os.ErrNotExist
+	}
+	return readTreeFile(h.tree, name)
+}
+
+// loadFTPCertificate reads the configured certificate, or creates a
+// self-signed one for localhost that lives as long as the mock
+func loadFTPCertificate(cfg *schema.FTPConfig, baseDir string) (tls.Certificate, error) {
+	if cfg.CertFile != "" {
+		certFile, keyFile := cfg.CertFile, cfg.KeyFile
+		if baseDir != "" {
+			if !filepath.IsAbs(certFile) {
+				certFile = filepath.Join(baseDir, certFile)
+			}
+			if !filepath.IsAbs(keyFile) {
+				keyFile = filepath.Join(baseDir, keyFile)
+			}
+		}
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "localhost", Organization: []string{"UseKuro"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
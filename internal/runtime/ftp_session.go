@@ -0,0 +1,617 @@
+package runtime
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// dataTimeout bounds how long a transfer waits for the client to open the
+// passive data connection
+const dataTimeout = 10 * time.Second
+
+// ftpSession is the control connection of one FTP client
+type ftpSession struct {
+	h      *FTPHandler
+	conn   net.Conn
+	r      *bufio.Reader
+	logger *logrus.Entry
+
+	secure  bool // control channel encrypted
+	private bool // PROT P, data channels encrypted
+
+	user       string
+	fs         *sftpFS // set once logged in
+	cwd        string
+	pasv       net.Listener
+	rest       int64
+	renameFrom string
+}
+
+func newFTPSession(h *FTPHandler, conn net.Conn) *ftpSession {
+	return &ftpSession{
+		h:      h,
+		conn:   conn,
+		r:      bufio.NewReader(conn),
+		logger: h.logger.WithField("remote", conn.RemoteAddr().String()),
+		secure: h.tlsMode() == "implicit",
+		cwd:    "/",
+	}
+}
+
+func (s *ftpSession) serve() {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.WithField("panic", r).Error("💥 Panic recovered in FTP session")
+		}
+		s.closePassive()
+		s.conn.Close()
+		s.logger.Info("👋 FTP connection closed")
+	}()
+	s.logger.Info("📥 FTP connection opened")
+
+	s.reply(220, s.welcome())
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		cmd = strings.ToUpper(cmd)
+
+		logged := arg
+		if cmd == "PASS" {
+			logged = "****"
+		}
+		s.logger.WithFields(logrus.Fields{"command": cmd, "arg": logged}).Debug("ftp command")
+
+		if !s.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+func (s *ftpSession) welcome() string {
+	msg := s.h.cfg.Welcome
+	if msg == "" {
+		return "UseKuro FTP server ready."
+	}
+	tpl, err := template.NewRuntimeEnv(template.MergeContext(nil, nil, contextVariables(s.h.def)), s.h.registry, s.h.env)
+	if err != nil {
+		return msg
+	}
+	out, err := tpl.Render("ftp.welcome", msg)
+	if err != nil {
+		s.logger.WithError(err).Warn("⚠️ failed to render ftp.welcome")
+		return msg
+	}
+	return strings.Join(strings.Fields(out), " ")
+}
+
+func (s *ftpSession) reply(code int, msg string) {
+	fmt.Fprintf(s.conn, "%d %s\r\n", code, msg)
+}
+
+// replyLines sends a multi-line reply
+func (s *ftpSession) replyLines(code int, first string, lines []string, last string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d-%s\r\n", code, first)
+	for _, l := range lines {
+		fmt.Fprintf(&b, " %s\r\n", l)
+	}
+	fmt.Fprintf(&b, "%d %s\r\n", code, last)
+	io.WriteString(s.conn, b.String())
+}
+
+// replyError reports a failed file operation
+func (s *ftpSession) replyError(err error) {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		s.reply(552, "Exceeded storage allocation.")
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission):
+		s.reply(550, fileErrorText(err)+".")
+	default:
+		s.reply(451, "Requested action aborted: "+fileErrorText(err)+".")
+	}
+}
+
+// path resolves a client path against the working directory
+func (s *ftpSession) path(arg string) string {
+	if arg == "" {
+		return s.cwd
+	}
+	if path.IsAbs(arg) {
+		return cleanName(arg)
+	}
+	return cleanName(path.Join(s.cwd, arg))
+}
+
+// handle runs one command and reports whether the session goes on
+func (s *ftpSession) handle(cmd, arg string) bool {
+	switch cmd {
+	case "QUIT":
+		s.reply(221, "Goodbye.")
+		return false
+	case "NOOP":
+		s.reply(200, "OK.")
+		return true
+	case "SYST":
+		s.reply(215, "UNIX Type: L8")
+		return true
+	case "FEAT":
+		features := []string{"EPSV", "PASV", "MDTM", "SIZE", "REST STREAM", "UTF8"}
+		if s.h.tlsConfig != nil {
+			features = append(features, "AUTH TLS", "PBSZ", "PROT")
+		}
+		s.replyLines(211, "Features:", features, "End")
+		return true
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			s.reply(200, "Always in UTF8 mode.")
+		} else {
+			s.reply(501, "Option not understood.")
+		}
+		return true
+	case "AUTH":
+		return s.handleAuth(arg)
+	case "PBSZ":
+		s.reply(200, "PBSZ=0")
+		return true
+	case "PROT":
+		s.handleProt(arg)
+		return true
+	case "USER":
+		if s.h.tlsRequired() && !s.secure {
+			s.reply(530, "Login requires TLS, use AUTH TLS first.")
+			return true
+		}
+		s.user, s.fs = arg, nil
+		s.reply(331, "Password required for "+arg+".")
+		return true
+	case "PASS":
+		s.handlePass(arg)
+		return true
+	}
+
+	if s.fs == nil {
+		s.reply(530, "Please login with USER and PASS.")
+		return true
+	}
+
+	switch cmd {
+	case "PWD", "XPWD":
+		s.reply(257, fmt.Sprintf("%q is the current directory.", s.cwd))
+	case "CWD", "XCWD":
+		s.changeDir(s.path(arg))
+	case "CDUP", "XCUP":
+		s.changeDir(path.Dir(s.cwd))
+	case "TYPE":
+		switch strings.ToUpper(strings.Fields(arg + " ")[0]) {
+		case "A":
+			s.reply(200, "Switching to ASCII mode.")
+		case "I", "L":
+			s.reply(200, "Switching to Binary mode.")
+		default:
+			s.reply(504, "Unsupported type.")
+		}
+	case "MODE":
+		s.replyIf(strings.EqualFold(arg, "S"), 200, "Mode set to S.", 504, "Unsupported mode.")
+	case "STRU":
+		s.replyIf(strings.EqualFold(arg, "F"), 200, "Structure set to F.", 504, "Unsupported structure.")
+	case "PASV":
+		s.handlePasv(false)
+	case "EPSV":
+		if strings.EqualFold(arg, "ALL") {
+			s.reply(200, "EPSV ALL ok.")
+		} else {
+			s.handlePasv(true)
+		}
+	case "PORT", "EPRT":
+		s.reply(502, "Active mode is not supported, use PASV or EPSV.")
+	case "LIST", "NLST":
+		s.handleList(cmd == "NLST", arg)
+	case "RETR":
+		s.handleRetr(s.path(arg))
+	case "STOR", "APPE":
+		s.handleStor(s.path(arg), cmd == "APPE")
+	case "REST":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			s.reply(501, "Invalid restart position.")
+			break
+		}
+		s.rest = n
+		s.reply(350, fmt.Sprintf("Restarting at %d. Send STORE or RETRIEVE.", n))
+	case "SIZE", "MDTM":
+		info, err := s.fs.stat(s.path(arg))
+		if err != nil || info.IsDir() {
+			s.reply(550, "Could not get file information.")
+			break
+		}
+		if cmd == "SIZE" {
+			s.reply(213, strconv.FormatInt(info.Size(), 10))
+		} else {
+			s.reply(213, info.ModTime().UTC().Format("20060102150405"))
+		}
+	case "DELE":
+		s.fileCmd("Remove", s.path(arg), 250, "File deleted.")
+	case "RMD", "XRMD":
+		s.fileCmd("Rmdir", s.path(arg), 250, "Directory removed.")
+	case "MKD", "XMKD":
+		name := s.path(arg)
+		s.fileCmd("Mkdir", name, 257, fmt.Sprintf("%q created.", name))
+	case "RNFR":
+		name := s.path(arg)
+		if _, err := s.fs.stat(name); err != nil {
+			s.replyError(err)
+			break
+		}
+		s.renameFrom = name
+		s.reply(350, "Ready for RNTO.")
+	case "RNTO":
+		if s.renameFrom == "" {
+			s.reply(503, "Send RNFR first.")
+			break
+		}
+		r := sftp.NewRequest("Rename", s.renameFrom)
+		r.Target = s.path(arg)
+		s.renameFrom = ""
+		if err := s.fs.PosixRename(r); err != nil {
+			s.replyError(err)
+			break
+		}
+		s.reply(250, "Rename successful.")
+	case "ABOR":
+		s.reply(226, "No transfer to abort.")
+	default:
+		s.reply(502, "Command not implemented.")
+	}
+	return true
+}
+
+func (s *ftpSession) replyIf(ok bool, okCode int, okMsg string, failCode int, failMsg string) {
+	if ok {
+		s.reply(okCode, okMsg)
+	} else {
+		s.reply(failCode, failMsg)
+	}
+}
+
+// handleAuth upgrades the control connection to TLS (explicit FTPS)
+func (s *ftpSession) handleAuth(arg string) bool {
+	switch {
+	case s.h.tlsConfig == nil:
+		s.reply(502, "TLS is not enabled on this server.")
+		return true
+	case s.secure:
+		s.reply(503, "Already using TLS.")
+		return true
+	}
+	switch strings.ToUpper(arg) {
+	case "TLS", "TLS-C", "SSL":
+	default:
+		s.reply(504, "Unsupported security mechanism.")
+		return true
+	}
+
+	s.reply(234, "AUTH "+strings.ToUpper(arg)+" successful.")
+	conn := tls.Server(s.conn, s.h.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		s.logger.WithError(err).Warn("⚠️ FTPS handshake failed")
+		return false
+	}
+	s.conn, s.r, s.secure = conn, bufio.NewReader(conn), true
+	s.user, s.fs = "", nil
+	s.logger.Info("🔒 Control connection secured")
+	return true
+}
+
+func (s *ftpSession) handleProt(arg string) {
+	switch strings.ToUpper(arg) {
+	case "P":
+		if !s.secure {
+			s.reply(503, "PROT P requires AUTH TLS.")
+			return
+		}
+		s.private = true
+		s.reply(200, "Protection level set to P.")
+	case "C":
+		s.private = false
+		s.reply(200, "Protection level set to C.")
+	default:
+		s.reply(504, "Unsupported protection level.")
+	}
+}
+
+func (s *ftpSession) handlePass(pass string) {
+	if s.user == "" {
+		s.reply(503, "Login with USER first.")
+		return
+	}
+	if err := s.h.auth.checkPassword(s.user, []byte(pass)); err != nil {
+		s.reply(530, "Login incorrect.")
+		return
+	}
+	s.fs = newSFTPFS(s.h.tree, s.h.policy, s.h.events, s.h.auth, s.user)
+	s.cwd = "/"
+	s.logger = s.logger.WithField("user", s.user)
+	s.reply(230, "User "+s.user+" logged in.")
+}
+
+func (s *ftpSession) changeDir(name string) {
+	info, err := s.fs.stat(name)
+	if err != nil || !info.IsDir() {
+		s.reply(550, "Failed to change directory.")
+		return
+	}
+	s.cwd = name
+	s.reply(250, "Directory successfully changed.")
+}
+
+// fileCmd runs a command through the SFTP handlers, so it gets the same
+// policy checks and recording
+func (s *ftpSession) fileCmd(method, name string, code int, msg string) {
+	if err := s.fs.Filecmd(sftp.NewRequest(method, name)); err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(code, msg)
+}
+
+// ---------------------------------------------------------------------------
+// Data connections
+// ---------------------------------------------------------------------------
+
+func (s *ftpSession) handlePasv(extended bool) {
+	s.closePassive()
+	l, err := s.listenPassive()
+	if err != nil {
+		s.logger.WithError(err).Warn("⚠️ Could not open passive port")
+		s.reply(425, "Cannot open passive connection.")
+		return
+	}
+	s.pasv = l
+	port := l.Addr().(*net.TCPAddr).Port
+
+	if extended {
+		s.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|).", port))
+		return
+	}
+	ip := net.ParseIP(s.h.cfg.PublicHost).To4()
+	if ip == nil {
+		if addr, ok := s.conn.LocalAddr().(*net.TCPAddr); ok {
+			ip = addr.IP.To4()
+		}
+	}
+	if ip == nil {
+		ip = net.IPv4(127, 0, 0, 1).To4()
+	}
+	s.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d).", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff))
+}
+
+// listenPassive opens a data port, within ftp.passivePorts when configured
+func (s *ftpSession) listenPassive() (net.Listener, error) {
+	if s.h.pasvMin == 0 {
+		return net.Listen("tcp", ":0")
+	}
+	var err error
+	for port := s.h.pasvMin; port <= s.h.pasvMax; port++ {
+		var l net.Listener
+		if l, err = net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
+			return l, nil
+		}
+	}
+	return nil, err
+}
+
+func (s *ftpSession) closePassive() {
+	if s.pasv != nil {
+		s.pasv.Close()
+		s.pasv = nil
+	}
+}
+
+// transfer opens the data connection announced by PASV or EPSV and runs fn
+// on it, replying with the outcome
+func (s *ftpSession) transfer(fn func(net.Conn) error) {
+	if s.pasv == nil {
+		s.reply(425, "Use PASV or EPSV first.")
+		return
+	}
+	if s.h.tlsRequired() && !s.private {
+		s.reply(521, "Data connections must be encrypted, use PROT P.")
+		return
+	}
+
+	s.reply(150, "Opening data connection.")
+	conn, err := s.acceptData()
+	if err != nil {
+		s.logger.WithError(err).Warn("⚠️ Data connection failed")
+		s.reply(425, "Cannot open data connection.")
+		return
+	}
+	s.h.track(conn, true)
+	err = fn(conn)
+	conn.Close()
+	s.h.track(conn, false)
+
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(226, "Transfer complete.")
+}
+
+func (s *ftpSession) acceptData() (net.Conn, error) {
+	l := s.pasv
+	s.pasv = nil
+	defer l.Close()
+
+	if tl, ok := l.(*net.TCPListener); ok {
+		tl.SetDeadline(time.Now().Add(dataTimeout))
+	}
+	// Only the client's own host may take the transfer, not whoever
+	// connects to the port first
+	var conn net.Conn
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if sameHost(c.RemoteAddr(), s.conn.RemoteAddr()) {
+			conn = c
+			break
+		}
+		s.logger.WithField("peer", c.RemoteAddr().String()).Warn("⚠️ Data connection from another host rejected")
+		c.Close()
+	}
+	if !s.private {
+		return conn, nil
+	}
+	tconn := tls.Server(conn, s.h.tlsConfig)
+	tconn.SetDeadline(time.Now().Add(dataTimeout))
+	if err := tconn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tconn.SetDeadline(time.Time{})
+	return tconn, nil
+}
+
+// sameHost reports whether two addresses have the same IP
+func sameHost(a, b net.Addr) bool {
+	hostA, _, errA := net.SplitHostPort(a.String())
+	hostB, _, errB := net.SplitHostPort(b.String())
+	if errA != nil || errB != nil {
+		return false
+	}
+	ipA, ipB := net.ParseIP(hostA), net.ParseIP(hostB)
+	return ipA != nil && ipA.Equal(ipB)
+}
+
+func (s *ftpSession) handleList(namesOnly bool, arg string) {
+	// Clients commonly send ls options such as "-la"
+	var target string
+	for _, f := range strings.Fields(arg) {
+		if !strings.HasPrefix(f, "-") {
+			target = f
+		}
+	}
+	name := s.path(target)
+
+	info, err := s.fs.stat(name)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	entries := []os.FileInfo{info}
+	if info.IsDir() {
+		if entries, err = s.fs.list(name); err != nil {
+			s.replyError(err)
+			return
+		}
+	}
+
+	s.transfer(func(conn net.Conn) error {
+		w := bufio.NewWriter(conn)
+		for _, e := range entries {
+			if namesOnly {
+				fmt.Fprintf(w, "%s\r\n", e.Name())
+			} else {
+				fmt.Fprintf(w, "%s\r\n", listLine(e))
+			}
+		}
+		return w.Flush()
+	})
+}
+
+// listLine formats an entry as "ls -l" does, which is what clients parse
+func listLine(info os.FileInfo) string {
+	owner, group := "ftp", "ftp"
+	if o, ok := info.(ownedInfo); ok {
+		owner, group = strconv.Itoa(int(o.uid)), strconv.Itoa(int(o.gid))
+	}
+	mtime := info.ModTime()
+	date := mtime.Format("Jan _2 15:04")
+	if age := time.Since(mtime); age > 180*24*time.Hour || age < -time.Hour {
+		date = mtime.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 %-8s %-8s %12d %s %s", info.Mode().String(), owner, group, info.Size(), date, info.Name())
+}
+
+func (s *ftpSession) handleRetr(name string) {
+	offset := s.rest
+	s.rest = 0
+
+	info, err := s.fs.stat(name)
+	if err == nil && info.IsDir() {
+		s.reply(550, "Not a regular file.")
+		return
+	}
+	f, err := s.fs.open(name, os.O_RDONLY)
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	defer f.Close()
+	if info, err = s.fs.stat(name); err != nil {
+		s.replyError(err)
+		return
+	}
+
+	s.transfer(func(conn net.Conn) error {
+		if offset >= info.Size() {
+			return nil
+		}
+		_, err := io.Copy(conn, io.NewSectionReader(f, offset, info.Size()-offset))
+		return err
+	})
+}
+
+func (s *ftpSession) handleStor(name string, appendMode bool) {
+	offset := s.rest
+	s.rest = 0
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if !appendMode && offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	if appendMode {
+		if info, err := s.fs.stat(name); err == nil {
+			offset = info.Size()
+		}
+	}
+	// Refuse early, the file itself is only opened once the client connects
+	if err := s.fs.checkOpen(s.fs.real(name), flag); err != nil {
+		s.fs.events.record(SFTPOperation{User: s.user, Op: "open", Path: s.fs.real(name), Flags: openMode(flag)}, err)
+		s.replyError(err)
+		return
+	}
+
+	s.transfer(func(conn net.Conn) error {
+		f, err := s.fs.open(name, flag)
+		if err != nil {
+			return err
+		}
+		w := &offsetWriter{w: f, off: offset}
+		_, err = io.Copy(w, conn)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
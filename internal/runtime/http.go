@@ -3,20 +3,23 @@ package runtime
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/usekuro/usekuro/internal/extensions"
 	"github.com/usekuro/usekuro/internal/schema"
 	"github.com/usekuro/usekuro/internal/template"
 )
 
 type HTTPHandler struct {
 	server *http.Server
+	env    *template.Env
 	logger *logrus.Entry
+
+	unsubscribe func()
 }
 
 func NewHTTPHandler() *HTTPHandler {
@@ -28,17 +31,15 @@ func NewHTTPHandler() *HTTPHandler {
 func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 	h.logger.Infof("starting HTTP mock on port %d", def.Port)
 
-	// Single extensions registry for all routes of this mock
-	registry := extensions.NewRegistry()
-	for _, src := range def.Import {
-		code, err := extensions.LoadKurof(src)
-		if err != nil {
-			h.logger.WithField("file", src).WithError(err).Warn("failed to load .kurof file")
-			continue
-		}
-		registry.Register(src, code, src)
-		h.logger.WithField("file", src).Info("loaded .kurof file")
+	env, err := newTemplateEnv(def)
+	if err != nil {
+		return err
 	}
+	h.env = env
+
+	// Single extensions registry for all routes of this mock
+	registry := loadExtensions(def, h.logger)
+	compileTemplates(def, registry, env, h.logger)
 
 	mux := http.NewServeMux()
 
@@ -81,13 +82,10 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 	}
 
 	registeredPaths := make(map[string]bool)
-	routeHandlers := make(map[string][]schema.Route)
+	routeHandlers := make(map[string][]int) // path -> indexes of its routes
 
 	// Create initial template runtime for path processing
-	contextVars := def.Context.Variables
-	if contextVars == nil {
-		contextVars = make(map[string]interface{})
-	}
+	contextVars := contextVariables(def)
 
 	// Create full context structure that matches .kuro file expectations
 	// Template expects .context.apiVersion, so we need to create a flattened structure
@@ -105,19 +103,19 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 	}
 	fullContext["context"] = contextStruct
 
-	initialTpl, err := template.NewRuntime(fullContext, registry)
+	initialTpl, err := template.NewRuntimeEnv(fullContext, registry, env)
 	if err != nil {
 		return fmt.Errorf("failed to create template runtime: %w", err)
 	}
 
 	// Group routes by path, processing templates in paths
-	for _, route := range def.Routes {
+	for i, route := range def.Routes {
 		routePath := route.Path
 
 		// Process template in route path if it contains template syntax
 		if strings.Contains(routePath, "{{") {
 
-			processedPath, err := initialTpl.Render("route-path", routePath)
+			processedPath, err := initialTpl.Render(fmt.Sprintf("routes[%d].path", i), routePath)
 			if err != nil {
 				h.logger.WithError(err).Warnf("failed to process template in path %s, using original", routePath)
 			} else {
@@ -126,7 +124,7 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 			}
 		}
 
-		routeHandlers[routePath] = append(routeHandlers[routePath], route)
+		routeHandlers[routePath] = append(routeHandlers[routePath], i)
 	}
 
 	// Register each unique path once
@@ -145,11 +143,14 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
 			// Find the matching route for this method
 			var routeCopy schema.Route
+			var field string
 			found := false
-			for _, rt := range routesCopy {
+			for _, i := range routesCopy {
+				rt := def.Routes[i]
 				// Empty rt.Method = wildcard (any method)
 				if strings.EqualFold(rt.Method, r.Method) || rt.Method == "" {
 					routeCopy = rt
+					field = fmt.Sprintf("routes[%d]", i)
 					found = true
 					break
 				}
@@ -173,16 +174,10 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 				}
 			}
 
-			// Prepare context with request data
-			var contextVars map[string]interface{}
-			if def.Context != nil {
-				contextVars = def.Context.Variables
-			}
-
 			// Merge all contexts with priority: input > route params (nil here) > context vars
 			ctx := template.MergeContext(inputVars, nil, contextVars)
 
-			tpl, err := template.NewRuntime(ctx, registry)
+			tpl, err := template.NewRuntimeEnv(ctx, registry, env)
 			if err != nil {
 				h.logger.WithError(err).Error("template runtime error")
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -191,9 +186,14 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 
 			// Dynamic headers with error handling
 			for k, v := range routeCopy.Response.Headers {
-				hdr, err := tpl.Render("hdr", v)
+				hdr, err := tpl.Render(field+".response.headers."+k, v)
+				var limit *template.LimitError
+				if errors.As(err, &limit) {
+					h.respondLimit(w, r, limit, err)
+					return
+				}
 				if err != nil {
-					h.logger.WithError(err).Warnf("failed to render header %s, using raw value", k)
+					h.logger.WithFields(diagnosticFields(err)).WithError(err).Warnf("failed to render header %s, using raw value", k)
 					hdr = v // fallback to raw value
 				}
 				h.logger.WithFields(logrus.Fields{
@@ -204,10 +204,15 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 			}
 
 			// Dynamic body with error handling
-			body, err := tpl.Render("body", routeCopy.Response.Body)
+			body, err := tpl.Render(field+".response.body", routeCopy.Response.Body)
+			var limit *template.LimitError
+			if errors.As(err, &limit) {
+				h.respondLimit(w, r, limit, err)
+				return
+			}
 			if err != nil {
-				h.logger.WithError(err).Error("failed to render response body")
-				body = `{"error": "template rendering failed"}`
+				h.logger.WithFields(diagnosticFields(err)).WithError(err).Error("failed to render response body")
+				body = templateErrorBody(err)
 				w.Header().Set("Content-Type", "application/json")
 			}
 
@@ -219,6 +224,10 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 
 			w.WriteHeader(routeCopy.Response.Status)
 			_, _ = w.Write([]byte(body))
+
+			if routeCopy.Publish != nil && err == nil {
+				publishEvent(tpl, field+".publish", routeCopy.Publish, env, 0, h.logger)
+			}
 		})
 	}
 
@@ -246,10 +255,51 @@ func (h *HTTPHandler) Start(def *schema.MockDefinition) error {
 		h.logger.Info("HTTP server started successfully")
 	}
 
+	h.unsubscribe = subscribe(def, registry, env, h.logger, nil)
 	return nil
 }
 
+// respondLimit answers a request whose templates the mock's limits stopped,
+// err being the render's error
+func (h *HTTPHandler) respondLimit(w http.ResponseWriter, r *http.Request, limit *template.LimitError, err error) {
+	h.logger.WithFields(diagnosticFields(err)).WithFields(logrus.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"limit":  limit.Limit,
+	}).WithError(err).Error("❌ template stopped by the mock's limits")
+	resp := map[string]any{"error": limit.Error()}
+	if d := diagnosticOf(err); DevMode && d != nil {
+		resp["diagnostic"] = d
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// templateErrorBody is the body of a response whose template failed, with
+// the diagnostic in dev mode
+func templateErrorBody(err error) string {
+	d := diagnosticOf(err)
+	if !DevMode || d == nil {
+		return `{"error": "template rendering failed"}`
+	}
+	out, jerr := json.Marshal(map[string]any{"error": "template rendering failed", "diagnostic": d})
+	if jerr != nil {
+		return `{"error": "template rendering failed"}`
+	}
+	return string(out)
+}
+
+// TemplateEnv returns the clock and random source of the mock's templates,
+// nil before Start
+func (h *HTTPHandler) TemplateEnv() *template.Env {
+	return h.env
+}
+
 func (h *HTTPHandler) Stop() error {
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+	}
 	if h.server != nil {
 		h.logger.Info("stopping HTTP mock")
 
@@ -0,0 +1,394 @@
+package runtime
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// hub tracks the live connections of a TCP or WS mock so that condition
+// actions can fan messages out to every client, a room or a single peer
+type hub struct {
+	mu    sync.RWMutex
+	peers map[string]*peer
+}
+
+// peer is a single client connection registered in a hub
+type peer struct {
+	id      string
+	mu      sync.Mutex // guards session and rooms
+	writeMu sync.Mutex // serialises writes to the connection
+	session map[string]any
+	rooms   map[string]bool
+	write   func(data []byte, binary bool) error
+	close   func() error
+}
+
+func newHub() *hub {
+	return &hub{peers: make(map[string]*peer)}
+}
+
+// add registers a connection; write must deliver one message to the client
+// and close must terminate the connection
+func (h *hub) add(write func(data []byte, binary bool) error, close func() error) *peer {
+	p := &peer{
+		id:    uuid.NewString(),
+		rooms: make(map[string]bool),
+		write: write,
+		close: close,
+	}
+	p.session = map[string]any{"id": p.id, "rooms": []string{}}
+
+	h.mu.Lock()
+	h.peers[p.id] = p
+	h.mu.Unlock()
+	return p
+}
+
+func (h *hub) remove(p *peer) {
+	h.mu.Lock()
+	delete(h.peers, p.id)
+	h.mu.Unlock()
+}
+
+// closeAll terminates every registered connection
+func (h *hub) closeAll() {
+	h.mu.RLock()
+	peers := make([]*peer, 0, len(h.peers))
+	for _, p := range h.peers {
+		peers = append(peers, p)
+	}
+	h.mu.RUnlock()
+
+	for _, p := range peers {
+		p.close()
+	}
+}
+
+// send writes a message to the peer, serialising concurrent writers
+func (p *peer) send(data []byte, binary bool) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.write(data, binary)
+}
+
+func (p *peer) set(key string, value any) {
+	p.mu.Lock()
+	p.session[key] = value
+	p.mu.Unlock()
+}
+
+func (p *peer) join(room string) {
+	p.mu.Lock()
+	p.rooms[room] = true
+	p.session["rooms"] = p.roomList()
+	p.mu.Unlock()
+}
+
+func (p *peer) leave(room string) {
+	p.mu.Lock()
+	delete(p.rooms, room)
+	p.session["rooms"] = p.roomList()
+	p.mu.Unlock()
+}
+
+// roomList must be called with p.mu held
+func (p *peer) roomList() []string {
+	rooms := make([]string, 0, len(p.rooms))
+	for r := range p.rooms {
+		rooms = append(rooms, r)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// matches reports whether the peer is in room (if any) and carries every
+// session attribute in filter
+func (p *peer) matches(room string, filter map[string]string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if room != "" && !p.rooms[room] {
+		return false
+	}
+	for k, v := range filter {
+		if fmt.Sprint(p.session[k]) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// broadcast delivers msg to every peer selected by room and filter and
+// returns how many peers received it
+func (h *hub) broadcast(from *peer, msg, room string, filter map[string]string, excludeSelf bool) int {
+	h.mu.RLock()
+	targets := make([]*peer, 0, len(h.peers))
+	for _, p := range h.peers {
+		if excludeSelf && p == from {
+			continue
+		}
+		if p.matches(room, filter) {
+			targets = append(targets, p)
+		}
+	}
+	h.mu.RUnlock()
+
+	sent := 0
+	for _, p := range targets {
+		if err := p.send([]byte(msg), false); err == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// reply is what a connection sends back after a message was evaluated
+type reply struct {
+	data   []byte
+	binary bool
+	close  *closeRequest
+	stderr []byte // ssh only
+	exit   int    // ssh only
+}
+
+// closeRequest is a rendered schema.CloseAction
+type closeRequest struct {
+	code   int
+	reason string
+	abrupt bool
+}
+
+// templateFailed logs a failed render with its place in the mock and
+// reports whether the mock's limits stopped it, which fails the message
+func templateFailed(err error, logger *logrus.Entry) bool {
+	logTemplateError(err, logger)
+	var limit *template.LimitError
+	return errors.As(err, &limit)
+}
+
+// templateError is the reply to a message whose template failed: the
+// diagnostic in dev mode, a generic error otherwise
+func templateError(err error) *reply {
+	if DevMode && err != nil {
+		return &reply{data: []byte("template error: " + err.Error())}
+	}
+	return &reply{data: []byte("template error")}
+}
+
+// handleMessage evaluates the onMessage rules at field for a message
+// received by p, applies the actions of the matched rule and returns the
+// direct reply; nil means neither a rule nor the fallback applied
+func (h *hub) handleMessage(p *peer, raw string, on *schema.OnMessage, field string, def *schema.MockDefinition, registry *extensions.Registry, env *template.Env, logger *logrus.Entry) *reply {
+	input, valid := messageInput(raw, on)
+	if !valid {
+		logger.WithField("input", raw).Debug("message is not valid JSON")
+	}
+	ctx := template.MergeContext(nil, p.session, contextVariables(def))
+	ctx["input"] = input
+
+	tpl, err := template.NewRuntimeEnv(ctx, registry, env)
+	if err != nil {
+		logger.WithError(err).Error("template runtime creation failed")
+		return templateError(err)
+	}
+
+	for i, cond := range on.Conditions {
+		if len(cond.When) > 0 && (!valid || !matchJSON(input, cond.When)) {
+			continue
+		}
+		rule := fmt.Sprintf("%s.conditions[%d]", field, i)
+
+		// A rule with JSON conditions and no template condition matches on
+		// the JSON conditions alone
+		if cond.If != "" || len(cond.When) == 0 {
+			result, err := tpl.Render(rule+".if", cond.If)
+			if err != nil && templateFailed(err, logger) {
+				return templateError(err)
+			}
+			logger.WithFields(logrus.Fields{
+				"condition": i,
+				"if":        cond.If,
+				"result":    result,
+			}).Debug("evaluated condition")
+
+			if err != nil || result != "true" {
+				continue
+			}
+		}
+
+		h.applyActions(p, tpl, env, rule, cond, logger)
+
+		var r reply
+		if cond.Respond != "" {
+			resp, err := tpl.Render(rule+".respond", cond.Respond)
+			if err != nil {
+				templateFailed(err, logger)
+				return templateError(err)
+			}
+			logger.WithField("response", resp).Info("sending matched response")
+
+			data, binary, err := encodeResponse(resp, cond.Encoding)
+			if err != nil {
+				logger.WithError(err).Error("failed to decode binary response")
+				data, binary = []byte("encoding error"), false
+			}
+			r.data, r.binary = data, binary
+		}
+		if c := cond.Close; c != nil {
+			reason, err := tpl.Render(rule+".close.reason", c.Reason)
+			if err != nil {
+				templateFailed(err, logger)
+			}
+			r.close = &closeRequest{code: c.Code, reason: reason, abrupt: c.Abrupt}
+		}
+		if cond.Stderr != "" {
+			stderr, err := tpl.Render(rule+".stderr", cond.Stderr)
+			if err != nil {
+				templateFailed(err, logger)
+			}
+			r.stderr = []byte(stderr)
+		}
+		if cond.Exit != nil {
+			r.exit = *cond.Exit
+		}
+		return &r
+	}
+
+	if on.Else != "" {
+		resp, err := tpl.Render(field+".else", on.Else)
+		if err != nil {
+			templateFailed(err, logger)
+			return templateError(err)
+		}
+		logger.WithField("response", resp).Info("sending fallback response")
+		return &reply{data: []byte(resp)}
+	}
+	return nil
+}
+
+// encodeResponse turns a rendered response into the bytes to send; base64
+// and hex responses are decoded and flagged as binary
+func encodeResponse(resp, encoding string) ([]byte, bool, error) {
+	switch encoding {
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(resp))
+		return data, true, err
+	case "hex":
+		data, err := hex.DecodeString(strings.Join(strings.Fields(resp), ""))
+		return data, true, err
+	default:
+		return []byte(resp), false, nil
+	}
+}
+
+// binaryInput presents a binary frame to the rules as configured by mode
+func binaryInput(data []byte, mode string) string {
+	switch mode {
+	case "base64":
+		return base64.StdEncoding.EncodeToString(data)
+	case "hex":
+		return hex.EncodeToString(data)
+	default:
+		return string(data)
+	}
+}
+
+// applyActions runs the session, room, broadcast and publish actions of the
+// matched rule at field. An action whose template fails is skipped.
+func (h *hub) applyActions(p *peer, tpl *template.Runtime, env *template.Env, field string, cond schema.OnMessageRule, logger *logrus.Entry) {
+	render := func(name, raw string) (string, bool) {
+		out, err := tpl.Render(field+"."+name, raw)
+		if err != nil {
+			templateFailed(err, logger)
+			return "", false
+		}
+		return out, true
+	}
+
+	for k, v := range cond.Set {
+		if val, ok := render("set."+k, v); ok {
+			p.set(k, val)
+		}
+	}
+
+	if cond.Join != "" {
+		if room, ok := render("join", cond.Join); ok && room != "" {
+			p.join(room)
+			logger.WithFields(logrus.Fields{"peer": p.id, "room": room}).Info("joined room")
+		}
+	}
+
+	if cond.Leave != "" {
+		if room, ok := render("leave", cond.Leave); ok && room != "" {
+			p.leave(room)
+			logger.WithFields(logrus.Fields{"peer": p.id, "room": room}).Info("left room")
+		}
+	}
+
+	if b := cond.Broadcast; b != nil {
+		if msg, filter, room, ok := renderBroadcast(tpl, field+".broadcast", b, logger); ok {
+			sent := h.broadcast(p, msg, room, filter, b.ExcludeSelf)
+			logger.WithFields(logrus.Fields{
+				"room":       room,
+				"recipients": sent,
+			}).Info("broadcast message")
+		}
+	}
+
+	if cond.Publish != nil {
+		publishEvent(tpl, field+".publish", cond.Publish, env, 0, logger)
+	}
+}
+
+// renderBroadcast renders the message, recipient filter and room of the
+// broadcast at field, reporting false when one of them fails
+func renderBroadcast(tpl *template.Runtime, field string, b *schema.Broadcast, logger *logrus.Entry) (string, map[string]string, string, bool) {
+	msg, err := tpl.Render(field+".message", b.Message)
+	if err != nil {
+		templateFailed(err, logger)
+		return "", nil, "", false
+	}
+	room, err := tpl.Render(field+".room", b.Room)
+	if err != nil {
+		templateFailed(err, logger)
+		return "", nil, "", false
+	}
+	filter := make(map[string]string, len(b.To))
+	for k, v := range b.To {
+		if filter[k], err = tpl.Render(field+".to."+k, v); err != nil {
+			templateFailed(err, logger)
+			return "", nil, "", false
+		}
+	}
+	return msg, filter, room, true
+}
+
+// reactBroadcast returns the reaction of a TCP or WS mock to an event: the
+// subscription's broadcast, sent to the connected clients
+func (h *hub) reactBroadcast(logger *logrus.Entry) func(int, schema.Subscription, *template.Runtime) {
+	return func(i int, sub schema.Subscription, tpl *template.Runtime) {
+		b := sub.Broadcast
+		if b == nil {
+			return
+		}
+		msg, filter, room, ok := renderBroadcast(tpl, fmt.Sprintf("subscribe[%d].broadcast", i), b, logger)
+		if !ok {
+			return
+		}
+		sent := h.broadcast(nil, msg, room, filter, false)
+		logger.WithFields(logrus.Fields{
+			"room":       room,
+			"recipients": sent,
+		}).Info("broadcast event")
+	}
+}
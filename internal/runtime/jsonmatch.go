@@ -0,0 +1,71 @@
+package runtime
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+
+	"github.com/usekuro/usekuro/internal/jsonpath"
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+// messageInput builds the .input value for a received message: the named
+// regex groups in the default mode, or the decoded document in json mode.
+// The second result is false when a json mode message is not valid JSON.
+func messageInput(raw string, on *schema.OnMessage) (any, bool) {
+	if on.Mode != "json" {
+		return extractVars(raw, on.Match), true
+	}
+	var doc any
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return raw, false
+	}
+	return doc, true
+}
+
+// matchJSON reports whether every condition holds for the decoded message
+func matchJSON(doc any, conds []schema.JSONCondition) bool {
+	for _, c := range conds {
+		val, found, err := jsonpath.Get(doc, c.Path)
+		if err != nil {
+			return false
+		}
+		if c.Exists != nil {
+			if *c.Exists != found {
+				return false
+			}
+			if !found {
+				continue
+			}
+		} else if !found {
+			return false
+		}
+		if c.Type != "" && jsonpath.TypeOf(val) != c.Type {
+			return false
+		}
+		if c.Equals != nil && !jsonEqual(val, c.Equals) {
+			return false
+		}
+		if c.Regex != "" {
+			s, ok := val.(string)
+			if !ok {
+				return false
+			}
+			if m, err := regexp.MatchString(c.Regex, s); err != nil || !m {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// jsonEqual compares two values by their JSON encoding so that numbers coming
+// from YAML (int) and from a JSON message (float64) compare equal
+func jsonEqual(a, b any) bool {
+	ab, err1 := json.Marshal(a)
+	bb, err2 := json.Marshal(b)
+	if err1 != nil || err2 != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return string(ab) == string(bb)
+}
@@ -0,0 +1,479 @@
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scpRequest is a parsed "scp -t" (sink, client uploads) or "scp -f"
+// (source, client downloads) command
+type scpRequest struct {
+	sink      bool
+	recursive bool
+	preserve  bool
+	targetDir bool // -d, the target must be a directory
+	paths     []string
+}
+
+// parseSCP recognises the commands an scp client runs on the server in
+// legacy (non-SFTP) mode, e.g. "scp -r -t -- /upload"
+func parseSCP(command string) (*scpRequest, bool) {
+	args := shellFields(command)
+	if len(args) < 2 || args[0] != "scp" {
+		return nil, false
+	}
+
+	req := &scpRequest{}
+	var to, from bool
+	i := 1
+	for ; i < len(args) && strings.HasPrefix(args[i], "-") && len(args[i]) > 1; i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		for _, c := range args[i][1:] {
+			switch c {
+			case 't':
+				to = true
+			case 'f':
+				from = true
+			case 'r':
+				req.recursive = true
+			case 'p':
+				req.preserve = true
+			case 'd':
+				req.targetDir = true
+			case 'v', 'q':
+			default:
+				return nil, false
+			}
+		}
+	}
+	if to == from {
+		return nil, false
+	}
+	req.sink = to
+	req.paths = args[i:]
+	if len(req.paths) == 0 || (to && len(req.paths) != 1) {
+		return nil, false
+	}
+	return req, true
+}
+
+// shellFields splits a command line as a POSIX shell would for plain words,
+// single and double quotes and backslash escapes
+func shellFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	inField := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '\\':
+			escaped, inField = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inField = c, true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// scpPath maps a path given to scp to the mock's root, where "~" and
+// relative paths refer to the root itself
+func scpPath(p string) string {
+	p = strings.TrimPrefix(p, "~")
+	return cleanName(p)
+}
+
+// scp runs the SCP protocol over the session channel against the same file
+// tree, policy and upload hooks as the SFTP subsystem
+func (s *sshSession) scp(req *scpRequest) {
+	t := &scpTransfer{
+		fs: newSFTPFS(s.h.tree, s.h.policy, s.h.events, s.h.auth, s.user),
+		r:  bufio.NewReader(s.channel),
+		w:  s.channel,
+	}
+
+	var err error
+	if req.sink {
+		err = t.sink(req)
+	} else {
+		err = t.source(req)
+	}
+
+	status := 0
+	if err != nil || t.failed {
+		status = 1
+	}
+	if err != nil {
+		s.h.logger.WithField("user", s.user).WithError(err).Warn("⚠️ SCP transfer aborted")
+	}
+	s.exit(status)
+}
+
+type scpTransfer struct {
+	fs     *sftpFS
+	r      *bufio.Reader
+	w      io.Writer
+	failed bool
+}
+
+func (t *scpTransfer) ack() error {
+	_, err := t.w.Write([]byte{0})
+	return err
+}
+
+// warn reports a per-file error; the transfer goes on but exits with 1
+func (t *scpTransfer) warn(name string, err error) error {
+	t.failed = true
+	_, werr := fmt.Fprintf(t.w, "\x01scp: %s: %s\n", name, fileErrorText(err))
+	return werr
+}
+
+// fatal reports a protocol error and ends the transfer
+func (t *scpTransfer) fatal(msg string) error {
+	fmt.Fprintf(t.w, "\x02scp: %s\n", msg)
+	return errors.New(msg)
+}
+
+// readAck waits for the peer to confirm the last message
+func (t *scpTransfer) readAck() error {
+	b, err := t.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	msg, _ := t.r.ReadString('\n')
+	return fmt.Errorf("client error: %s", strings.TrimSpace(msg))
+}
+
+// fileErrorText describes a file error the way command line tools print it
+func fileErrorText(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "No such file or directory"
+	case errors.Is(err, fs.ErrPermission):
+		return "Permission denied"
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// sink receives files from the client into the target path
+func (t *scpTransfer) sink(req *scpRequest) error {
+	target := scpPath(req.paths[0])
+	targetIsDir := false
+	if info, err := t.fs.stat(target); err == nil && info.IsDir() {
+		targetIsDir = true
+	} else if req.targetDir {
+		return t.fatal(fmt.Sprintf("%s: Not a directory", target))
+	}
+
+	if err := t.ack(); err != nil {
+		return err
+	}
+
+	var dirs []string // directories entered with D, innermost last
+	var times []time.Time
+	for {
+		line, err := t.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return t.fatal("unexpected empty line")
+		}
+
+		switch line[0] {
+		case '\x01', '\x02':
+			return fmt.Errorf("client error: %s", line[1:])
+		case 'T':
+			var mtime, atime int64
+			if _, err := fmt.Sscanf(line, "T%d 0 %d 0", &mtime, &atime); err != nil {
+				return t.fatal("mtime.sec not delimited")
+			}
+			times = []time.Time{time.Unix(atime, 0), time.Unix(mtime, 0)}
+			if err := t.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'E':
+			if len(dirs) == 0 {
+				return t.fatal("unexpected end of directory")
+			}
+			dirs = dirs[:len(dirs)-1]
+			if err := t.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+		default:
+			return t.fatal(fmt.Sprintf("protocol error: unexpected <%q>", line[0]))
+		}
+
+		mode, size, name, err := parseSCPHeader(line[1:])
+		if err != nil {
+			return t.fatal(err.Error())
+		}
+
+		var dest string
+		switch {
+		case len(dirs) > 0:
+			dest = path.Join(dirs[len(dirs)-1], name)
+		case targetIsDir:
+			dest = path.Join(target, name)
+		default:
+			dest = target
+		}
+
+		if line[0] == 'D' {
+			if !req.recursive {
+				return t.fatal("received directory without -r")
+			}
+			if err := t.mkdir(dest, mode); err != nil {
+				if err := t.warn(dest, err); err != nil {
+					return err
+				}
+				return errors.New("cannot create directory " + dest)
+			}
+			if req.preserve && times != nil {
+				t.fs.chtimes(dest, times[0], times[1])
+			}
+			times = nil
+			dirs = append(dirs, dest)
+			if err := t.ack(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := t.receive(dest, mode, size, times, req.preserve); err != nil {
+			return err
+		}
+		times = nil
+	}
+}
+
+// parseSCPHeader parses the "<mode> <size> <name>" part of a C or D line
+func parseSCPHeader(s string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(s, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", errors.New("protocol error: bad header")
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", errors.New("protocol error: bad mode")
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", errors.New("protocol error: size not delimited")
+	}
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("error: unexpected filename: %s", name)
+	}
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+func (t *scpTransfer) mkdir(name string, mode os.FileMode) error {
+	if info, err := t.fs.stat(name); err == nil {
+		if !info.IsDir() {
+			return errors.New("Not a directory")
+		}
+		return nil
+	}
+	if err := t.fs.mkdir(name); err != nil {
+		return err
+	}
+	return t.fs.chmod(name, mode|0700)
+}
+
+// receive reads one file of size bytes from the client into dest. Write
+// errors still consume the data so the transfer stays in sync.
+func (t *scpTransfer) receive(dest string, mode os.FileMode, size int64, times []time.Time, preserve bool) error {
+	f, err := t.fs.open(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return t.warn(dest, err)
+	}
+	if err := t.ack(); err != nil {
+		f.Close()
+		return err
+	}
+
+	w := &offsetWriter{w: f}
+	n, err := io.CopyN(w, t.r, size)
+	if n < size && w.err == nil {
+		// The connection failed before the whole file arrived
+		f.Close()
+		return err
+	}
+	if w.err != nil {
+		io.CopyN(io.Discard, t.r, size-n)
+	}
+	if err := t.readAck(); err != nil {
+		f.Close()
+		return err
+	}
+
+	werr := w.err
+	if err := f.Close(); werr == nil {
+		werr = err
+	}
+	if werr != nil {
+		return t.warn(dest, werr)
+	}
+
+	// Uploads may have been moved away by an onUpload rule
+	if _, err := t.fs.stat(dest); err == nil {
+		t.fs.chmod(dest, mode)
+		if preserve && times != nil {
+			t.fs.chtimes(dest, times[0], times[1])
+		}
+	}
+	return t.ack()
+}
+
+// offsetWriter writes sequentially to a WriterAt and keeps the first error,
+// accepting (and dropping) the remaining data afterwards
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+	err error
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// source sends the requested files to the client
+func (t *scpTransfer) source(req *scpRequest) error {
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	for _, p := range req.paths {
+		if err := t.send(scpPath(p), req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *scpTransfer) send(name string, req *scpRequest) error {
+	info, err := t.fs.stat(name)
+	if err != nil {
+		return t.warn(name, err)
+	}
+	if info.IsDir() && !req.recursive {
+		return t.warn(name, errors.New("not a regular file"))
+	}
+
+	if req.preserve {
+		mtime := info.ModTime().Unix()
+		if _, err := fmt.Fprintf(t.w, "T%d 0 %d 0\n", mtime, mtime); err != nil {
+			return err
+		}
+		if err := t.readAck(); err != nil {
+			return err
+		}
+	}
+
+	if info.IsDir() {
+		return t.sendDir(name, info, req)
+	}
+
+	f, err := t.fs.open(name, os.O_RDONLY)
+	if err != nil {
+		return t.warn(name, err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(t.w, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), path.Base(name)); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	if _, err := io.Copy(t.w, io.NewSectionReader(f, 0, info.Size())); err != nil {
+		return err
+	}
+	if err := t.ack(); err != nil {
+		return err
+	}
+	return t.readAck()
+}
+
+func (t *scpTransfer) sendDir(name string, info os.FileInfo, req *scpRequest) error {
+	entries, err := t.fs.list(name)
+	if err != nil {
+		return t.warn(name, err)
+	}
+
+	if _, err := fmt.Fprintf(t.w, "D%04o 0 %s\n", info.Mode().Perm(), path.Base(name)); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := t.send(path.Join(name, entry.Name()), req); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(t.w, "E\n"); err != nil {
+		return err
+	}
+	return t.readAck()
+}
@@ -2,82 +2,96 @@ package runtime
 
 import (
 	"fmt"
-	"io"
 	"net"
 	"os"
-	"path/filepath"
-	runtime2 "runtime"
+	"sync"
 
-	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/extensions"
 	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/template"
 	"golang.org/x/crypto/ssh"
 )
 
 type SFTPHandler struct {
 	port     int
 	config   *ssh.ServerConfig
+	auth     *sftpAuthenticator
 	listener net.Listener
-	root     string
+	tree     fileTree
+	events   *sftpEvents
+	policy   *sftpPolicy
+	hostKeys []HostKey
+	def      *schema.MockDefinition
+	registry *extensions.Registry
+	env      *template.Env
+	hub      *hub
+	logger   *logrus.Entry
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	unsubscribe func()
 }
 
 // Crea una nueva instancia
 func NewSFTPHandler() *SFTPHandler {
-	return &SFTPHandler{}
+	return &SFTPHandler{
+		conns:  make(map[net.Conn]struct{}),
+		hub:    newHub(),
+		logger: logrus.WithField("protocol", "sftp"),
+	}
 }
 
 // Inicia el servidor
 func (h *SFTPHandler) Start(def *schema.MockDefinition) error {
 	h.port = def.Port
-	h.root = "sftp_root"
+	h.def = def
+	h.logger = logrus.WithField("protocol", def.Protocol)
 
 	// Configuración de autenticación
-	h.config = &ssh.ServerConfig{
-		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			logrus.WithField("user", c.User()).Info("🔐 Password auth")
-			return nil, nil
-		},
-		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-			logrus.WithFields(logrus.Fields{
-				"user": c.User(),
-				"key":  key.Type(),
-			}).Info("🔑 Public key auth")
-			return nil, nil
-		},
-	}
-
-	// Cargar clave privada del host
-	hostKeyPath := getSettingsSftp("host_key")
-	privateBytes, err := os.ReadFile(hostKeyPath)
+	auth, err := newSFTPAuthenticator(def.SFTPAuth, def.BaseDir)
 	if err != nil {
-		return fmt.Errorf("❌ failed to load host key at %s: %w", hostKeyPath, err)
+		return err
 	}
-	private, err := ssh.ParsePrivateKey(privateBytes)
+	h.auth = auth
+	h.config = auth.serverConfig()
+
+	// Claves del host: inline, archivo configurado o generadas en settings
+	signers, err := loadHostKeys(def)
 	if err != nil {
-		return fmt.Errorf("❌ failed to parse host key: %w", err)
+		return fmt.Errorf("❌ failed to load host key: %w", err)
+	}
+	h.hostKeys = nil
+	for _, signer := range signers {
+		h.config.AddHostKey(signer)
+		key := describeHostKey(signer, h.port)
+		h.hostKeys = append(h.hostKeys, key)
+		logrus.WithFields(logrus.Fields{"type": key.Type, "fingerprint": key.Fingerprint}).Info("🔑 SFTP host key")
 	}
-	h.config.AddHostKey(private)
 
-	// Preparar directorio raíz
-	if err := os.MkdirAll(h.root, 0755); err != nil {
-		return fmt.Errorf("❌ failed to create root dir: %w", err)
+	// Preparar el árbol de archivos propio del mock
+	h.registry = loadExtensions(def, h.logger)
+	if h.env, err = newTemplateEnv(def); err != nil {
+		return err
 	}
-	for _, f := range def.Files {
-		fullPath := filepath.Join(h.root, f.Path)
-		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-			logrus.WithError(err).Warn("⚠️ Could not create intermediate dirs")
-		}
-		if err := os.WriteFile(fullPath, []byte(f.Content), 0644); err != nil {
-			logrus.WithError(err).Warnf("⚠️ Could not write file %s", fullPath)
-		}
+	compileTemplates(def, h.registry, h.env, h.logger)
+	tree, policy, events, err := openFileStore(def, h.registry, h.env, h.logger)
+	if err != nil {
+		return err
 	}
 
 	// Iniciar listener TCP
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", h.port))
 	if err != nil {
+		tree.Close()
 		return fmt.Errorf("❌ failed to listen on port %d: %w", h.port, err)
 	}
 	h.listener = listener
+	h.tree = tree
+	h.policy = policy
+	h.events = events
+	h.unsubscribe = subscribe(def, h.registry, h.env, h.logger, events.reactWrite)
 
 	logrus.Infof("🚀 SFTP server listening on port %d", h.port)
 
@@ -94,6 +108,9 @@ func (h *SFTPHandler) acceptConnections() {
 			return
 		}
 		logrus.Info("📥 Incoming TCP connection")
+		h.mu.Lock()
+		h.conns[conn] = struct{}{}
+		h.mu.Unlock()
 		go h.handleConn(conn)
 	}
 }
@@ -104,6 +121,9 @@ func (h *SFTPHandler) handleConn(nConn net.Conn) {
 		if r := recover(); r != nil {
 			logrus.WithField("panic", r).Error("💥 Panic recovered in handleConn")
 		}
+		h.mu.Lock()
+		delete(h.conns, nConn)
+		h.mu.Unlock()
 	}()
 
 	sshConn, chans, reqs, err := ssh.NewServerConn(nConn, h.config)
@@ -112,6 +132,7 @@ func (h *SFTPHandler) handleConn(nConn net.Conn) {
 		nConn.Close()
 		return
 	}
+	h.auth.succeeded(sshConn.User(), sshConn.Permissions)
 	logrus.WithField("user", sshConn.User()).Info("✅ SSH connection established")
 	defer func() {
 		sshConn.Close()
@@ -133,43 +154,138 @@ func (h *SFTPHandler) handleConn(nConn net.Conn) {
 			continue
 		}
 
-		go func() {
-			for req := range requests {
-				if req.Type == "subsystem" && string(req.Payload[4:]) == "sftp" {
-					logrus.Info("📦 Starting SFTP subsystem")
-					server, err := sftp.NewServer(channel)
-					if err != nil {
-						logrus.WithError(err).Error("❌ Failed to start SFTP subsystem")
-						channel.Close()
-						return
-					}
-
-					if err := server.Serve(); err == io.EOF {
-						logrus.Info("✅ SFTP session ended cleanly (EOF)")
-					} else if err != nil {
-						logrus.WithError(err).Error("❌ SFTP session error")
-					} else {
-						logrus.Info("✅ SFTP session ended normally")
-					}
-					channel.Close()
-				}
-			}
-		}()
+		session := &sshSession{h: h, user: sshConn.User(), channel: channel, env: map[string]any{}}
+		go session.serve(requests)
 	}
 }
 
+// TemplateEnv returns the clock and random source of the mock's templates,
+// nil before Start
+func (h *SFTPHandler) TemplateEnv() *template.Env {
+	return h.env
+}
+
 // Detiene el servidor SFTP
 func (h *SFTPHandler) Stop() error {
-	if h.listener != nil {
-		logrus.Info("🛑 Stopping SFTP server")
-		return h.listener.Close()
+	if h.listener == nil {
+		return nil
 	}
-	return nil
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+	}
+	logrus.Info("🛑 Stopping SFTP server")
+	err := h.listener.Close()
+
+	h.hub.closeAll()
+	h.mu.Lock()
+	for conn := range h.conns {
+		conn.Close()
+	}
+	h.mu.Unlock()
+
+	// Los directorios temporales se eliminan, el árbol en memoria se vacía
+	if h.tree != nil {
+		if cerr := h.tree.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// HostKeys returns the host keys presented to clients, so tests can pin
+// known_hosts
+func (h *SFTPHandler) HostKeys() []HostKey {
+	return h.hostKeys
+}
+
+// Operations returns the last 100 client operations recorded since Start
+func (h *SFTPHandler) Operations() []SFTPOperation {
+	if h.events == nil {
+		return nil
+	}
+	return h.events.operations()
+}
+
+// Uploads returns the last 100 files uploaded by clients since Start
+func (h *SFTPHandler) Uploads() []SFTPUpload {
+	if h.events == nil {
+		return nil
+	}
+	return h.events.uploadList()
 }
 
-// Devuelve la ruta del archivo de configuración
-func getSettingsSftp(filename string) string {
-	_, currentFile, _, _ := runtime2.Caller(0)
-	baseDir := filepath.Join(filepath.Dir(currentFile), "..", "..", "settings")
-	return filepath.Join(baseDir, filename)
+// ReadFile returns the content of a file in the mock's root
+func (h *SFTPHandler) ReadFile(name string) ([]byte, error) {
+	if h.tree == nil {
+		return nil, os.ErrNotExist
+	}
+	return readTreeFile(h.tree, name)
+}
+
+// openFileStore prepares the files of an SFTP or FTP mock: its own tree with
+// the seed files, their permissions, quota and faults, and the recorder of
+// client operations
+func openFileStore(def *schema.MockDefinition, registry *extensions.Registry, env *template.Env, logger *logrus.Entry) (fileTree, *sftpPolicy, *sftpEvents, error) {
+	tree, err := newFileTree(def.SFTP)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("❌ failed to create root dir: %w", err)
+	}
+	cfg, files := userEntries(def)
+	files, err = seedFiles(tree, def, files, registry, env)
+	if err != nil {
+		tree.Close()
+		return nil, nil, nil, fmt.Errorf("❌ failed to seed files: %w", err)
+	}
+
+	// Permisos, propietarios, cuota y fallos simulados
+	policy, err := newSFTPPolicy(tree, cfg, files)
+	if err != nil {
+		tree.Close()
+		return nil, nil, nil, fmt.Errorf("❌ failed to apply file attributes: %w", err)
+	}
+
+	events := &sftpEvents{tree: tree, policy: policy, def: def, registry: registry, env: env, logger: logger}
+	return tree, policy, events, nil
+}
+
+// userEntries adds the home directories of the users, with their own seed
+// files and directories, to the files and directories of the mock
+func userEntries(def *schema.MockDefinition) (*schema.SFTPConfig, []schema.FileEntry) {
+	cfg := schema.SFTPConfig{}
+	if def.SFTP != nil {
+		cfg = *def.SFTP
+	}
+	if def.SFTPAuth == nil {
+		return &cfg, def.Files
+	}
+
+	files := append([]schema.FileEntry(nil), def.Files...)
+	dirs := append([]schema.DirEntry(nil), cfg.Dirs...)
+	for _, u := range def.SFTPAuth.Users {
+		home := cleanName(u.Home)
+		if home != "/" {
+			dirs = append(dirs, schema.DirEntry{Path: home})
+		}
+		for _, f := range u.Files {
+			f.Path = home + "/" + f.Path
+			files = append(files, f)
+		}
+		for _, d := range u.Dirs {
+			d.Path = home + "/" + d.Path
+			dirs = append(dirs, d)
+		}
+	}
+	cfg.Dirs = dirs
+	return &cfg, files
+}
+
+// newFileTree creates the storage configured for an SFTP mock
+func newFileTree(cfg *schema.SFTPConfig) (fileTree, error) {
+	if cfg == nil {
+		return newDiskTree("")
+	}
+	if cfg.InMemory {
+		return newMemTree(), nil
+	}
+	return newDiskTree(cfg.Root)
 }
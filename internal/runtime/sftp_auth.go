@@ -0,0 +1,273 @@
+package runtime
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	errBadCredentials = errors.New("invalid credentials")
+	errLockedOut      = errors.New("too many failed attempts")
+	errPartial        = errors.New("further authentication required")
+)
+
+// sftpAccount is an SFTP user with its resolved credentials
+type sftpAccount struct {
+	username string
+	password string
+	keys     []ssh.PublicKey
+	auth     string // any, password, publickey or both
+	home     string
+	readOnly bool
+}
+
+func (acc *sftpAccount) passwordMatches(pass []byte) bool {
+	return acc.password != "" && subtle.ConstantTimeCompare([]byte(acc.password), pass) == 1
+}
+
+// sftpAuthenticator checks SSH credentials against the accounts of an SFTP
+// mock and locks users out after too many failed attempts
+type sftpAuthenticator struct {
+	accounts    map[string]*sftpAccount
+	maxAttempts int
+	lockout     time.Duration
+
+	mu          sync.Mutex
+	failures    map[string]int
+	lockedUntil map[string]time.Time
+}
+
+// newSFTPAuthenticator reads the accounts of auth; relative public key
+// files are resolved against baseDir, the mock file's directory
+func newSFTPAuthenticator(auth *schema.SFTPAuth, baseDir string) (*sftpAuthenticator, error) {
+	a := &sftpAuthenticator{
+		accounts:    make(map[string]*sftpAccount),
+		failures:    make(map[string]int),
+		lockedUntil: make(map[string]time.Time),
+	}
+	if auth == nil {
+		return a, nil
+	}
+
+	a.maxAttempts = auth.MaxAttempts
+	if auth.Lockout != "" {
+		d, err := time.ParseDuration(auth.Lockout)
+		if err != nil {
+			return nil, fmt.Errorf("❌ invalid sftpAuth.lockout: %w", err)
+		}
+		a.lockout = d
+	}
+
+	users := auth.Users
+	if auth.Username != "" {
+		users = append([]schema.SFTPUser{{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			PublicKeyPath: auth.PublicKeyPath,
+		}}, users...)
+	}
+
+	for _, u := range users {
+		acc := &sftpAccount{
+			username: u.Username,
+			password: u.Password,
+			auth:     u.Auth,
+			home:     cleanName(u.Home),
+			readOnly: u.ReadOnly,
+		}
+		if acc.auth == "" {
+			acc.auth = "any"
+		}
+		if path := u.PublicKeyPath; path != "" {
+			if !filepath.IsAbs(path) && baseDir != "" {
+				path = filepath.Join(baseDir, path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("❌ failed to read public keys for %s: %w", u.Username, err)
+			}
+			keys, err := parseAuthorizedKeys(data)
+			if err != nil {
+				return nil, fmt.Errorf("❌ failed to parse public keys for %s: %w", u.Username, err)
+			}
+			acc.keys = append(acc.keys, keys...)
+		}
+		for _, k := range u.PublicKeys {
+			keys, err := parseAuthorizedKeys([]byte(k))
+			if err != nil {
+				return nil, fmt.Errorf("❌ failed to parse public keys for %s: %w", u.Username, err)
+			}
+			acc.keys = append(acc.keys, keys...)
+		}
+		a.accounts[u.Username] = acc
+	}
+	return a, nil
+}
+
+// parseAuthorizedKeys reads every key of an authorized_keys (or .pub) file
+func parseAuthorizedKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
+
+// serverConfig returns an ssh.ServerConfig wired to the authenticator
+func (a *sftpAuthenticator) serverConfig() *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			return nil, a.checkPassword(c.User(), pass)
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			// Clients offer keys before signing with them, so an unknown key
+			// is not counted as a failed attempt
+			if !a.hasKey(c.User(), key) || a.accounts[c.User()].auth == "password" {
+				return nil, errBadCredentials
+			}
+			perms := &ssh.Permissions{
+				Extensions: map[string]string{"pubkey-fp": ssh.FingerprintSHA256(key)},
+			}
+			// The key may only be offered, its signature is checked later,
+			// so success is logged and the failures reset once the handshake
+			// succeeds
+			err := a.allowed(c.User())
+			if err == nil && a.accounts[c.User()].auth == "both" {
+				a.log(c.User(), "publickey", errPartial)
+				return nil, &ssh.PartialSuccessError{Next: ssh.ServerAuthCallbacks{
+					PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+						err := a.check(c.User(), func(acc *sftpAccount) bool { return acc.passwordMatches(pass) })
+						a.log(c.User(), "publickey+password", err)
+						if err != nil {
+							return nil, err
+						}
+						return perms, nil
+					},
+				}}
+			}
+			if err != nil {
+				a.log(c.User(), "publickey", err)
+				return nil, err
+			}
+			perms.Extensions["method"] = "publickey"
+			return perms, nil
+		},
+	}
+}
+
+// checkPassword authenticates a user by password alone, as SSH and FTP
+// logins do
+func (a *sftpAuthenticator) checkPassword(user string, pass []byte) error {
+	err := a.check(user, func(acc *sftpAccount) bool {
+		return (acc.auth == "any" || acc.auth == "password") && acc.passwordMatches(pass)
+	})
+	a.log(user, "password", err)
+	return err
+}
+
+func (a *sftpAuthenticator) hasKey(user string, key ssh.PublicKey) bool {
+	acc, ok := a.accounts[user]
+	if !ok {
+		return false
+	}
+	marshaled := key.Marshal()
+	for _, k := range acc.keys {
+		if bytes.Equal(k.Marshal(), marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+// check runs valid for the user's account while honouring the lockout policy
+func (a *sftpAuthenticator) check(user string, valid func(*sftpAccount) bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.lockedOut(user) {
+		return errLockedOut
+	}
+
+	acc, ok := a.accounts[user]
+	if ok && valid(acc) {
+		delete(a.failures, user)
+		return nil
+	}
+	if !ok {
+		// only configured users are counted, so unknown names cannot grow
+		// the maps
+		return errBadCredentials
+	}
+
+	a.failures[user]++
+	if a.maxAttempts > 0 && a.failures[user] >= a.maxAttempts {
+		a.lockedUntil[user] = time.Now().Add(a.lockout)
+	}
+	return errBadCredentials
+}
+
+// allowed reports whether the user is locked out, without counting an
+// attempt
+func (a *sftpAuthenticator) allowed(user string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lockedOut(user) {
+		return errLockedOut
+	}
+	return nil
+}
+
+// succeeded resets the failures of a user whose connection authenticated
+// and logs a public key login, only known to succeed at this point
+func (a *sftpAuthenticator) succeeded(user string, perms *ssh.Permissions) {
+	a.mu.Lock()
+	delete(a.failures, user)
+	a.mu.Unlock()
+	if perms != nil && perms.Extensions["method"] == "publickey" {
+		a.log(user, "publickey", nil)
+	}
+}
+
+// lockedOut reports whether the user is locked out, clearing an expired
+// lockout. The caller holds the lock.
+func (a *sftpAuthenticator) lockedOut(user string) bool {
+	until, locked := a.lockedUntil[user]
+	if !locked {
+		return false
+	}
+	if a.lockout == 0 || time.Now().Before(until) {
+		return true
+	}
+	delete(a.lockedUntil, user)
+	delete(a.failures, user)
+	return false
+}
+
+func (a *sftpAuthenticator) log(user, method string, err error) {
+	entry := logrus.WithFields(logrus.Fields{"user": user, "method": method})
+	switch {
+	case err == nil:
+		entry.Info("🔐 Authentication succeeded")
+	case errors.Is(err, errLockedOut):
+		entry.Warn("🔒 Authentication refused, user locked out")
+	case errors.Is(err, errPartial):
+		entry.Info("🔐 Public key accepted, password required")
+	default:
+		entry.Warn("🚫 Authentication failed")
+	}
+}
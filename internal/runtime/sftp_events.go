@@ -0,0 +1,280 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// SFTPOperation is a client operation recorded by an SFTP mock
+type SFTPOperation struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Op     string    `json:"op"` // open, write, rename, remove, mkdir, rmdir, setstat
+	Path   string    `json:"path"`
+	Target string    `json:"target,omitempty"` // rename destination
+	Flags  string    `json:"flags,omitempty"`  // open mode
+	Bytes  int64     `json:"bytes,omitempty"`  // bytes written
+	Error  string    `json:"error,omitempty"`
+}
+
+// SFTPUpload is a file written by a client
+type SFTPUpload struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Path     string    `json:"path"`     // where the client wrote it
+	Location string    `json:"location"` // where it is now, after onUpload moves
+	Size     int64     `json:"size"`
+}
+
+// sftpHistorySize is how many recent operations and uploads an SFTP or FTP
+// mock keeps for inspection
+const sftpHistorySize = 100
+
+// sftpEvents records the operations of an SFTP mock and runs its onUpload
+// rules
+type sftpEvents struct {
+	tree     fileTree
+	policy   *sftpPolicy // counts what the mock writes against the quota
+	def      *schema.MockDefinition
+	registry *extensions.Registry
+	env      *template.Env
+	logger   *logrus.Entry
+
+	mu      sync.Mutex
+	ops     []SFTPOperation
+	uploads []SFTPUpload
+}
+
+func (e *sftpEvents) record(op SFTPOperation, err error) {
+	op.Time = time.Now()
+	op.Path = cleanName(op.Path)
+	if op.Target != "" {
+		op.Target = cleanName(op.Target)
+	}
+	if err != nil {
+		op.Error = err.Error()
+	}
+
+	e.mu.Lock()
+	e.ops = append(e.ops, op)
+	if len(e.ops) > sftpHistorySize {
+		e.ops = e.ops[len(e.ops)-sftpHistorySize:]
+	}
+	e.mu.Unlock()
+
+	e.logger.WithFields(logrus.Fields{"user": op.User, "op": op.Op, "path": op.Path}).Debug("sftp operation")
+}
+
+func (e *sftpEvents) operations() []SFTPOperation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SFTPOperation(nil), e.ops...)
+}
+
+func (e *sftpEvents) uploadList() []SFTPUpload {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SFTPUpload(nil), e.uploads...)
+}
+
+// uploaded registers a finished upload and applies the first matching
+// onUpload rule
+func (e *sftpEvents) uploaded(user, name string, size int64) {
+	name = cleanName(name)
+	upload := SFTPUpload{Time: time.Now(), User: user, Path: name, Location: name, Size: size}
+	logger := e.logger.WithFields(logrus.Fields{"user": user, "path": name})
+	logger.Info("📤 File uploaded")
+
+	for i, rule := range e.def.OnUpload {
+		if !matchUpload(rule.Path, name) {
+			continue
+		}
+		location, err := e.applyUploadRule(i, rule, user, name, size)
+		if err != nil {
+			logger.WithError(err).Warnf("⚠️ onUpload[%d] failed", i)
+		}
+		upload.Location = location
+		break
+	}
+
+	e.mu.Lock()
+	e.uploads = append(e.uploads, upload)
+	if len(e.uploads) > sftpHistorySize {
+		e.uploads = e.uploads[len(e.uploads)-sftpHistorySize:]
+	}
+	e.mu.Unlock()
+}
+
+// matchUpload matches patterns without a "/" against the file name only
+func matchUpload(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func (e *sftpEvents) applyUploadRule(i int, rule schema.UploadRule, user, name string, size int64) (string, error) {
+	content, _ := readTreeFile(e.tree, name)
+	ext := path.Ext(name)
+	input := map[string]any{
+		"path":    name,
+		"name":    path.Base(name),
+		"base":    strings.TrimSuffix(path.Base(name), ext),
+		"ext":     ext,
+		"dir":     path.Dir(name),
+		"size":    size,
+		"user":    user,
+		"content": string(content),
+	}
+	tpl, err := template.NewRuntimeEnv(template.MergeContext(input, nil, contextVariables(e.def)), e.registry, e.env)
+	if err != nil {
+		return name, err
+	}
+
+	for j, f := range rule.Write {
+		target, err := tpl.Render(fmt.Sprintf("onUpload[%d].write[%d].path", i, j), f.Path)
+		if err != nil {
+			return name, err
+		}
+		body, err := tpl.Render(fmt.Sprintf("onUpload[%d].write[%d].content", i, j), f.Content)
+		if err != nil {
+			return name, err
+		}
+		if err := e.writeFile(target, body); err != nil {
+			return name, err
+		}
+		e.logger.WithField("path", cleanName(target)).Info("📝 Upload response written")
+	}
+
+	if rule.MoveTo == "" {
+		e.publishUpload(tpl, input, i, rule, name)
+		return name, nil
+	}
+	target, err := tpl.Render(fmt.Sprintf("onUpload[%d].moveTo", i), rule.MoveTo)
+	if err != nil {
+		return name, err
+	}
+	if strings.HasSuffix(target, "/") {
+		target += path.Base(name)
+	} else if info, err := e.tree.Stat(target); err == nil && info.IsDir() {
+		target += "/" + path.Base(name)
+	}
+	target = cleanName(target)
+	if err := mkdirAll(e.tree, path.Dir(target)); err != nil {
+		return name, err
+	}
+	rename := func() error { return e.tree.Rename(name, target) }
+	if err := e.policy.track(rename, name, target); err != nil {
+		return name, err
+	}
+	e.logger.WithField("to", target).Info("📦 Upload moved")
+	e.publishUpload(tpl, input, i, rule, target)
+	return target, nil
+}
+
+// publishUpload sends the event of an onUpload rule, with the file's final
+// location as .input.location
+func (e *sftpEvents) publishUpload(tpl *template.Runtime, input map[string]any, i int, rule schema.UploadRule, location string) {
+	if rule.Publish == nil {
+		return
+	}
+	input["location"] = location
+	publishEvent(tpl, fmt.Sprintf("onUpload[%d].publish", i), rule.Publish, e.env, 0, e.logger)
+}
+
+// writeFile writes a file of an onUpload rule or subscription
+func (e *sftpEvents) writeFile(name, body string) error {
+	write := func() error { return writeTreeFile(e.tree, name, []byte(body), 0644) }
+	return e.policy.track(write, name)
+}
+
+// reactWrite is the reaction of an SFTP or FTP mock to an event: the
+// subscription's files, written to the mock's tree
+func (e *sftpEvents) reactWrite(i int, sub schema.Subscription, tpl *template.Runtime) {
+	for j, f := range sub.Write {
+		target, err := tpl.Render(fmt.Sprintf("subscribe[%d].write[%d].path", i, j), f.Path)
+		if err != nil {
+			e.logger.WithError(err).Warnf("⚠️ subscribe[%d].write[%d] path failed", i, j)
+			continue
+		}
+		body, err := tpl.Render(fmt.Sprintf("subscribe[%d].write[%d].content", i, j), f.Content)
+		if err != nil {
+			e.logger.WithError(err).Warnf("⚠️ subscribe[%d].write[%d] content failed", i, j)
+			continue
+		}
+		target = cleanName(target)
+		if err := e.writeFile(target, body); err != nil {
+			e.logger.WithError(err).Warnf("⚠️ subscribe[%d].write[%d] failed", i, j)
+			continue
+		}
+		e.logger.WithField("path", target).Info("📝 Event file written")
+	}
+}
+
+// uploadFile tracks the bytes written through a handle and reports the
+// upload when the client closes it, if anything was written
+type uploadFile struct {
+	treeFile
+	fs      *sftpFS
+	name    string
+	written atomic.Int64
+	once    sync.Once
+}
+
+func (f *uploadFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.treeFile.WriteAt(p, off)
+	f.written.Add(int64(n))
+	return n, err
+}
+
+func (f *uploadFile) Close() error {
+	err := f.treeFile.Close()
+	f.once.Do(func() {
+		if f.written.Load() == 0 && err == nil {
+			return
+		}
+		size := f.written.Load()
+		if info, serr := f.fs.tree.Stat(f.name); serr == nil {
+			size = info.Size()
+		}
+		f.fs.events.record(SFTPOperation{User: f.fs.user, Op: "write", Path: f.name, Bytes: f.written.Load()}, err)
+		if err == nil {
+			f.fs.events.uploaded(f.fs.user, f.name, size)
+		}
+	})
+	return err
+}
+
+// openMode describes sftp open flags as in fopen modes
+func openMode(flag int) string {
+	var parts []string
+	switch {
+	case flag&os.O_RDWR != 0:
+		parts = append(parts, "rw")
+	case flag&os.O_WRONLY != 0:
+		parts = append(parts, "w")
+	default:
+		parts = append(parts, "r")
+	}
+	if flag&os.O_CREATE != 0 {
+		parts = append(parts, "create")
+	}
+	if flag&os.O_TRUNC != 0 {
+		parts = append(parts, "trunc")
+	}
+	if flag&os.O_EXCL != 0 {
+		parts = append(parts, "excl")
+	}
+	return strings.Join(parts, ",")
+}
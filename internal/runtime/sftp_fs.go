@@ -0,0 +1,538 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fileTree is the storage behind an SFTP mock. Names are absolute, slash
+// separated paths relative to the mock's root and implementations must never
+// reach outside of it.
+type fileTree interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	OpenFile(name string, flag int, perm os.FileMode) (treeFile, error)
+	Mkdir(name string, perm os.FileMode) error
+	Remove(name string) error // files and empty directories
+	Rename(from, to string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Truncate(name string, size int64) error
+	Close() error
+}
+
+// treeFile is an open file of a fileTree
+type treeFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// cleanName maps any client supplied path to an absolute path inside the
+// root; ".." can never climb above "/"
+func cleanName(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// mkdirAll creates name and any missing parents
+func mkdirAll(t fileTree, name string) error {
+	name = cleanName(name)
+	if name == "/" {
+		return nil
+	}
+	if info, err := t.Stat(name); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if err := mkdirAll(t, path.Dir(name)); err != nil {
+		return err
+	}
+	if err := t.Mkdir(name, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// writeTreeFile creates or replaces a file, creating parent directories
+func writeTreeFile(t fileTree, name string, data []byte, perm os.FileMode) error {
+	name = cleanName(name)
+	if err := mkdirAll(t, path.Dir(name)); err != nil {
+		return err
+	}
+	f, err := t.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readTreeFile returns the whole content of a file
+func readTreeFile(t fileTree, name string) ([]byte, error) {
+	info, err := t.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := t.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, info.Size())
+	n, err := f.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// ---------------------------------------------------------------------------
+// Disk backed tree
+// ---------------------------------------------------------------------------
+
+// diskTree serves a directory on disk. A tree created without an explicit
+// root owns a temporary directory that is removed on Close.
+type diskTree struct {
+	root  string
+	owned bool
+}
+
+func newDiskTree(root string) (*diskTree, error) {
+	if root == "" {
+		dir, err := os.MkdirTemp("", "usekuro-sftp-*")
+		if err != nil {
+			return nil, err
+		}
+		return &diskTree{root: dir, owned: true}, nil
+	}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, err
+	}
+	return &diskTree{root: abs}, nil
+}
+
+func (t *diskTree) real(name string) string {
+	return filepath.Join(t.root, filepath.FromSlash(cleanName(name)))
+}
+
+func (t *diskTree) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(t.real(name))
+}
+
+func (t *diskTree) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(t.real(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (t *diskTree) OpenFile(name string, flag int, perm os.FileMode) (treeFile, error) {
+	return os.OpenFile(t.real(name), flag, perm)
+}
+
+func (t *diskTree) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(t.real(name), perm)
+}
+
+func (t *diskTree) Remove(name string) error {
+	if cleanName(name) == "/" {
+		return os.ErrPermission
+	}
+	return os.Remove(t.real(name))
+}
+
+func (t *diskTree) Rename(from, to string) error {
+	if cleanName(from) == "/" || cleanName(to) == "/" {
+		return os.ErrPermission
+	}
+	return os.Rename(t.real(from), t.real(to))
+}
+
+func (t *diskTree) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(t.real(name), mode)
+}
+
+func (t *diskTree) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(t.real(name), atime, mtime)
+}
+
+func (t *diskTree) Truncate(name string, size int64) error {
+	return os.Truncate(t.real(name), size)
+}
+
+func (t *diskTree) Close() error {
+	if !t.owned {
+		return nil
+	}
+	// Read-only directories would keep their children from being removed
+	filepath.WalkDir(t.root, func(p string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(p, 0700)
+		}
+		return nil
+	})
+	return os.RemoveAll(t.root)
+}
+
+// ---------------------------------------------------------------------------
+// In-memory tree
+// ---------------------------------------------------------------------------
+
+// maxMemTreeSize caps the bytes an in-memory tree holds, quota or not, so a
+// client can't make the mock allocate without bound
+const maxMemTreeSize = 1 << 30
+
+// memTree keeps the whole file tree in memory
+type memTree struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode
+	used  int64 // bytes held by the files
+	limit int64
+}
+
+type memNode struct {
+	name    string
+	dir     bool
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+func newMemTree() *memTree {
+	return &memTree{
+		nodes: map[string]*memNode{
+			"/": {name: "/", dir: true, mode: 0755, modTime: time.Now()},
+		},
+		limit: maxMemTreeSize,
+	}
+}
+
+func (n *memNode) info() os.FileInfo {
+	return memInfo{
+		name:    path.Base(n.name),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+		dir:     n.dir,
+	}
+}
+
+func (t *memTree) lookup(name string) (*memNode, error) {
+	n, ok := t.nodes[cleanName(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return n, nil
+}
+
+// parentDir ensures the parent of name exists and is a directory
+func (t *memTree) parentDir(name string) error {
+	parent, err := t.lookup(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	if !parent.dir {
+		return &os.PathError{Op: "open", Path: name, Err: errors.New("not a directory")}
+	}
+	return nil
+}
+
+func (t *memTree) Stat(name string) (os.FileInfo, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n, err := t.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+func (t *memTree) ReadDir(name string) ([]os.FileInfo, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	dir, err := t.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.dir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	var infos []os.FileInfo
+	for p, n := range t.nodes {
+		if p != "/" && path.Dir(p) == dir.name {
+			infos = append(infos, n.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (t *memTree) OpenFile(name string, flag int, perm os.FileMode) (treeFile, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name = cleanName(name)
+	n, err := t.lookup(name)
+	switch {
+	case err != nil:
+		if flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+		if err := t.parentDir(name); err != nil {
+			return nil, err
+		}
+		n = &memNode{name: name, mode: perm, modTime: time.Now()}
+		t.nodes[name] = n
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case n.dir:
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	case flag&os.O_TRUNC != 0:
+		t.used -= int64(len(n.data))
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	return &memFile{tree: t, node: n}, nil
+}
+
+func (t *memTree) Mkdir(name string, perm os.FileMode) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name = cleanName(name)
+	if _, ok := t.nodes[name]; ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := t.parentDir(name); err != nil {
+		return err
+	}
+	t.nodes[name] = &memNode{name: name, dir: true, mode: perm, modTime: time.Now()}
+	return nil
+}
+
+func (t *memTree) Remove(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name = cleanName(name)
+	if name == "/" {
+		return os.ErrPermission
+	}
+	n, err := t.lookup(name)
+	if err != nil {
+		return err
+	}
+	if n.dir {
+		for p := range t.nodes {
+			if path.Dir(p) == name && p != name {
+				return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+	}
+	t.used -= int64(len(n.data))
+	delete(t.nodes, name)
+	return nil
+}
+
+func (t *memTree) Rename(from, to string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	from, to = cleanName(from), cleanName(to)
+	if from == "/" || to == "/" || strings.HasPrefix(to, from+"/") {
+		return os.ErrPermission
+	}
+	n, err := t.lookup(from)
+	if err != nil {
+		return err
+	}
+	if err := t.parentDir(to); err != nil {
+		return err
+	}
+	existing, ok := t.nodes[to]
+	if ok && existing.dir {
+		return &os.PathError{Op: "rename", Path: to, Err: os.ErrExist}
+	}
+	if ok && existing != n {
+		t.used -= int64(len(existing.data))
+	}
+
+	delete(t.nodes, from)
+	n.name = to
+	t.nodes[to] = n
+
+	if n.dir {
+		prefix := from + "/"
+		for p, child := range t.nodes {
+			if strings.HasPrefix(p, prefix) {
+				delete(t.nodes, p)
+				child.name = to + "/" + strings.TrimPrefix(p, prefix)
+				t.nodes[child.name] = child
+			}
+		}
+	}
+	return nil
+}
+
+func (t *memTree) Chmod(name string, mode os.FileMode) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, err := t.lookup(name)
+	if err != nil {
+		return err
+	}
+	n.mode = mode.Perm()
+	return nil
+}
+
+func (t *memTree) Chtimes(name string, _, mtime time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, err := t.lookup(name)
+	if err != nil {
+		return err
+	}
+	n.modTime = mtime
+	return nil
+}
+
+func (t *memTree) Truncate(name string, size int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, err := t.lookup(name)
+	if err != nil {
+		return err
+	}
+	if n.dir {
+		return &os.PathError{Op: "truncate", Path: name, Err: errors.New("is a directory")}
+	}
+	if err := t.resize(n, size); err != nil {
+		return &os.PathError{Op: "truncate", Path: name, Err: err}
+	}
+	n.modTime = time.Now()
+	return nil
+}
+
+func (t *memTree) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodes = map[string]*memNode{
+		"/": {name: "/", dir: true, mode: 0755, modTime: time.Now()},
+	}
+	t.used = 0
+	return nil
+}
+
+// resize grows or shrinks the data of n, refusing sizes the client can't
+// have: negative ones and those past the tree's limit. The lock is held.
+func (t *memTree) resize(n *memNode, size int64) error {
+	if size < 0 {
+		return os.ErrInvalid
+	}
+	grow := size - int64(len(n.data))
+	if grow > t.limit-t.used {
+		return syscall.ENOSPC
+	}
+	if grow < 0 {
+		n.data = n.data[:size]
+	} else {
+		n.data = append(n.data, make([]byte, grow)...)
+	}
+	t.used += grow
+	return nil
+}
+
+// memFile is an open handle on a memNode
+type memFile struct {
+	tree *memTree
+	node *memNode
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.tree.mu.RLock()
+	defer f.tree.mu.RUnlock()
+	if off < 0 {
+		return 0, &os.PathError{Op: "read", Path: f.node.name, Err: os.ErrInvalid}
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+	if off < 0 {
+		return 0, &os.PathError{Op: "write", Path: f.node.name, Err: os.ErrInvalid}
+	}
+	// comparar antes de sumar, off+len puede desbordar
+	if off > f.tree.limit-int64(len(p)) {
+		return 0, &os.PathError{Op: "write", Path: f.node.name, Err: syscall.ENOSPC}
+	}
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		if err := f.tree.resize(f.node, end); err != nil {
+			return 0, &os.PathError{Op: "write", Path: f.node.name, Err: err}
+		}
+	}
+	n := copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Close() error { return nil }
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	dir     bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() any           { return nil }
+
+func (i memInfo) Mode() os.FileMode {
+	if i.dir {
+		return i.mode | os.ModeDir
+	}
+	return i.mode
+}
@@ -0,0 +1,327 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpFS exposes a fileTree through the request based sftp server, so clients
+// only ever see the mock's own root, or their home directory within it. There
+// is one per client session; SCP and FTP sessions use it too.
+type sftpFS struct {
+	tree     fileTree
+	user     string
+	home     string // directory the user is confined to, "/" for the whole tree
+	readOnly bool
+	events   *sftpEvents
+	policy   *sftpPolicy
+}
+
+// newSFTPFS returns the file system seen by an authenticated user
+func newSFTPFS(tree fileTree, policy *sftpPolicy, events *sftpEvents, auth *sftpAuthenticator, user string) *sftpFS {
+	fs := &sftpFS{tree: tree, user: user, home: "/", events: events, policy: policy}
+	if acc, ok := auth.accounts[user]; ok {
+		fs.home, fs.readOnly = acc.home, acc.readOnly
+	}
+	return fs
+}
+
+// real maps a path of the client to the path in the tree
+func (fs *sftpFS) real(name string) string {
+	return cleanName(fs.home + cleanName(name))
+}
+
+func (fs *sftpFS) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs}
+}
+
+func (fs *sftpFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := fs.open(r.Filepath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *sftpFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return fs.OpenFile(r)
+}
+
+// OpenFile implements sftp.OpenFileWriter for read/write handles
+func (fs *sftpFS) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	f, err := fs.open(r.Filepath, openFlags(r.Pflags()))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens a file for the session's user, applying the mock's policy and
+// recording the operation. Writable handles enforce the quota and report the
+// upload when closed.
+func (fs *sftpFS) open(name string, flag int) (treeFile, error) {
+	name = fs.real(name)
+	var f treeFile
+	err := fs.checkOpen(name, flag)
+	if err == nil {
+		open := func() (err error) {
+			f, err = fs.tree.OpenFile(name, flag, 0644)
+			return err
+		}
+		if flag&os.O_TRUNC != 0 {
+			err = fs.policy.track(open, name)
+		} else {
+			err = open()
+		}
+	}
+	fs.events.record(SFTPOperation{User: fs.user, Op: "open", Path: name, Flags: openMode(flag)}, err)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f, nil
+	}
+	f = newLimitedFile(f, fs.policy, name)
+	return &uploadFile{treeFile: f, fs: fs, name: name}, nil
+}
+
+// checkOpen applies the user's and the mock's policy to opening a tree path
+func (fs *sftpFS) checkOpen(name string, flag int) error {
+	if fs.readOnly && flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return permissionDenied("open", name)
+	}
+	return fs.policy.checkOpen(name, flag)
+}
+
+// checkCmd applies the user's and the mock's policy to a command on tree
+// paths
+func (fs *sftpFS) checkCmd(op, name, target string) error {
+	if fs.readOnly && op != "list" {
+		return permissionDenied(op, name)
+	}
+	return fs.policy.checkCmd(op, name, target)
+}
+
+// stat returns the attributes of a client path, with its configured owner
+func (fs *sftpFS) stat(name string) (os.FileInfo, error) {
+	name = fs.real(name)
+	info, err := fs.tree.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.policy.withOwner(path.Dir(name), info), nil
+}
+
+// list returns the entries of a client directory
+func (fs *sftpFS) list(name string) ([]os.FileInfo, error) {
+	name = fs.real(name)
+	if err := fs.checkCmd("list", name, ""); err != nil {
+		return nil, err
+	}
+	infos, err := fs.tree.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range infos {
+		infos[i] = fs.policy.withOwner(name, info)
+	}
+	return infos, nil
+}
+
+// mkdir creates a client directory, recording the operation
+func (fs *sftpFS) mkdir(name string) error {
+	return fs.Filecmd(sftp.NewRequest("Mkdir", name))
+}
+
+// chmod and chtimes change the attributes of a client path the way
+// transfer tools preserve them, with the checks of an SFTP setstat
+func (fs *sftpFS) chmod(name string, mode os.FileMode) error {
+	return fs.setattr(name, func(name string) error { return fs.tree.Chmod(name, mode) })
+}
+
+func (fs *sftpFS) chtimes(name string, atime, mtime time.Time) error {
+	return fs.setattr(name, func(name string) error { return fs.tree.Chtimes(name, atime, mtime) })
+}
+
+func (fs *sftpFS) setattr(name string, change func(name string) error) error {
+	name = fs.real(name)
+	err := fs.checkCmd("setstat", name, "")
+	if err == nil {
+		err = change(name)
+	}
+	fs.events.record(SFTPOperation{User: fs.user, Op: "setstat", Path: name}, err)
+	return err
+}
+
+func openFlags(p sftp.FileOpenFlags) int {
+	flag := os.O_RDONLY
+	switch {
+	case p.Read && p.Write:
+		flag = os.O_RDWR
+	case p.Write:
+		flag = os.O_WRONLY
+	}
+	if p.Creat {
+		flag |= os.O_CREATE
+	}
+	if p.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if p.Excl {
+		flag |= os.O_EXCL
+	}
+	return flag
+}
+
+func (fs *sftpFS) Filecmd(r *sftp.Request) error {
+	name, target := fs.real(r.Filepath), ""
+	if r.Target != "" {
+		target = fs.real(r.Target)
+	}
+	err := fs.filecmd(r, name, target)
+	if err != sftp.ErrSSHFxOpUnsupported {
+		fs.events.record(SFTPOperation{
+			User:   fs.user,
+			Op:     strings.ToLower(r.Method),
+			Path:   name,
+			Target: target,
+		}, err)
+	}
+	return err
+}
+
+func (fs *sftpFS) filecmd(r *sftp.Request, name, target string) error {
+	switch r.Method {
+	case "Setstat", "Rename", "Rmdir", "Remove", "Mkdir":
+		if err := fs.checkCmd(strings.ToLower(r.Method), name, target); err != nil {
+			return err
+		}
+	}
+
+	switch r.Method {
+	case "Setstat":
+		return fs.setstat(r, name)
+	case "Rename":
+		// SFTPv3 rename must not replace an existing file
+		if _, err := fs.tree.Stat(target); err == nil {
+			return os.ErrExist
+		}
+		return fs.rename(name, target)
+	case "Rmdir":
+		info, err := fs.tree.Stat(name)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errors.New("not a directory")
+		}
+		return fs.remove(name)
+	case "Remove":
+		info, err := fs.tree.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return errors.New("is a directory")
+		}
+		return fs.remove(name)
+	case "Mkdir":
+		return fs.tree.Mkdir(name, 0755)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename implements the posix-rename@openssh.com extension
+func (fs *sftpFS) PosixRename(r *sftp.Request) error {
+	name, target := fs.real(r.Filepath), fs.real(r.Target)
+	err := fs.checkCmd("rename", name, target)
+	if err == nil {
+		err = fs.rename(name, target)
+	}
+	fs.events.record(SFTPOperation{User: fs.user, Op: "rename", Path: name, Target: target}, err)
+	return err
+}
+
+func (fs *sftpFS) rename(from, to string) error {
+	rename := func() error { return fs.tree.Rename(from, to) }
+	if err := fs.policy.track(rename, from, to); err != nil {
+		return err
+	}
+	fs.policy.renamed(from, to)
+	return nil
+}
+
+func (fs *sftpFS) remove(name string) error {
+	remove := func() error { return fs.tree.Remove(name) }
+	if err := fs.policy.track(remove, name); err != nil {
+		return err
+	}
+	fs.policy.removed(name)
+	return nil
+}
+
+func (fs *sftpFS) setstat(r *sftp.Request, name string) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Size {
+		if attrs.Size > math.MaxInt64 {
+			return &os.PathError{Op: "truncate", Path: name, Err: os.ErrInvalid}
+		}
+		// growing a file counts against the quota like a write
+		if err := fs.policy.truncate(name, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := fs.tree.Chmod(name, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := fs.tree.Chtimes(name, attrs.AccessTime(), attrs.ModTime()); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		fs.policy.chown(name, attrs.UID, attrs.GID)
+	}
+	return nil
+}
+
+func (fs *sftpFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		infos, err := fs.list(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		info, err := fs.stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
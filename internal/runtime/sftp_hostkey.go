@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/usekuro/usekuro/internal/config"
+	"github.com/usekuro/usekuro/internal/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKey describes an SSH host key presented by a mock
+type HostKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"` // SHA256:...
+	PublicKey   string `json:"publicKey"`   // authorized_keys format
+	KnownHost   string `json:"knownHost"`   // known_hosts line for localhost and the mock's port
+}
+
+// loadHostKeys returns the host keys of an SFTP mock: the inline key, the
+// configured key file (generated when missing) or the shared keys in the
+// settings directory, generated on first use
+func loadHostKeys(def *schema.MockDefinition) ([]ssh.Signer, error) {
+	cfg := def.SFTP
+	if cfg == nil {
+		cfg = &schema.SFTPConfig{}
+	}
+
+	switch {
+	case cfg.HostKey != "":
+		signer, err := ssh.ParsePrivateKey([]byte(cfg.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp.hostKey: %w", err)
+		}
+		return []ssh.Signer{signer}, nil
+	case cfg.HostKeyPath != "":
+		path := cfg.HostKeyPath
+		if !filepath.IsAbs(path) && def.BaseDir != "" {
+			path = filepath.Join(def.BaseDir, path)
+		}
+		signer, err := config.LoadOrGenerateHostKey(path, cfg.HostKeyType)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.Signer{signer}, nil
+	}
+
+	var signers []ssh.Signer
+	for _, keyType := range []string{"ed25519", "rsa"} {
+		signer, err := config.LoadOrGenerateHostKey(config.DefaultHostKeyPaths[keyType], keyType)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func describeHostKey(signer ssh.Signer, port int) HostKey {
+	pub := signer.PublicKey()
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	return HostKey{
+		Type:        pub.Type(),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		PublicKey:   authorized,
+		KnownHost:   fmt.Sprintf("[localhost]:%d %s", port, authorized),
+	}
+}
@@ -0,0 +1,440 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+// sftpPolicy applies the attributes of an SFTP mock's files and decides which
+// client operations are allowed: permission bits, read-only directories, the
+// quota and injected faults
+type sftpPolicy struct {
+	tree     fileTree
+	quota    int64
+	readOnly []string
+	faults   []sftpFault
+
+	mu     sync.RWMutex
+	owners map[string][2]uint32
+
+	// used is what the files take while there is a quota, counted as the
+	// tree changes. quotaMu serializes the changes, so concurrent writes to
+	// a file are counted once.
+	used    atomic.Int64
+	quotaMu sync.Mutex
+}
+
+type sftpFault struct {
+	pattern    string
+	op         string
+	kind       string
+	afterBytes int64
+}
+
+// newSFTPPolicy builds the policy of an SFTP mock and applies the attributes
+// of its seeded files and configured directories
+func newSFTPPolicy(tree fileTree, cfg *schema.SFTPConfig, files []schema.FileEntry) (*sftpPolicy, error) {
+	p := &sftpPolicy{tree: tree, owners: make(map[string][2]uint32)}
+
+	if cfg == nil {
+		cfg = &schema.SFTPConfig{}
+	}
+	if cfg.Quota != "" {
+		quota, err := schema.ParseSize(cfg.Quota)
+		if err != nil {
+			return nil, err
+		}
+		p.quota = quota
+	}
+	for _, f := range cfg.Faults {
+		p.faults = append(p.faults, sftpFault{pattern: f.Path, op: f.Op, kind: f.Error, afterBytes: f.AfterBytes})
+	}
+
+	for _, f := range files {
+		if err := p.applyAttrs(f.Path, f.Mode, f.Owner, f.ModTime); err != nil {
+			return nil, err
+		}
+	}
+
+	// Directories are created first and get their attributes deepest first,
+	// so restrictive modes do not get in the way of their children
+	dirs := append([]schema.DirEntry(nil), cfg.Dirs...)
+	for _, d := range dirs {
+		if err := mkdirAll(tree, d.Path); err != nil {
+			return nil, err
+		}
+		if d.ReadOnly {
+			p.readOnly = append(p.readOnly, cleanName(d.Path))
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(cleanName(dirs[i].Path), "/") > strings.Count(cleanName(dirs[j].Path), "/")
+	})
+	for _, d := range dirs {
+		if err := p.applyAttrs(d.Path, d.Mode, d.Owner, d.ModTime); err != nil {
+			return nil, err
+		}
+	}
+	if p.quota > 0 {
+		p.used.Store(p.usage())
+	}
+	return p, nil
+}
+
+func (p *sftpPolicy) applyAttrs(name, mode, owner, modTime string) error {
+	if modTime != "" {
+		t, err := time.Parse(time.RFC3339, modTime)
+		if err != nil {
+			return err
+		}
+		if err := p.tree.Chtimes(name, t, t); err != nil {
+			return err
+		}
+	}
+	if owner != "" {
+		uid, gid, err := schema.ParseOwner(owner)
+		if err != nil {
+			return err
+		}
+		p.chown(name, uid, gid)
+	}
+	if mode != "" {
+		m, err := schema.ParseFileMode(mode)
+		if err != nil {
+			return err
+		}
+		if err := p.tree.Chmod(name, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *sftpPolicy) chown(name string, uid, gid uint32) {
+	p.mu.Lock()
+	p.owners[cleanName(name)] = [2]uint32{uid, gid}
+	p.mu.Unlock()
+}
+
+// owner returns the configured owner of a file, if any
+func (p *sftpPolicy) owner(name string) ([2]uint32, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	o, ok := p.owners[cleanName(name)]
+	return o, ok
+}
+
+// renamed moves the owners of a renamed file or directory
+func (p *sftpPolicy) renamed(from, to string) {
+	from, to = cleanName(from), cleanName(to)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, o := range p.owners {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			delete(p.owners, name)
+			p.owners[to+strings.TrimPrefix(name, from)] = o
+		}
+	}
+}
+
+func (p *sftpPolicy) removed(name string) {
+	p.mu.Lock()
+	delete(p.owners, cleanName(name))
+	p.mu.Unlock()
+}
+
+// withOwner attaches the configured owner to a file info
+func (p *sftpPolicy) withOwner(dir string, info os.FileInfo) os.FileInfo {
+	if o, ok := p.owner(path.Join(cleanName(dir), info.Name())); ok {
+		return ownedInfo{FileInfo: info, uid: o[0], gid: o[1]}
+	}
+	return info
+}
+
+// ---------------------------------------------------------------------------
+// Checks
+// ---------------------------------------------------------------------------
+
+func permissionDenied(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: syscall.EACCES}
+}
+
+// faultError returns the error of the first fault matching op and name.
+// Faults limited to a number of bytes are applied by limitedFile instead.
+func (p *sftpPolicy) faultError(op, name string) error {
+	for _, f := range p.faults {
+		if f.afterBytes == 0 && f.matches(op, name) {
+			return f.err(op, name)
+		}
+	}
+	return nil
+}
+
+func (f sftpFault) matches(op, name string) bool {
+	return (f.op == "" || f.op == op) && matchUpload(f.pattern, cleanName(name))
+}
+
+func (f sftpFault) err(op, name string) error {
+	switch f.kind {
+	case "permission":
+		return permissionDenied(op, name)
+	case "nospace":
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOSPC}
+	case "notfound":
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	default:
+		return &os.PathError{Op: op, Path: name, Err: errors.New("simulated failure")}
+	}
+}
+
+func (p *sftpPolicy) inReadOnly(name string) bool {
+	name = cleanName(name)
+	for _, dir := range p.readOnly {
+		if name == dir || strings.HasPrefix(name, dir+"/") || dir == "/" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBit reports whether name exists with the given owner permission bit
+func (p *sftpPolicy) hasBit(name string, bit os.FileMode) bool {
+	info, err := p.tree.Stat(name)
+	return err != nil || info.Mode().Perm()&bit != 0
+}
+
+// canChange reports whether entries may be created in or removed from the
+// directory holding name
+func (p *sftpPolicy) canChange(name string) bool {
+	return !p.inReadOnly(name) && p.hasBit(path.Dir(cleanName(name)), 0200)
+}
+
+// checkOpen validates opening name with the given os flags
+func (p *sftpPolicy) checkOpen(name string, flag int) error {
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	op := "read"
+	if write {
+		op = "write"
+	}
+	if err := p.faultError("open", name); err != nil {
+		return err
+	}
+	if err := p.faultError(op, name); err != nil {
+		return err
+	}
+
+	_, statErr := p.tree.Stat(name)
+	exists := statErr == nil
+	switch {
+	case !write:
+		if !p.hasBit(name, 0400) {
+			return permissionDenied("open", name)
+		}
+	case !exists:
+		if !p.canChange(name) {
+			return permissionDenied("open", name)
+		}
+	default:
+		if p.inReadOnly(name) || !p.hasBit(name, 0200) || (flag&os.O_RDWR != 0 && !p.hasBit(name, 0400)) {
+			return permissionDenied("open", name)
+		}
+	}
+	return nil
+}
+
+// checkCmd validates a file command; target is set for renames
+func (p *sftpPolicy) checkCmd(op, name, target string) error {
+	if err := p.faultError(op, name); err != nil {
+		return err
+	}
+	switch op {
+	case "list":
+		if !p.hasBit(name, 0400) {
+			return permissionDenied(op, name)
+		}
+	case "setstat":
+		if p.inReadOnly(name) {
+			return permissionDenied(op, name)
+		}
+	case "rename":
+		if !p.canChange(name) || !p.canChange(target) {
+			return permissionDenied(op, name)
+		}
+	default: // mkdir, remove, rmdir
+		if !p.canChange(name) {
+			return permissionDenied(op, name)
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Quota and byte limited writes
+// ---------------------------------------------------------------------------
+
+// usage returns the total size of the files in the tree. It walks the whole
+// tree, so it only sets the count the quota starts from.
+func (p *sftpPolicy) usage() int64 {
+	var walk func(dir string) int64
+	walk = func(dir string) int64 {
+		infos, err := p.tree.ReadDir(dir)
+		if err != nil {
+			return 0
+		}
+		var total int64
+		for _, info := range infos {
+			if info.IsDir() {
+				total += walk(path.Join(dir, info.Name()))
+			} else {
+				total += info.Size()
+			}
+		}
+		return total
+	}
+	return walk("/")
+}
+
+// size returns the size of the file name, 0 for directories and missing files
+func (p *sftpPolicy) size(name string) int64 {
+	info, err := p.tree.Stat(name)
+	if err != nil || info.IsDir() {
+		return 0
+	}
+	return info.Size()
+}
+
+// track runs change, which modifies the named files, and counts the bytes
+// it adds or frees against the quota
+func (p *sftpPolicy) track(change func() error, names ...string) error {
+	if p.quota <= 0 {
+		return change()
+	}
+	p.quotaMu.Lock()
+	defer p.quotaMu.Unlock()
+	before := p.sizes(names)
+	err := change()
+	p.used.Add(p.sizes(names) - before)
+	return err
+}
+
+func (p *sftpPolicy) sizes(names []string) int64 {
+	var total int64
+	for i, name := range names {
+		if i > 0 && cleanName(name) == cleanName(names[0]) {
+			continue
+		}
+		total += p.size(name)
+	}
+	return total
+}
+
+// free returns how far name may grow within the quota. The caller holds
+// quotaMu.
+func (p *sftpPolicy) free(name string) (size, end int64) {
+	size = p.size(name)
+	return size, size + max(p.quota-p.used.Load(), 0)
+}
+
+// faultLimit returns how far into name a write may reach before a byte
+// limited fault and the error it reports; the limit is negative when no fault
+// applies
+func (p *sftpPolicy) faultLimit(name string) (int64, error) {
+	limit, limitErr := int64(-1), error(nil)
+	for _, f := range p.faults {
+		if f.afterBytes > 0 && f.matches("write", name) && (limit < 0 || f.afterBytes < limit) {
+			limit, limitErr = f.afterBytes, f.err("write", name)
+		}
+	}
+	return limit, limitErr
+}
+
+// truncate resizes name, growing it only within the faults and the quota
+func (p *sftpPolicy) truncate(name string, size int64) error {
+	if limit, limitErr := p.faultLimit(name); limit >= 0 && size > limit {
+		return limitErr
+	}
+	if p.quota <= 0 {
+		return p.tree.Truncate(name, size)
+	}
+	p.quotaMu.Lock()
+	defer p.quotaMu.Unlock()
+	before, end := p.free(name)
+	if size > before && size > end {
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.ENOSPC}
+	}
+	err := p.tree.Truncate(name, size)
+	p.used.Add(p.size(name) - before)
+	return err
+}
+
+// limitedFile enforces the quota and byte limited faults, keeping the part of
+// a write that still fits like a full disk would
+type limitedFile struct {
+	treeFile
+	policy   *sftpPolicy
+	name     string
+	limit    int64 // of the faults, from faultLimit
+	limitErr error
+}
+
+func newLimitedFile(f treeFile, policy *sftpPolicy, name string) *limitedFile {
+	limit, limitErr := policy.faultLimit(name)
+	return &limitedFile{treeFile: f, policy: policy, name: name, limit: limit, limitErr: limitErr}
+}
+
+func (f *limitedFile) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrInvalid}
+	}
+	var cutErr error
+	if f.limit >= 0 && off > f.limit-int64(len(b)) {
+		if off >= f.limit {
+			return 0, f.limitErr
+		}
+		b, cutErr = b[:f.limit-off], f.limitErr
+	}
+
+	p := f.policy
+	if p.quota <= 0 {
+		return f.write(b, off, cutErr)
+	}
+	// check the space left and write at once, against the shared count
+	p.quotaMu.Lock()
+	defer p.quotaMu.Unlock()
+	before, end := p.free(f.name)
+	if off > end-int64(len(b)) {
+		if off >= end {
+			return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+		}
+		b, cutErr = b[:end-off], &os.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+	}
+	defer func() { p.used.Add(p.size(f.name) - before) }()
+	return f.write(b, off, cutErr)
+}
+
+// write writes b and reports cutErr once it all went through
+func (f *limitedFile) write(b []byte, off int64, cutErr error) (int, error) {
+	n, err := f.treeFile.WriteAt(b, off)
+	if err == nil {
+		err = cutErr
+	}
+	return n, err
+}
+
+// ownedInfo reports a configured owner to sftp clients
+type ownedInfo struct {
+	os.FileInfo
+	uid, gid uint32
+}
+
+func (i ownedInfo) Uid() uint32 { return i.uid }
+func (i ownedInfo) Gid() uint32 { return i.gid }
@@ -0,0 +1,121 @@
+package runtime
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// seedFiles writes the files of an SFTP mock to its tree and returns them
+// with their paths rendered
+func seedFiles(tree fileTree, def *schema.MockDefinition, files []schema.FileEntry, registry *extensions.Registry, env *template.Env) ([]schema.FileEntry, error) {
+	tpl, err := template.NewRuntimeEnv(template.MergeContext(nil, nil, contextVariables(def)), registry, env)
+	if err != nil {
+		return nil, err
+	}
+
+	seeded := make([]schema.FileEntry, 0, len(files))
+	for i, f := range files {
+		field := seedField(def, i)
+		name, err := tpl.Render(field+".path", f.Path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := seedContent(tpl, field, f, def.BaseDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s): %w", field, name, err)
+		}
+		if err := writeTreeFile(tree, name, data, 0644); err != nil {
+			return nil, fmt.Errorf("%s (%s): %w", field, name, err)
+		}
+		f.Path = name
+		seeded = append(seeded, f)
+	}
+	return seeded, nil
+}
+
+// seedField names the field of the i-th file of userEntries: the mock's
+// files, then those of each user
+func seedField(def *schema.MockDefinition, i int) string {
+	if i < len(def.Files) || def.SFTPAuth == nil {
+		return fmt.Sprintf("files[%d]", i)
+	}
+	j := i - len(def.Files)
+	for u, user := range def.SFTPAuth.Users {
+		if j < len(user.Files) {
+			return fmt.Sprintf("sftpAuth.users[%d].files[%d]", u, j)
+		}
+		j -= len(user.Files)
+	}
+	return fmt.Sprintf("files[%d]", i)
+}
+
+// seedContent resolves the content of the seed file at field: copied from
+// its source or taken inline, optionally rendered, decoded and sized
+func seedContent(tpl *template.Runtime, field string, f schema.FileEntry, baseDir string) ([]byte, error) {
+	data := []byte(f.Content)
+	if f.Source != "" {
+		src := f.Source
+		if !filepath.IsAbs(src) && baseDir != "" {
+			src = filepath.Join(baseDir, src)
+		}
+		var err error
+		if data, err = os.ReadFile(src); err != nil {
+			return nil, err
+		}
+	}
+
+	// Inline text is a template unless disabled, sources and base64 data
+	// only when asked for
+	render := f.Source == "" && f.Encoding != "base64"
+	if f.Template != nil {
+		render = *f.Template
+	}
+	if render {
+		out, err := tpl.Render(field+".content", string(data))
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(out)
+	}
+
+	if f.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+		data = decoded
+	}
+
+	if f.Size != "" {
+		size, err := schema.ParseSize(f.Size)
+		if err != nil {
+			return nil, err
+		}
+		if size > schema.MaxGeneratedSize {
+			return nil, fmt.Errorf("size %s is more than the maximum of 256MB", f.Size)
+		}
+		data = fillContent(data, size)
+	}
+	return data, nil
+}
+
+// fillContent returns size bytes repeating pattern, or reproducible random
+// bytes when there is no pattern
+func fillContent(pattern []byte, size int64) []byte {
+	data := make([]byte, size)
+	if len(pattern) == 0 {
+		rand.New(rand.NewSource(size)).Read(data)
+		return data
+	}
+	for i := 0; i < len(data); {
+		i += copy(data[i:], pattern)
+	}
+	return data
+}
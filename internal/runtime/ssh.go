@@ -0,0 +1,310 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/template"
+	"golang.org/x/crypto/ssh"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// sshSession serves a session channel of an SSH or SFTP mock: the sftp
+// subsystem, scp transfers, exec requests and interactive shells scripted by
+// ssh.commands
+type sshSession struct {
+	h       *SFTPHandler
+	user    string
+	channel ssh.Channel
+	env     map[string]any
+	pty     bool
+	term    string
+	reader  *bufio.Reader
+	lastCR  bool
+}
+
+func (s *sshSession) serve(requests <-chan *ssh.Request) {
+	logger := s.h.logger.WithField("user", s.user)
+	// A channel runs one program, on a copy of the state the requests before
+	// it set up, so later requests cannot change it under the program's feet
+	started := false
+	start := func(run func(*sshSession)) {
+		started = true
+		program := *s
+		program.env = maps.Clone(s.env)
+		go run(&program)
+	}
+	for req := range requests {
+		ok := true
+		switch req.Type {
+		case "pty-req":
+			var pty struct {
+				Term                  string
+				Columns, Rows, Wd, Ht uint32
+				Modes                 string
+			}
+			ssh.Unmarshal(req.Payload, &pty)
+			s.pty, s.term = true, pty.Term
+		case "env":
+			var kv struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &kv) == nil {
+				s.env[kv.Name] = kv.Value
+			}
+		case "window-change":
+		case "subsystem":
+			var sub struct{ Name string }
+			ssh.Unmarshal(req.Payload, &sub)
+			ok = !started && sub.Name == "sftp"
+			if ok {
+				start((*sshSession).serveSFTP)
+			}
+		case "exec":
+			var cmd struct{ Command string }
+			if started || ssh.Unmarshal(req.Payload, &cmd) != nil {
+				ok = false
+				break
+			}
+			// scp clients in legacy mode run "scp -t" or "scp -f" on the server
+			if scp, isSCP := parseSCP(cmd.Command); isSCP {
+				logger.WithField("command", cmd.Command).Info("📦 SCP transfer")
+				start(func(s *sshSession) { s.scp(scp) })
+				break
+			}
+			ok = s.commandsEnabled()
+			if ok {
+				logger.WithField("command", cmd.Command).Info("⚙️ SSH exec")
+				start(func(s *sshSession) { s.exec(cmd.Command) })
+			}
+		case "shell":
+			ok = !started && s.commandsEnabled()
+			if ok {
+				logger.Info("🐚 SSH shell started")
+				start((*sshSession).shell)
+			}
+		default:
+			ok = false
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+func (s *sshSession) commandsEnabled() bool {
+	return s.h.def.SSH != nil && s.h.def.SSH.Commands != nil
+}
+
+func (s *sshSession) serveSFTP() {
+	logger := s.h.logger.WithField("user", s.user)
+	logger.Info("📦 Starting SFTP subsystem")
+	fs := newSFTPFS(s.h.tree, s.h.policy, s.h.events, s.h.auth, s.user)
+	server := sftp.NewRequestServer(s.channel, fs.handlers())
+	if err := server.Serve(); err == io.EOF {
+		logger.Info("✅ SFTP session ended cleanly (EOF)")
+	} else if err != nil {
+		logger.WithError(err).Error("❌ SFTP session error")
+	} else {
+		logger.Info("✅ SFTP session ended normally")
+	}
+	s.channel.Close()
+}
+
+// newPeer registers the session in the mock's hub so that rules can keep
+// session attributes and broadcast to other shells
+func (s *sshSession) newPeer() *peer {
+	p := s.h.hub.add(func(data []byte, _ bool) error {
+		_, err := s.channel.Write(s.output(data))
+		return err
+	}, s.channel.Close)
+	p.set("user", s.user)
+	env := make(map[string]any, len(s.env))
+	for k, v := range s.env {
+		env[k] = v
+	}
+	p.set("env", env)
+	p.set("term", s.term)
+	return p
+}
+
+// output converts line endings for terminals, which need \r\n
+func (s *sshSession) output(data []byte) []byte {
+	if !s.pty {
+		return data
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
+// run evaluates a command line and writes its output; it returns the exit
+// status and whether the rule asked to end the session
+func (s *sshSession) run(p *peer, line string) (int, bool) {
+	r := s.h.hub.handleMessage(p, line, s.h.def.SSH.Commands, "ssh.commands", s.h.def, s.h.registry, s.h.env, s.h.logger)
+	if r == nil {
+		name, _, _ := strings.Cut(line, " ")
+		s.channel.Stderr().Write(s.output([]byte("sh: " + name + ": command not found\n")))
+		return 127, false
+	}
+	if len(r.data) > 0 {
+		if !r.binary {
+			r.data = withNewline(r.data)
+		}
+		p.send(r.data, r.binary)
+	}
+	if len(r.stderr) > 0 {
+		s.channel.Stderr().Write(s.output(withNewline(r.stderr)))
+	}
+	return r.exit, r.close != nil
+}
+
+func withNewline(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		return append(data, '\n')
+	}
+	return data
+}
+
+func (s *sshSession) exec(command string) {
+	p := s.newPeer()
+	defer s.h.hub.remove(p)
+
+	status, _ := s.run(p, command)
+	s.exit(status)
+}
+
+func (s *sshSession) shell() {
+	p := s.newPeer()
+	defer s.h.hub.remove(p)
+	s.reader = bufio.NewReader(s.channel)
+
+	cfg := s.h.def.SSH
+	if cfg.Banner != "" {
+		p.send(withNewline([]byte(s.render(p, "ssh.banner", cfg.Banner))), false)
+	}
+	prompt := cfg.Prompt
+	if prompt == "" {
+		prompt = "$ "
+	}
+
+	status := 0
+	for {
+		p.send([]byte(s.render(p, "ssh.prompt", prompt)), false)
+		line, err := s.readLine()
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err != nil {
+			break
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if fields := strings.Fields(line); fields[0] == "exit" || fields[0] == "logout" {
+			if len(fields) > 1 {
+				if n, err := strconv.Atoi(fields[1]); err == nil {
+					status = n
+				}
+			}
+			break
+		}
+
+		var closed bool
+		status, closed = s.run(p, line)
+		if closed {
+			break
+		}
+	}
+	s.exit(status)
+}
+
+func (s *sshSession) render(p *peer, name, raw string) string {
+	ctx := template.MergeContext(nil, p.session, contextVariables(s.h.def))
+	tpl, err := template.NewRuntimeEnv(ctx, s.h.registry, s.h.env)
+	if err != nil {
+		return raw
+	}
+	out, err := tpl.Render(name, raw)
+	if err != nil {
+		s.h.logger.WithError(err).Warnf("⚠️ failed to render %s", name)
+		return raw
+	}
+	return out
+}
+
+// readLine reads one command line. With a PTY the client sends raw
+// keystrokes, so the line is echoed and edited here.
+func (s *sshSession) readLine() (string, error) {
+	var line []byte
+	escape := 0
+	for {
+		b, err := s.reader.ReadByte()
+		if err != nil {
+			if len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
+
+		// Skip "\n" after "\r" so CRLF clients do not produce empty lines
+		if b == '\n' && s.lastCR {
+			s.lastCR = false
+			continue
+		}
+		s.lastCR = b == '\r'
+
+		if !s.pty {
+			if b == '\n' || b == '\r' {
+				return string(line), nil
+			}
+			line = append(line, b)
+			continue
+		}
+
+		switch {
+		case escape > 0:
+			// Drop terminal escape sequences such as arrow keys
+			if (escape == 1 && b != '[') || (escape > 1 && b >= 0x40 && b <= 0x7e) {
+				escape = 0
+			} else {
+				escape++
+			}
+		case b == 0x1b:
+			escape = 1
+		case b == '\r' || b == '\n':
+			s.channel.Write([]byte("\r\n"))
+			return string(line), nil
+		case b == 0x7f || b == 0x08:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				s.channel.Write([]byte("\b \b"))
+			}
+		case b == 0x03:
+			s.channel.Write([]byte("^C\r\n"))
+			return "", errInterrupted
+		case b == 0x04:
+			if len(line) == 0 {
+				s.channel.Write([]byte("\r\n"))
+				return "", io.EOF
+			}
+		case b >= 0x20 || b == '\t':
+			line = append(line, b)
+			s.channel.Write([]byte{b})
+		}
+	}
+}
+
+// exit reports the exit status to the client and ends the session
+func (s *sshSession) exit(status int) {
+	s.h.logger.WithFields(logrus.Fields{"user": s.user, "status": status}).Info("👋 SSH command finished")
+	s.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+	s.channel.Close()
+}
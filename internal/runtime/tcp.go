@@ -3,27 +3,41 @@ package runtime
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 
 	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/extensions"
 	"github.com/usekuro/usekuro/internal/schema"
 	"github.com/usekuro/usekuro/internal/template"
 )
 
 type TCPHandler struct {
-	Port   int
-	ln     net.Listener
-	logger *logrus.Entry
+	Port     int
+	ln       net.Listener
+	hub      *hub
+	env      *template.Env
+	registry *extensions.Registry
+	logger   *logrus.Entry
+
+	unsubscribe func()
 }
 
 func NewTCPHandler() *TCPHandler {
 	return &TCPHandler{
+		hub:    newHub(),
 		logger: logrus.WithField("protocol", "tcp"),
 	}
 }
 
 func (h *TCPHandler) Start(def *schema.MockDefinition) error {
 	var err error
+	if h.env, err = newTemplateEnv(def); err != nil {
+		return err
+	}
+	// Extensions load once, not on every connection
+	h.registry = loadExtensions(def, h.logger)
+	compileTemplates(def, h.registry, h.env, h.logger)
 	h.ln, err = net.Listen("tcp", fmt.Sprintf(":%d", def.Port))
 	if err != nil {
 		h.logger.WithError(err).Error("failed to start TCP listener")
@@ -31,6 +45,9 @@ func (h *TCPHandler) Start(def *schema.MockDefinition) error {
 	}
 
 	h.logger.Infof("TCP mock listening on port %d", def.Port)
+	if len(def.Subscribe) > 0 {
+		h.unsubscribe = subscribe(def, h.registry, h.env, h.logger, h.hub.reactBroadcast(h.logger))
+	}
 
 	go func() {
 		for {
@@ -52,10 +69,21 @@ func (h *TCPHandler) Start(def *schema.MockDefinition) error {
 	return nil
 }
 
+// TemplateEnv returns the clock and random source of the mock's templates,
+// nil before Start
+func (h *TCPHandler) TemplateEnv() *template.Env {
+	return h.env
+}
+
 func (h *TCPHandler) Stop() error {
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+	}
 	if h.ln != nil {
 		h.logger.Info("stopping TCP mock")
-		return h.ln.Close()
+		err := h.ln.Close()
+		h.hub.closeAll()
+		return err
 	}
 	return nil
 }
@@ -76,60 +104,46 @@ func (h *TCPHandler) handleConnection(conn net.Conn, def *schema.MockDefinition)
 		return
 	}
 
-	defer conn.Close()
+	p := h.hub.add(func(data []byte, binary bool) error {
+		// Text responses are line oriented, binary ones are sent verbatim
+		if !binary && len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		_, err := conn.Write(data)
+		return err
+	}, conn.Close)
+	defer h.hub.remove(p)
 
 	buf := make([]byte, 2048)
-	n, err := conn.Read(buf)
-	if err != nil {
-		h.logger.WithError(err).Warn("failed to read from TCP client")
-		return
-	}
-
-	rawInput := string(buf[:n])
-	h.logger.WithField("input", rawInput).Info("received message")
-
-	matches := extractVars(rawInput, def.OnMessage.Match)
-	registry := loadExtensions(def.Import, h.logger)
-	ctx := template.MergeContext(matches, nil, def.Context.Variables)
-
-	tpl, err := template.NewRuntime(ctx, registry)
-	if err != nil {
-		h.logger.WithError(err).Error("template runtime creation failed")
-		conn.Write([]byte("error de template"))
-		return
-	}
-
-	for i, cond := range def.OnMessage.Conditions {
-		result, _ := tpl.Render(fmt.Sprintf("cond_%d", i), cond.If)
-		h.logger.WithFields(logrus.Fields{
-			"condition": i,
-			"if":        cond.If,
-			"result":    result,
-		}).Debug("evaluated condition")
-
-		if result == "true" {
-			resp, _ := tpl.Render(fmt.Sprintf("resp_%d", i), cond.Respond)
-			h.logger.WithField("response", resp).Info("sending matched response")
-			if len(resp) > 0 && resp[len(resp)-1] != '\n' {
-				resp += "\n"
-			}
-			if len(resp) > 0 && resp[len(resp)-1] != '\n' {
-				resp += "\n"
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				h.logger.WithError(err).Warn("failed to read from TCP client")
 			}
-			conn.Write([]byte(resp))
 			return
 		}
-	}
 
-	if def.OnMessage.Else != "" {
-		resp, _ := tpl.Render("else", def.OnMessage.Else)
-		h.logger.WithField("response", resp).Info("sending fallback response")
-		if len(resp) > 0 && resp[len(resp)-1] != '\n' {
-			resp += "\n"
+		rawInput := binaryInput(buf[:n], def.OnMessage.Binary)
+		h.logger.WithField("input", rawInput).Info("received message")
+
+		r := h.hub.handleMessage(p, rawInput, def.OnMessage, "onMessage", def, h.registry, h.env, h.logger)
+		if r == nil {
+			continue
+		}
+		if len(r.data) > 0 {
+			if err := p.send(r.data, r.binary); err != nil {
+				h.logger.WithError(err).Warn("failed to write to TCP client")
+				return
+			}
 		}
-		if len(resp) > 0 && resp[len(resp)-1] != '\n' {
-			resp += "\n"
+		if r.close != nil {
+			if tcpConn, ok := conn.(*net.TCPConn); ok && r.close.abrupt {
+				// Discard unsent data and reset the connection
+				tcpConn.SetLinger(0)
+			}
+			h.logger.WithField("abrupt", r.close.abrupt).Info("closing TCP connection")
+			return
 		}
-		conn.Write([]byte(resp))
 	}
 }
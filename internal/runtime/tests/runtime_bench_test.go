@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+// compiledMock is an HTTP mock with an import and inline functions, the
+// templates handlers compile at start
+func compiledMock(t testing.TB, port int) *schema.MockDefinition {
+	t.Helper()
+	kurof := filepath.Join(t.TempDir(), "helpers.kurof")
+	require.NoError(t, os.WriteFile(kurof, []byte(`{{ define "user" }}{"name":"{{ .input.name }}","greeting":"{{ greet .input.name }}"}{{ end }}`), 0644))
+	return &schema.MockDefinition{
+		Protocol:  "http",
+		Port:      port,
+		Import:    []string{kurof},
+		Functions: map[string]string{"greet(name)": `Hello {{ .name | title }}`},
+		Routes: []schema.Route{{
+			Path:   "/users",
+			Method: "POST",
+			Response: schema.ResponseDefinition{
+				Status:  200,
+				Headers: map[string]string{"Content-Type": "application/json", "X-User": "{{ .input.name }}"},
+				Body:    `{"user":{{ template "user" . }},"n":{{ len .input.items }}}`,
+			},
+		}},
+	}
+}
+
+func postUser(client *http.Client, port int, name string) (string, string, error) {
+	body := fmt.Sprintf(`{"name":%q,"items":[1,2,3]}`, name)
+	resp, err := client.Post(fmt.Sprintf("http://localhost:%d/users", port), "application/json", strings.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	return string(out), resp.Header.Get("X-User"), err
+}
+
+func TestHTTPCompiledTemplates(t *testing.T) {
+	// no context block, which used to panic at start
+	def := compiledMock(t, 8108)
+	require.NoError(t, schema.Validate(def))
+	handler := runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("user%d", i)
+			body, header, err := postUser(http.DefaultClient, 8108, name)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, fmt.Sprintf(`{"user":{"name":"%s","greeting":"Hello User%d"},"n":3}`, name, i), body)
+			assert.Equal(t, name, header)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkHTTPRequest(b *testing.B) {
+	def := compiledMock(b, 8107)
+	handler := runtime.NewHTTPHandler()
+	if err := handler.Start(def); err != nil {
+		b.Fatal(err)
+	}
+	defer handler.Stop()
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := postUser(client, 8107, "ana"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkTCPMessage(b *testing.B) {
+	def := &schema.MockDefinition{
+		Protocol:  "tcp",
+		Port:      9350,
+		Functions: map[string]string{"reply(cmd)": `ack {{ .cmd | upper }}`},
+		OnMessage: &schema.OnMessage{
+			Match: `^(?P<cmd>\w+)`,
+			Conditions: []schema.OnMessageRule{
+				{If: `{{ eq .input.cmd "ping" }}`, Respond: `pong`},
+				{If: `{{ ne .input.cmd "" }}`, Respond: `{{ reply .input.cmd }}`},
+			},
+		},
+	}
+	handler := runtime.NewTCPHandler()
+	if err := handler.Start(def); err != nil {
+		b.Fatal(err)
+	}
+	defer handler.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "localhost:9350")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write([]byte("hello\n")); err != nil {
+			b.Fatal(err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			b.Fatal(err)
+		}
+		if line != "ack HELLO\n" {
+			b.Fatalf("unexpected reply %q", line)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+func TestTCPRoomsAndBroadcast(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "tcp",
+		Port:     9111,
+		OnMessage: &schema.OnMessage{
+			Match: `(?P<cmd>\w+)(?:\s+(?P<args>[^\r\n]*))?`,
+			Conditions: []schema.OnMessageRule{
+				{
+					If:      `{{ if eq .input.cmd "NICK" }}true{{ end }}`,
+					Set:     map[string]string{"nick": "{{ .input.args }}"},
+					Respond: "OK {{ .session.nick }}",
+				},
+				{
+					If:      `{{ if eq .input.cmd "JOIN" }}true{{ end }}`,
+					Join:    "{{ .input.args }}",
+					Respond: "JOINED {{ join .session.rooms \",\" }}",
+				},
+				{
+					If:      `{{ if eq .input.cmd "LEAVE" }}true{{ end }}`,
+					Leave:   "{{ .input.args }}",
+					Respond: "LEFT {{ .input.args }}",
+				},
+				{
+					If: `{{ if eq .input.cmd "SAY" }}true{{ end }}`,
+					Broadcast: &schema.Broadcast{
+						Message:     "[dev] {{ .session.nick }}: {{ .input.args }}",
+						Room:        "dev",
+						ExcludeSelf: true,
+					},
+					Respond: "SENT",
+				},
+				{
+					If: `{{ if eq .input.cmd "WHISPER" }}true{{ end }}`,
+					Broadcast: &schema.Broadcast{
+						Message: "whisper from {{ .session.nick }}",
+						To:      map[string]string{"nick": "{{ .input.args }}"},
+					},
+				},
+			},
+		},
+	}
+
+	handler := runtime.NewTCPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	type client struct {
+		conn   net.Conn
+		reader *bufio.Reader
+	}
+	dial := func() *client {
+		conn, err := net.Dial("tcp", "localhost:9111")
+		require.NoError(t, err)
+		return &client{conn: conn, reader: bufio.NewReader(conn)}
+	}
+	send := func(c *client, msg string) string {
+		_, err := c.conn.Write([]byte(msg + "\n"))
+		require.NoError(t, err)
+		return readLine(t, c.reader)
+	}
+
+	alice, bob, carol := dial(), dial(), dial()
+	defer alice.conn.Close()
+	defer bob.conn.Close()
+	defer carol.conn.Close()
+
+	assert.Equal(t, "OK alice\n", send(alice, "NICK alice"))
+	assert.Equal(t, "OK bob\n", send(bob, "NICK bob"))
+	assert.Equal(t, "OK carol\n", send(carol, "NICK carol"))
+
+	assert.Equal(t, "JOINED dev\n", send(alice, "JOIN dev"))
+	assert.Equal(t, "JOINED dev\n", send(bob, "JOIN dev"))
+
+	t.Run("room broadcast reaches members only", func(t *testing.T) {
+		assert.Equal(t, "SENT\n", send(alice, "SAY hello"))
+		assert.Equal(t, "[dev] alice: hello\n", readLine(t, bob.reader))
+
+		carol.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := carol.reader.ReadString('\n')
+		assert.Error(t, err, "carol is not in the room")
+		carol.conn.SetReadDeadline(time.Time{})
+	})
+
+	t.Run("leave stops delivery", func(t *testing.T) {
+		assert.Equal(t, "LEFT dev\n", send(bob, "LEAVE dev"))
+		assert.Equal(t, "SENT\n", send(alice, "SAY anyone?"))
+
+		bob.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := bob.reader.ReadString('\n')
+		assert.Error(t, err, "bob left the room")
+		bob.conn.SetReadDeadline(time.Time{})
+	})
+
+	t.Run("targeted by session attribute", func(t *testing.T) {
+		_, err := bob.conn.Write([]byte("WHISPER carol\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "whisper from bob\n", readLine(t, carol.reader))
+	})
+}
+
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	return line
+}
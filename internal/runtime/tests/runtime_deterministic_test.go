@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+func TestHTTPDeterministicMode(t *testing.T) {
+	seed := int64(2024)
+	def := &schema.MockDefinition{
+		Protocol: "http",
+		Port:     8101,
+		Seed:     &seed,
+		Clock:    &schema.ClockConfig{Freeze: "2024-01-15T10:30:00Z"},
+		Context:  &schema.Context{Variables: map[string]any{}},
+		Routes: []schema.Route{{
+			Path:   "/order",
+			Method: "GET",
+			Response: schema.ResponseDefinition{
+				Status: 200,
+				Body:   `{"id": "{{ uuid }}", "customer": "{{ fakeName }}", "created": "{{ now }}"}`,
+			},
+		}},
+	}
+	require.NoError(t, schema.Validate(def))
+
+	get := func() string {
+		t.Helper()
+		resp, err := http.Get("http://localhost:8101/order")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// Two runs of the same mock answer the same sequence of requests
+	var runs [2][]string
+	for i := range runs {
+		handler := runtime.NewHTTPHandler()
+		require.NoError(t, handler.Start(def))
+		runs[i] = []string{get(), get()}
+		require.NoError(t, handler.Stop())
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, runs[0], runs[1])
+	assert.NotEqual(t, runs[0][0], runs[0][1])
+	assert.Contains(t, runs[0][0], `"created": "2024-01-15T10:30:00Z"`)
+
+	handler := runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+	get()
+
+	handler.TemplateEnv().Clock.Advance(48 * time.Hour)
+	handler.TemplateEnv().Reseed(nil)
+	body := get()
+	assert.Contains(t, body, `"created": "2024-01-17T10:30:00Z"`)
+	assert.Equal(t, runs[0][0][:60], body[:60])
+}
+
+func TestClockConfigValidation(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "http",
+		Routes:   []schema.Route{{Path: "/", Response: schema.ResponseDefinition{Status: 200}}},
+	}
+	for _, clock := range []*schema.ClockConfig{
+		{Freeze: "yesterday"},
+		{Offset: "1 day"},
+		{Freeze: "2024-01-15T10:30:00Z", Offset: "1h"},
+	} {
+		def.Clock = clock
+		assert.Error(t, schema.Validate(def), "%+v", clock)
+	}
+	def.Clock = &schema.ClockConfig{Offset: "-24h"}
+	assert.NoError(t, schema.Validate(def))
+}
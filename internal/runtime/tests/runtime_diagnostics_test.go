@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/loader"
+	"github.com/usekuro/usekuro/internal/runtime"
+)
+
+func TestHTTPTemplateDiagnostics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.kuro")
+	require.NoError(t, os.WriteFile(path, []byte(`protocol: http
+port: 8110
+routes:
+  - path: /orders
+    method: GET
+    response:
+      status: 200
+      body: |
+        {
+          "total": {{ div 10 .input.count }}
+        }
+`), 0644))
+	def, err := loader.LoadMockFromFile(path)
+	require.NoError(t, err)
+
+	handler := runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	get := func() map[string]any {
+		t.Helper()
+		resp, err := http.Get("http://localhost:8110/orders")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		var out map[string]any
+		require.NoError(t, json.Unmarshal(raw, &out), string(raw))
+		return out
+	}
+
+	out := get()
+	assert.Equal(t, "template rendering failed", out["error"])
+	assert.NotContains(t, out, "diagnostic", "only dev mode returns the diagnostic")
+
+	runtime.DevMode = true
+	defer func() { runtime.DevMode = false }()
+	out = get()
+	require.Contains(t, out, "diagnostic")
+	d := out["diagnostic"].(map[string]any)
+	assert.Equal(t, path, d["file"])
+	assert.EqualValues(t, 10, d["line"])
+	assert.EqualValues(t, 23, d["column"], "the column of div in the file")
+	assert.Equal(t, "routes[0].response.body", d["field"])
+	assert.Equal(t, "routes[0].response.body:2:14", d["template"])
+	assert.Contains(t, d["message"], "is not a number")
+}
+
+func TestTCPTemplateDiagnostics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "echo.kuro")
+	require.NoError(t, os.WriteFile(path, []byte(`protocol: tcp
+port: 9352
+functions:
+  half(n): '{{ div .n 0 }}'
+onMessage:
+  match: '^(?P<cmd>\w+)'
+  conditions:
+    - if: '{{ eq .input.cmd "half" }}'
+      respond: '{{ half 4 }}'
+  else: ok
+`), 0644))
+	def, err := loader.LoadMockFromFile(path)
+	require.NoError(t, err)
+
+	handler := runtime.NewTCPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "localhost:9352")
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(msg string) string {
+		t.Helper()
+		_, err := conn.Write([]byte(msg + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		return line
+	}
+
+	assert.Equal(t, "template error\n", send("half"), "render errors are no longer ignored")
+
+	runtime.DevMode = true
+	defer func() { runtime.DevMode = false }()
+	reply := send("half")
+	assert.Contains(t, reply, "template error: "+path+":9:20: onMessage.conditions[0].respond: ")
+	assert.Contains(t, reply, "division by zero")
+	assert.Equal(t, "ok\n", send("hello"), "the connection keeps working")
+}
@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+func TestEventsAcrossProtocols(t *testing.T) {
+	useTempSettings(t)
+
+	orders := &schema.MockDefinition{
+		Protocol: "http",
+		Port:     8104,
+		Meta:     schema.Meta{Name: "orders-api"},
+		Context:  &schema.Context{Variables: map[string]any{}},
+		Routes: []schema.Route{{
+			Path:     "/orders",
+			Method:   "POST",
+			Response: schema.ResponseDefinition{Status: 201, Body: `{"id": {{ .input.id }}}`},
+			Publish:  &schema.PublishAction{Event: "orders.created", Data: `{{ toJSON .input }}`},
+		}},
+		Subscribe: []schema.Subscription{{
+			Event: "orders.filed",
+			Do:    `{{ store.Set "filed" .input.path }}`,
+		}},
+	}
+	updates := &schema.MockDefinition{
+		Protocol: "ws",
+		Port:     9341,
+		Context:  &schema.Context{Variables: map[string]any{}},
+		OnMessage: &schema.OnMessage{
+			Conditions: []schema.OnMessageRule{{If: `true`, Respond: "subscribed"}},
+		},
+		Subscribe: []schema.Subscription{{
+			Event:     "orders.*",
+			If:        `{{ ne .event.name "orders.filed" }}`,
+			Broadcast: &schema.Broadcast{Message: `{"event": "{{ .event.name }}", "id": {{ .input.id }}, "from": "{{ .event.source }}"}`},
+		}},
+	}
+	files := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9313,
+		Files:    []schema.FileEntry{{Path: "/readme.txt", Content: "orders"}},
+		SFTPAuth: &schema.SFTPAuth{Username: "user", Password: "pass"},
+		SFTP:     &schema.SFTPConfig{InMemory: true},
+		Subscribe: []schema.Subscription{{
+			Event:   "orders.created",
+			Write:   []schema.FileEntry{{Path: "/orders/{{ .input.id }}.json", Content: `{{ toJSON .input }}`}},
+			Publish: &schema.PublishAction{Event: "orders.filed", Data: `{"path": "/orders/{{ .input.id }}.json"}`},
+		}},
+	}
+	for _, def := range []*schema.MockDefinition{orders, updates, files} {
+		require.NoError(t, schema.Validate(def), def.Protocol)
+	}
+
+	httpHandler := runtime.NewHTTPHandler()
+	require.NoError(t, httpHandler.Start(orders))
+	defer httpHandler.Stop()
+	wsHandler := runtime.NewWSHandler()
+	require.NoError(t, wsHandler.Start(updates))
+	defer wsHandler.Stop()
+	sftpHandler := runtime.NewSFTPHandler()
+	require.NoError(t, sftpHandler.Start(files))
+	defer sftpHandler.Stop()
+	time.Sleep(200 * time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9341/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "subscribed", string(msg))
+
+	resp, err := http.Post("http://localhost:8104/orders", "application/json", strings.NewReader(`{"id": 42, "total": 9.5}`))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, `{"id": 42}`, string(body))
+
+	// The WS mock pushes the order to its clients
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"event": "orders.created", "id": 42, "from": "orders-api"}`, string(msg))
+
+	// The SFTP mock files it and tells the HTTP mock where
+	client, err := dialSFTP(9313, "user", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer client.Close()
+	require.Eventually(t, func() bool {
+		return httpHandler.TemplateEnv().Store.Get("filed") == "/orders/42.json"
+	}, 2*time.Second, 20*time.Millisecond)
+	f, err := client.Open("/orders/42.json")
+	require.NoError(t, err)
+	content, _ := io.ReadAll(f)
+	f.Close()
+	assert.JSONEq(t, `{"id": 42, "total": 9.5}`, string(content))
+}
+
+func TestEventLoopsStop(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "http",
+		Port:     8105,
+		Context:  &schema.Context{Variables: map[string]any{}},
+		Routes: []schema.Route{{
+			Path:     "/ping",
+			Method:   "GET",
+			Response: schema.ResponseDefinition{Status: 200, Body: "ok"},
+			Publish:  &schema.PublishAction{Event: "loop.ping"},
+		}},
+		Subscribe: []schema.Subscription{{
+			Event:   "loop.ping",
+			Do:      `{{ store.Incr "pings" }}`,
+			Publish: &schema.PublishAction{Event: "loop.ping"},
+		}},
+	}
+	handler := runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	resp, err := http.Get("http://localhost:8105/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Eventually(t, func() bool {
+		return handler.TemplateEnv().Store.Get("pings") == int64(8)
+	}, 2*time.Second, 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int64(8), handler.TemplateEnv().Store.Get("pings"))
+}
+
+func TestEventLoopsThroughPublishStop(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "http",
+		Port:     8111,
+		Context:  &schema.Context{Variables: map[string]any{}},
+		Routes: []schema.Route{{
+			Path:     "/ping",
+			Method:   "GET",
+			Response: schema.ResponseDefinition{Status: 200, Body: `{{ publish "loop.tpl" }}ok`},
+		}},
+		Subscribe: []schema.Subscription{{
+			Event: "loop.tpl",
+			Do:    `{{ store.Incr "pings" }}{{ publish "loop.tpl" }}`,
+		}},
+	}
+	handler := runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	resp, err := http.Get("http://localhost:8111/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Eventually(t, func() bool {
+		return handler.TemplateEnv().Store.Get("pings") == int64(8)
+	}, 2*time.Second, 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int64(8), handler.TemplateEnv().Store.Get("pings"), "the publish function carries the depth too")
+}
+
+func TestSubscriptionValidation(t *testing.T) {
+	base := func(protocol string, sub schema.Subscription) *schema.MockDefinition {
+		return &schema.MockDefinition{
+			Protocol:  protocol,
+			Routes:    []schema.Route{{Path: "/", Response: schema.ResponseDefinition{Status: 200}}},
+			OnMessage: &schema.OnMessage{},
+			Subscribe: []schema.Subscription{sub},
+		}
+	}
+	for name, def := range map[string]*schema.MockDefinition{
+		"subscribe[0]: 'event' is required": base("http", schema.Subscription{Do: "x"}),
+		"invalid event pattern":             base("http", schema.Subscription{Event: "a.[", Do: "x"}),
+		"only supported by tcp and ws":      base("http", schema.Subscription{Event: "a", Broadcast: &schema.Broadcast{}}),
+		"only supported by sftp":            base("ws", schema.Subscription{Event: "a", Write: []schema.FileEntry{{Path: "/x"}}}),
+		"'do', 'broadcast'":                 base("tcp", schema.Subscription{Event: "a"}),
+		"publish: 'event' is required":      base("tcp", schema.Subscription{Event: "a", Publish: &schema.PublishAction{}}),
+	} {
+		err := schema.Validate(def)
+		require.Error(t, err, name)
+		assert.Contains(t, err.Error(), name)
+	}
+}
@@ -0,0 +1,334 @@
+package tests
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+// ftpConn is a minimal FTP client speaking the control protocol directly
+type ftpConn struct {
+	t       *testing.T
+	conn    net.Conn
+	text    *textproto.Conn
+	private bool
+}
+
+func dialFTP(t *testing.T, port int, implicit bool) *ftpConn {
+	t.Helper()
+	addr := fmt.Sprintf("localhost:%d", port)
+	var conn net.Conn
+	var err error
+	if implicit {
+		conn, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 2*time.Second)
+	}
+	require.NoError(t, err)
+	c := &ftpConn{t: t, conn: conn, text: textproto.NewConn(conn)}
+	t.Cleanup(func() { c.text.Close() })
+
+	_, _, err = c.text.ReadResponse(220)
+	require.NoError(t, err)
+	return c
+}
+
+// cmd sends a command and checks the reply code
+func (c *ftpConn) cmd(code int, format string, args ...any) string {
+	c.t.Helper()
+	_, err := c.text.Cmd(format, args...)
+	require.NoError(c.t, err)
+	got, msg, err := c.text.ReadResponse(code)
+	require.NoError(c.t, err, "%s: got %d %s", fmt.Sprintf(format, args...), got, msg)
+	return msg
+}
+
+func (c *ftpConn) login(user, pass string) {
+	c.t.Helper()
+	c.cmd(331, "USER %s", user)
+	c.cmd(230, "PASS %s", pass)
+}
+
+func (c *ftpConn) startTLS() {
+	c.t.Helper()
+	c.cmd(234, "AUTH TLS")
+	conn := tls.Client(c.conn, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(c.t, conn.Handshake())
+	c.conn, c.text = conn, textproto.NewConn(conn)
+}
+
+var epsvPort = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+var pasvAddr = regexp.MustCompile(`\((\d+),(\d+),(\d+),(\d+),(\d+),(\d+)\)`)
+
+// data opens a passive data connection, with PASV or EPSV
+func (c *ftpConn) data(extended bool) net.Conn {
+	c.t.Helper()
+	var addr string
+	if extended {
+		m := epsvPort.FindStringSubmatch(c.cmd(229, "EPSV"))
+		require.NotNil(c.t, m)
+		addr = "localhost:" + m[1]
+	} else {
+		m := pasvAddr.FindStringSubmatch(c.cmd(227, "PASV"))
+		require.NotNil(c.t, m)
+		hi, _ := strconv.Atoi(m[5])
+		lo, _ := strconv.Atoi(m[6])
+		addr = fmt.Sprintf("%s.%s.%s.%s:%d", m[1], m[2], m[3], m[4], hi<<8|lo)
+	}
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	require.NoError(c.t, err)
+	if c.private {
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	return conn
+}
+
+// retrieve runs a command that sends data to the client, such as RETR or LIST
+func (c *ftpConn) retrieve(format string, args ...any) string {
+	c.t.Helper()
+	conn := c.data(true)
+	c.cmd(150, format, args...)
+	data, err := io.ReadAll(conn)
+	require.NoError(c.t, err)
+	conn.Close()
+	c.cmd226()
+	return string(data)
+}
+
+// store runs a command that sends data to the server, such as STOR or APPE
+func (c *ftpConn) store(content string, format string, args ...any) (int, string) {
+	c.t.Helper()
+	conn := c.data(true)
+	c.cmd(150, format, args...)
+	_, err := io.WriteString(conn, content)
+	require.NoError(c.t, err)
+	require.NoError(c.t, conn.Close())
+	code, msg, _ := c.text.ReadResponse(0)
+	return code, msg
+}
+
+func (c *ftpConn) cmd226() {
+	c.t.Helper()
+	_, _, err := c.text.ReadResponse(226)
+	require.NoError(c.t, err)
+}
+
+func startFTPMock(t *testing.T, port int, cfg *schema.FTPConfig) *runtime.FTPHandler {
+	t.Helper()
+	useTempSettings(t)
+	def := &schema.MockDefinition{
+		Protocol: "ftp",
+		Port:     port,
+		SFTPAuth: &schema.SFTPAuth{Username: "admin", Password: "admin"},
+		Context:  &schema.Context{Variables: map[string]any{"bank": "ACME Bank"}},
+		Files: []schema.FileEntry{
+			{Path: "/outbox/statement.csv", Content: "date,amount\n2024-01-01,100\n"},
+			{Path: "/archive/2023.csv", Content: "old"},
+		},
+		SFTP: &schema.SFTPConfig{
+			InMemory: true,
+			Dirs:     []schema.DirEntry{{Path: "/inbox"}, {Path: "/archive", ReadOnly: true}},
+			Quota:    "1KB",
+		},
+		FTP: cfg,
+	}
+	handler := runtime.NewFTPHandler()
+	require.NoError(t, handler.Start(def))
+	t.Cleanup(func() { handler.Stop() })
+	return handler
+}
+
+func TestFTPTransfers(t *testing.T) {
+	handler := startFTPMock(t, 9330, &schema.FTPConfig{Welcome: "{{ .context.bank }} file exchange"})
+
+	c := dialFTP(t, 9330, false)
+	c.cmd(530, "LIST")
+	c.cmd(331, "USER admin")
+	c.cmd(530, "PASS wrong")
+	c.login("admin", "admin")
+	assert.Equal(t, `"/" is the current directory.`, c.cmd(257, "PWD"))
+	c.cmd(200, "TYPE I")
+
+	t.Run("list and retrieve", func(t *testing.T) {
+		c.cmd(250, "CWD outbox")
+		listing := c.retrieve("LIST -la")
+		assert.Regexp(t, `^-rw-r--r-- 1 ftp\s+ftp\s+27 .+ statement.csv\r\n$`, listing)
+		assert.Equal(t, "statement.csv\r\n", c.retrieve("NLST"))
+
+		assert.Equal(t, "27", c.cmd(213, "SIZE statement.csv"))
+		assert.Equal(t, "date,amount\n2024-01-01,100\n", c.retrieve("RETR statement.csv"))
+
+		c.cmd(350, "REST 12")
+		assert.Equal(t, "2024-01-01,100\n", c.retrieve("RETR /outbox/statement.csv"))
+		c.cmd(550, "RETR missing.csv")
+		c.cmd(250, "CDUP")
+	})
+
+	t.Run("store and append", func(t *testing.T) {
+		code, _ := c.store("line 1\n", "STOR /inbox/payments.txt")
+		assert.Equal(t, 226, code)
+		code, _ = c.store("line 2\n", "APPE /inbox/payments.txt")
+		assert.Equal(t, 226, code)
+
+		data, err := handler.ReadFile("/inbox/payments.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "line 1\nline 2\n", string(data))
+
+		uploads := handler.Uploads()
+		require.Len(t, uploads, 2)
+		assert.Equal(t, "admin", uploads[0].User)
+		assert.Equal(t, "/inbox/payments.txt", uploads[1].Path)
+		assert.Equal(t, int64(14), uploads[1].Size)
+	})
+
+	t.Run("file commands", func(t *testing.T) {
+		c.cmd(257, "MKD /inbox/batch")
+		c.cmd(350, "RNFR /inbox/payments.txt")
+		c.cmd(250, "RNTO /inbox/batch/payments.txt")
+		c.cmd(550, "SIZE /inbox/payments.txt")
+		c.cmd(250, "DELE /inbox/batch/payments.txt")
+		c.cmd(250, "RMD /inbox/batch")
+
+		ops := handler.Operations()
+		var names []string
+		for _, op := range ops {
+			names = append(names, op.Op)
+		}
+		assert.Subset(t, names, []string{"mkdir", "rename", "remove", "rmdir"})
+	})
+
+	t.Run("policy", func(t *testing.T) {
+		c.cmd(550, "STOR /archive/new.csv")
+		c.cmd(550, "DELE /archive/2023.csv")
+
+		code, msg := c.store(strings.Repeat("x", 2048), "STOR /inbox/big.bin")
+		assert.Equal(t, 552, code, msg)
+	})
+
+	c.cmd(502, "PORT 127,0,0,1,4,1")
+	c.cmd(221, "QUIT")
+}
+
+func TestFTPPassiveRejectsOtherHosts(t *testing.T) {
+	startFTPMock(t, 9361, nil)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:9361", 2*time.Second)
+	require.NoError(t, err)
+	c := &ftpConn{t: t, conn: conn, text: textproto.NewConn(conn)}
+	t.Cleanup(func() { c.text.Close() })
+	_, _, err = c.text.ReadResponse(220)
+	require.NoError(t, err)
+	c.login("admin", "admin")
+
+	m := epsvPort.FindStringSubmatch(c.cmd(229, "EPSV"))
+	require.NotNil(t, m)
+	addr := "127.0.0.1:" + m[1]
+
+	// another host reaches the data port first
+	thief, err := (&net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}, Timeout: 2 * time.Second}).Dial("tcp", addr)
+	require.NoError(t, err)
+	defer thief.Close()
+	data, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	require.NoError(t, err)
+
+	c.cmd(150, "RETR /outbox/statement.csv")
+	got, err := io.ReadAll(data)
+	require.NoError(t, err)
+	data.Close()
+	c.cmd226()
+	assert.Equal(t, "date,amount\n2024-01-01,100\n", string(got))
+
+	thief.SetReadDeadline(time.Now().Add(time.Second))
+	stolen, _ := io.ReadAll(thief)
+	assert.Empty(t, stolen, "the other host gets nothing")
+}
+
+func TestFTPSExplicit(t *testing.T) {
+	startFTPMock(t, 9331, &schema.FTPConfig{TLS: "explicit"})
+
+	c := dialFTP(t, 9331, false)
+	feat := c.cmd(211, "FEAT")
+	assert.Contains(t, feat, "AUTH TLS")
+	c.cmd(530, "USER admin")
+
+	c.startTLS()
+	c.login("admin", "admin")
+
+	// Data connections must be encrypted too
+	conn := c.data(true)
+	c.cmd(521, "RETR /outbox/statement.csv")
+	conn.Close()
+
+	c.cmd(200, "PBSZ 0")
+	c.cmd(200, "PROT P")
+	c.private = true
+	assert.Equal(t, "date,amount\n2024-01-01,100\n", c.retrieve("RETR /outbox/statement.csv"))
+	code, _ := c.store("secret", "STOR /inbox/secret.txt")
+	assert.Equal(t, 226, code)
+	assert.Equal(t, "secret", c.retrieve("RETR /inbox/secret.txt"))
+}
+
+func TestFTPSImplicit(t *testing.T) {
+	startFTPMock(t, 9332, &schema.FTPConfig{TLS: "implicit", PassivePorts: "9333-9334"})
+
+	c := dialFTP(t, 9332, true)
+	c.login("admin", "admin")
+	c.cmd(200, "PROT P")
+	c.private = true
+
+	conn := c.data(false)
+	port := conn.RemoteAddr().(*net.TCPAddr).Port
+	assert.True(t, port == 9333 || port == 9334, "passive port %d", port)
+	c.cmd(150, "NLST /outbox")
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "statement.csv\r\n", string(data))
+	c.cmd226()
+}
+
+func TestFTPUserHomes(t *testing.T) {
+	useTempSettings(t)
+	def := &schema.MockDefinition{
+		Protocol: "ftp",
+		Port:     9312,
+		SFTPAuth: &schema.SFTPAuth{
+			Username: "admin",
+			Password: "admin",
+			Users: []schema.SFTPUser{
+				{Username: "acme", Password: "acme-pass", Home: "/partners/acme", Dirs: []schema.DirEntry{{Path: "inbox"}, {Path: "outbox"}}},
+				{Username: "globex", PublicKeyPath: getTestdataPath("id_rsa.pub"), Auth: "publickey", Home: "/partners/globex"},
+			},
+		},
+		SFTP: &schema.SFTPConfig{InMemory: true},
+	}
+	handler := runtime.NewFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	c := dialFTP(t, 9312, false)
+	c.cmd(331, "USER globex")
+	c.cmd(530, "PASS anything")
+
+	c.login("acme", "acme-pass")
+	assert.Equal(t, "inbox\r\noutbox\r\n", c.retrieve("NLST /"))
+	c.cmd(250, "CWD ../..")
+	assert.Equal(t, `"/" is the current directory.`, c.cmd(257, "PWD"))
+
+	code, _ := c.store("ack", "STOR outbox/ack.txt")
+	assert.Equal(t, 226, code)
+	data, err := handler.ReadFile("/partners/acme/outbox/ack.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "ack", string(data))
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+)
+
+func TestHTTPLimits(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "http",
+		Port:     8109,
+		Limits:   &schema.LimitsConfig{Timeout: "100ms", MaxOutput: "1KB", MaxDepth: 4},
+		Routes: []schema.Route{
+			{Path: "/spin", Method: "GET", Response: schema.ResponseDefinition{Status: 200, Body: `{{ range 1000000000 }}{{ end }}`}},
+			{Path: "/flood", Method: "GET", Response: schema.ResponseDefinition{Status: 200, Body: `{{ range repeat 10000 }}0123456789{{ end }}`}},
+			{Path: "/header", Method: "GET", Response: schema.ResponseDefinition{
+				Status:  200,
+				Headers: map[string]string{"X-Flood": `{{ range repeat 200 }}0123456789{{ end }}`},
+				Body:    "ok",
+			}},
+			{Path: "/ok", Method: "GET", Response: schema.ResponseDefinition{Status: 200, Body: `ok`}},
+		},
+	}
+	require.NoError(t, schema.Validate(def))
+	handler := runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get("http://localhost:8109" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		var out struct {
+			Error string `json:"error"`
+		}
+		if resp.StatusCode != http.StatusInternalServerError {
+			return resp.StatusCode, string(raw)
+		}
+		require.NoError(t, json.Unmarshal(raw, &out), string(raw))
+		return resp.StatusCode, out.Error
+	}
+
+	start := time.Now()
+	status, msg := get("/spin")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "render exceeded limits.timeout of 100ms", msg)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	status, msg = get("/flood")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "render exceeded limits.maxOutput of 1024 bytes", msg)
+
+	status, msg = get("/header")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, msg, "limits.maxOutput")
+
+	status, msg = get("/ok")
+	assert.Equal(t, http.StatusOK, status, "the mock keeps serving")
+	assert.Equal(t, "ok", msg)
+}
+
+func TestTCPLimits(t *testing.T) {
+	kurof := filepath.Join(t.TempDir(), "rec.kurof")
+	require.NoError(t, os.WriteFile(kurof, []byte(`{{ define "rec" }}{{ template "rec" . }}{{ end }}`), 0644))
+	def := &schema.MockDefinition{
+		Protocol: "tcp",
+		Port:     9351,
+		Import:   []string{kurof},
+		Limits:   &schema.LimitsConfig{MaxDepth: 8, Allow: []string{"publish"}},
+		OnMessage: &schema.OnMessage{
+			Match: `^(?P<cmd>\w+)`,
+			Conditions: []schema.OnMessageRule{
+				{If: `{{ eq .input.cmd "rec" }}`, Respond: `{{ template "rec" . }}`},
+				{If: `{{ eq .input.cmd "global" }}`, Respond: `{{ globalStore.Get "x" }}`},
+			},
+			Else: "ok",
+		},
+	}
+	require.NoError(t, schema.Validate(def))
+	handler := runtime.NewTCPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "localhost:9351")
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(msg string) string {
+		t.Helper()
+		_, err := conn.Write([]byte(msg + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		return line
+	}
+
+	assert.Equal(t, "template error\n", send("rec"))
+	assert.Equal(t, "template error\n", send("global"))
+	assert.Equal(t, "ok\n", send("hello"), "the connection keeps working")
+}
+
+func TestLimitsValidation(t *testing.T) {
+	base := func(l *schema.LimitsConfig) *schema.MockDefinition {
+		return &schema.MockDefinition{
+			Protocol: "http",
+			Routes:   []schema.Route{{Path: "/", Response: schema.ResponseDefinition{Status: 200}}},
+			Limits:   l,
+		}
+	}
+	for name, def := range map[string]*schema.MockDefinition{
+		`limits.timeout: invalid duration "soon"`: base(&schema.LimitsConfig{Timeout: "soon"}),
+		`limits.timeout: invalid duration "-1s"`:  base(&schema.LimitsConfig{Timeout: "-1s"}),
+		`limits.maxOutput: invalid size "lots"`:   base(&schema.LimitsConfig{MaxOutput: "lots"}),
+		`limits.maxDepth: must not be negative`:   base(&schema.LimitsConfig{MaxDepth: -1}),
+	} {
+		err := schema.Validate(def)
+		require.Error(t, err, name)
+		assert.Contains(t, err.Error(), name)
+	}
+	assert.NoError(t, schema.Validate(base(&schema.LimitsConfig{Timeout: "250ms", MaxOutput: "2MB", MaxDepth: 10})))
+
+	handler := runtime.NewHTTPHandler()
+	err := handler.Start(base(&schema.LimitsConfig{Allow: []string{"exec"}}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `limits.allow: "exec" is not a risky function`)
+}
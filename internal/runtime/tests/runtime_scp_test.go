@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+// scpConn drives the server side of an scp command the way an scp client does
+type scpConn struct {
+	t       *testing.T
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+}
+
+func startSCP(t *testing.T, client *ssh.Client, command string) *scpConn {
+	t.Helper()
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	t.Cleanup(func() { session.Close() })
+
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := session.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Start(command))
+	return &scpConn{t: t, session: session, stdin: stdin, stdout: bufio.NewReader(stdout)}
+}
+
+func (c *scpConn) send(s string) {
+	c.t.Helper()
+	_, err := io.WriteString(c.stdin, s)
+	require.NoError(c.t, err)
+}
+
+// response reads an ack, returning the message of a warning or error
+func (c *scpConn) response() string {
+	c.t.Helper()
+	b, err := c.stdout.ReadByte()
+	require.NoError(c.t, err)
+	if b == 0 {
+		return ""
+	}
+	msg, err := c.stdout.ReadString('\n')
+	require.NoError(c.t, err)
+	return msg
+}
+
+func (c *scpConn) line() string {
+	c.t.Helper()
+	line, err := c.stdout.ReadString('\n')
+	require.NoError(c.t, err)
+	return line
+}
+
+func (c *scpConn) exitStatus() int {
+	c.t.Helper()
+	c.stdin.Close()
+	err := c.session.Wait()
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	require.NoError(c.t, err)
+	return 0
+}
+
+func TestSCPSharesTreeWithSFTP(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9322,
+		SFTPAuth: &schema.SFTPAuth{Username: "admin", Password: "admin"},
+		Files: []schema.FileEntry{
+			{Path: "/outbox/report.csv", Content: "id,total\n1,10\n"},
+		},
+		SFTP: &schema.SFTPConfig{
+			InMemory: true,
+			Dirs:     []schema.DirEntry{{Path: "/inbox"}, {Path: "/archive", ReadOnly: true}},
+		},
+	}
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	client := dialSSH(t, 9322)
+	sftpClient, err := sftp.NewClient(client)
+	require.NoError(t, err)
+	defer sftpClient.Close()
+
+	t.Run("upload", func(t *testing.T) {
+		scp := startSCP(t, client, "scp -t /inbox")
+		assert.Empty(t, scp.response())
+		scp.send("C0640 5 hello.txt\n")
+		assert.Empty(t, scp.response())
+		scp.send("hello\x00")
+		assert.Empty(t, scp.response())
+		assert.Equal(t, 0, scp.exitStatus())
+
+		f, err := sftpClient.Open("/inbox/hello.txt")
+		require.NoError(t, err)
+		data, _ := io.ReadAll(f)
+		f.Close()
+		assert.Equal(t, "hello", string(data))
+
+		info, err := sftpClient.Stat("/inbox/hello.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "-rw-r-----", info.Mode().String())
+
+		uploads := handler.Uploads()
+		require.Len(t, uploads, 1)
+		assert.Equal(t, "/inbox/hello.txt", uploads[0].Path)
+		assert.Equal(t, int64(5), uploads[0].Size)
+	})
+
+	t.Run("recursive upload with times", func(t *testing.T) {
+		scp := startSCP(t, client, "scp -r -p -t -- /inbox")
+		assert.Empty(t, scp.response())
+		scp.send("D0755 0 batch\n")
+		assert.Empty(t, scp.response())
+		scp.send("T1700000000 0 1700000000 0\n")
+		assert.Empty(t, scp.response())
+		scp.send("C0644 3 a.txt\n")
+		assert.Empty(t, scp.response())
+		scp.send("abc\x00")
+		assert.Empty(t, scp.response())
+		scp.send("E\n")
+		assert.Empty(t, scp.response())
+		assert.Equal(t, 0, scp.exitStatus())
+
+		info, err := sftpClient.Stat("/inbox/batch/a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), info.Size())
+		assert.Equal(t, int64(1700000000), info.ModTime().Unix())
+	})
+
+	t.Run("download", func(t *testing.T) {
+		// A file written over SFTP is visible to scp
+		f, err := sftpClient.Create("/outbox/extra.txt")
+		require.NoError(t, err)
+		f.Write([]byte("via sftp"))
+		f.Close()
+
+		scp := startSCP(t, client, "scp -f /outbox/report.csv '/outbox/extra.txt'")
+		scp.send("\x00")
+		assert.Equal(t, "C0644 14 report.csv\n", scp.line())
+		scp.send("\x00")
+		data := make([]byte, 15)
+		_, err = io.ReadFull(scp.stdout, data)
+		require.NoError(t, err)
+		assert.Equal(t, "id,total\n1,10\n\x00", string(data))
+		scp.send("\x00")
+
+		assert.Equal(t, "C0644 8 extra.txt\n", scp.line())
+		scp.send("\x00")
+		data = make([]byte, 9)
+		_, err = io.ReadFull(scp.stdout, data)
+		require.NoError(t, err)
+		assert.Equal(t, "via sftp\x00", string(data))
+		scp.send("\x00")
+		assert.Equal(t, 0, scp.exitStatus())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		scp := startSCP(t, client, "scp -f /missing.txt")
+		scp.send("\x00")
+		assert.Equal(t, "scp: /missing.txt: No such file or directory\n", scp.response())
+		assert.Equal(t, 1, scp.exitStatus())
+
+		scp = startSCP(t, client, "scp -t /archive/new.txt")
+		assert.Empty(t, scp.response())
+		scp.send("C0644 2 new.txt\n")
+		assert.Equal(t, "scp: /archive/new.txt: Permission denied\n", scp.response())
+		assert.Equal(t, 1, scp.exitStatus())
+	})
+}
+
+func TestSCPAttributesFollowThePolicy(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9358,
+		SFTPAuth: &schema.SFTPAuth{Username: "admin", Password: "admin"},
+		SFTP: &schema.SFTPConfig{
+			InMemory: true,
+			Dirs:     []schema.DirEntry{{Path: "/locked"}},
+			Faults:   []schema.SFTPFault{{Path: "/locked/*", Op: "setstat", Error: "permission"}},
+		},
+	}
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	client := dialSSH(t, 9358)
+	sftpClient, err := sftp.NewClient(client)
+	require.NoError(t, err)
+	defer sftpClient.Close()
+
+	scp := startSCP(t, client, "scp -p -t /locked")
+	assert.Empty(t, scp.response())
+	scp.send("T1700000000 0 1700000000 0\n")
+	assert.Empty(t, scp.response())
+	scp.send("C0600 2 a.txt\n")
+	assert.Empty(t, scp.response())
+	scp.send("ok\x00")
+	assert.Empty(t, scp.response())
+	assert.Equal(t, 0, scp.exitStatus())
+
+	info, err := sftpClient.Stat("/locked/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), info.Size(), "the upload itself is allowed")
+	assert.Equal(t, "-rw-r--r--", info.Mode().String(), "setstat is denied, so the mode is not changed")
+	assert.NotEqual(t, int64(1700000000), info.ModTime().Unix())
+
+	var denied int
+	for _, op := range handler.Operations() {
+		if op.Op == "setstat" && op.Error != "" {
+			denied++
+		}
+	}
+	assert.Equal(t, 2, denied, "the denied mode and times are recorded")
+}
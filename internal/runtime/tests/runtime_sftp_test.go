@@ -1,4 +1,911 @@
 package tests
 
-// This file is intentionally left empty as a placeholder.
-// Comprehensive SFTP tests are implemented in sftp_comprehensive_test.go
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	runtime2 "runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+func getTestdataPath(filename string) string {
+	_, currentFile, _, _ := runtime2.Caller(0)
+	baseDir := filepath.Join(filepath.Dir(currentFile), "..", "..", "..", "tests", "testdata")
+	return filepath.Join(baseDir, filename)
+}
+
+// useTempSettings runs the test from a temporary directory, so the host keys
+// SFTP mocks generate under settings/ do not end up in the source tree
+func useTempSettings(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func dialSFTP(port int, user string, auth ...ssh.AuthMethod) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", fmt.Sprintf("localhost:%d", port), &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func TestSFTPAuthentication(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9301,
+		Files:    []schema.FileEntry{{Path: "/readme.txt", Content: "hello"}},
+		SFTPAuth: &schema.SFTPAuth{
+			Username: "alice",
+			Password: "secret",
+			Users: []schema.SFTPUser{
+				{Username: "bob", Password: "builder"},
+				{Username: "deploy", PublicKeyPath: getTestdataPath("id_rsa.pub")},
+			},
+			MaxAttempts: 2,
+		},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	t.Run("valid passwords", func(t *testing.T) {
+		for user, pass := range map[string]string{"alice": "secret", "bob": "builder"} {
+			client, err := dialSFTP(9301, user, ssh.Password(pass))
+			require.NoError(t, err, user)
+			client.Close()
+		}
+	})
+
+	t.Run("wrong password and unknown user", func(t *testing.T) {
+		_, err := dialSFTP(9301, "bob", ssh.Password("secret"))
+		assert.Error(t, err)
+		_, err = dialSFTP(9301, "mallory", ssh.Password("secret"))
+		assert.Error(t, err)
+	})
+
+	t.Run("public key", func(t *testing.T) {
+		keyBytes, err := os.ReadFile(getTestdataPath("id_rsa"))
+		require.NoError(t, err)
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		require.NoError(t, err)
+
+		client, err := dialSFTP(9301, "deploy", ssh.PublicKeys(signer))
+		require.NoError(t, err)
+		client.Close()
+
+		_, err = dialSFTP(9301, "alice", ssh.PublicKeys(signer))
+		assert.Error(t, err, "key is not authorized for alice")
+	})
+
+	t.Run("lockout after max attempts", func(t *testing.T) {
+		_, err := dialSFTP(9301, "alice", ssh.Password("nope"))
+		assert.Error(t, err)
+		_, err = dialSFTP(9301, "alice", ssh.Password("nope"))
+		assert.Error(t, err)
+
+		_, err = dialSFTP(9301, "alice", ssh.Password("secret"))
+		assert.Error(t, err, "alice is locked out")
+
+		client, err := dialSFTP(9301, "bob", ssh.Password("builder"))
+		require.NoError(t, err, "other users are unaffected")
+		client.Close()
+	})
+}
+
+// offeredKey offers a public key without holding its private key, as anyone
+// can with the keys a user publishes
+type offeredKey struct {
+	key ssh.PublicKey
+}
+
+func (o offeredKey) PublicKey() ssh.PublicKey { return o.key }
+
+func (o offeredKey) Sign(io.Reader, []byte) (*ssh.Signature, error) {
+	return nil, fmt.Errorf("no private key")
+}
+
+func TestSFTPLockoutIgnoresOfferedKeys(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9354,
+		Files:    []schema.FileEntry{{Path: "/readme.txt", Content: "hello"}},
+		SFTPAuth: &schema.SFTPAuth{
+			Users: []schema.SFTPUser{
+				{Username: "deploy", Password: "secret", PublicKeyPath: getTestdataPath("id_rsa.pub")},
+			},
+			MaxAttempts: 2,
+		},
+	}
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	keyBytes, err := os.ReadFile(getTestdataPath("id_rsa"))
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	require.NoError(t, err)
+	offer := ssh.PublicKeys(offeredKey{key: signer.PublicKey()})
+
+	_, err = dialSFTP(9354, "deploy", ssh.Password("guess1"))
+	assert.Error(t, err)
+	_, err = dialSFTP(9354, "deploy", offer)
+	assert.Error(t, err, "an offered key without its signature does not log in")
+	_, err = dialSFTP(9354, "deploy", ssh.Password("guess2"))
+	assert.Error(t, err)
+
+	_, err = dialSFTP(9354, "deploy", ssh.Password("secret"))
+	assert.Error(t, err, "offering the key did not reset the failed attempts")
+}
+
+func TestSFTPKeyLoginLoggedOnceVerified(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9363,
+		Files:    []schema.FileEntry{{Path: "/readme.txt", Content: "hello"}},
+		SFTPAuth: &schema.SFTPAuth{
+			Users: []schema.SFTPUser{
+				{Username: "deploy", PublicKeyPath: getTestdataPath("id_rsa.pub")},
+			},
+		},
+	}
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	keyBytes, err := os.ReadFile(getTestdataPath("id_rsa"))
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	require.NoError(t, err)
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	succeeded := func() int {
+		n := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.InfoLevel && entry.Message == "🔐 Authentication succeeded" {
+				n++
+			}
+		}
+		return n
+	}
+
+	_, err = dialSFTP(9363, "deploy", ssh.PublicKeys(offeredKey{key: signer.PublicKey()}))
+	assert.Error(t, err)
+	assert.Zero(t, succeeded(), "an offered key is not logged as a login")
+
+	client, err := dialSFTP(9363, "deploy", ssh.PublicKeys(signer))
+	require.NoError(t, err)
+	client.Close()
+	assert.Eventually(t, func() bool { return succeeded() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSFTPPublicKeyPathRelativeToMock(t *testing.T) {
+	useTempSettings(t)
+
+	dir := t.TempDir()
+	pub, err := os.ReadFile(getTestdataPath("id_rsa.pub"))
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "keys"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keys", "deploy.pub"), pub, 0644))
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(&schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9355,
+		Files:    []schema.FileEntry{{Path: "/readme.txt", Content: "hello"}},
+		SFTPAuth: &schema.SFTPAuth{Users: []schema.SFTPUser{
+			{Username: "deploy", PublicKeyPath: "keys/deploy.pub"},
+		}},
+		BaseDir: dir,
+	}))
+	defer handler.Stop()
+
+	keyBytes, err := os.ReadFile(getTestdataPath("id_rsa"))
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	require.NoError(t, err)
+	client, err := dialSFTP(9355, "deploy", ssh.PublicKeys(signer))
+	require.NoError(t, err, "the key file is read next to the mock, not the working directory")
+	client.Close()
+}
+
+func TestSFTPIsolatedRoots(t *testing.T) {
+	useTempSettings(t)
+
+	root := t.TempDir()
+	outside := filepath.Dir(root)
+	auth := &schema.SFTPAuth{Username: "user", Password: "pass"}
+
+	disk := runtime.NewSFTPHandler()
+	require.NoError(t, disk.Start(&schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9302,
+		Files:    []schema.FileEntry{{Path: "/disk.txt", Content: "on disk"}},
+		SFTPAuth: auth,
+		SFTP:     &schema.SFTPConfig{Root: root},
+	}))
+	defer disk.Stop()
+
+	mem := runtime.NewSFTPHandler()
+	require.NoError(t, mem.Start(&schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9303,
+		Files:    []schema.FileEntry{{Path: "/data/mem.txt", Content: "in memory"}},
+		SFTPAuth: auth,
+		SFTP:     &schema.SFTPConfig{InMemory: true},
+	}))
+	defer mem.Stop()
+
+	diskClient, err := dialSFTP(9302, "user", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer diskClient.Close()
+	memClient, err := dialSFTP(9303, "user", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer memClient.Close()
+
+	t.Run("mocks do not share files", func(t *testing.T) {
+		_, err := diskClient.Stat("/data/mem.txt")
+		assert.Error(t, err)
+		_, err = memClient.Stat("/disk.txt")
+		assert.Error(t, err)
+	})
+
+	t.Run("disk root is chrooted", func(t *testing.T) {
+		f, err := diskClient.Create("../../escape.txt")
+		require.NoError(t, err)
+		_, err = f.Write([]byte("nope"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		_, err = os.Stat(filepath.Join(root, "escape.txt"))
+		assert.NoError(t, err, "file lands inside the root")
+		_, err = os.Stat(filepath.Join(outside, "escape.txt"))
+		assert.True(t, os.IsNotExist(err), "file must not escape the root")
+
+		data, err := os.ReadFile(filepath.Join(root, "disk.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "on disk", string(data))
+	})
+
+	t.Run("in-memory tree", func(t *testing.T) {
+		f, err := memClient.Open("/data/mem.txt")
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "in memory", string(data))
+
+		require.NoError(t, memClient.MkdirAll("/out/nested"))
+		w, err := memClient.Create("/out/nested/upload.txt")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("uploaded"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		require.NoError(t, memClient.PosixRename("/out/nested/upload.txt", "/out/done.txt"))
+		entries, err := memClient.ReadDir("/out")
+		require.NoError(t, err)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		assert.ElementsMatch(t, []string{"done.txt", "nested"}, names)
+
+		assert.Error(t, memClient.RemoveDirectory("/out"), "directory is not empty")
+		require.NoError(t, memClient.Remove("/out/done.txt"))
+		_, err = memClient.Stat("/out/done.txt")
+		assert.Error(t, err)
+	})
+}
+
+func TestSFTPUploadRecordingAndRules(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9304,
+		Files:    []schema.FileEntry{{Path: "/inbox/.keep", Content: ""}},
+		SFTPAuth: &schema.SFTPAuth{Username: "batch", Password: "pass"},
+		SFTP:     &schema.SFTPConfig{InMemory: true},
+		OnUpload: []schema.UploadRule{{
+			Path: "/inbox/*.csv",
+			Write: []schema.FileEntry{{
+				Path:    "/outbox/{{ .input.base }}.ack",
+				Content: "OK {{ .input.name }} {{ .input.size }} bytes from {{ .input.user }}",
+			}},
+			MoveTo: "/processed/",
+		}},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	client, err := dialSFTP(9304, "batch", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	upload := func(name, content string) {
+		f, err := client.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	upload("/inbox/orders.csv", "id,qty\n1,2\n")
+	upload("/inbox/notes.txt", "unmatched")
+	require.NoError(t, client.Mkdir("/tmp"))
+	require.NoError(t, client.Rename("/inbox/notes.txt", "/tmp/notes.txt"))
+
+	t.Run("ack file and move", func(t *testing.T) {
+		f, err := client.Open("/outbox/orders.ack")
+		require.NoError(t, err)
+		ack, err := io.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "OK orders.csv 11 bytes from batch", string(ack))
+
+		_, err = client.Stat("/inbox/orders.csv")
+		assert.Error(t, err, "upload is moved away")
+		data, err := handler.ReadFile("/processed/orders.csv")
+		require.NoError(t, err)
+		assert.Equal(t, "id,qty\n1,2\n", string(data))
+	})
+
+	t.Run("uploads", func(t *testing.T) {
+		uploads := handler.Uploads()
+		require.Len(t, uploads, 2)
+		assert.Equal(t, "/inbox/orders.csv", uploads[0].Path)
+		assert.Equal(t, "/processed/orders.csv", uploads[0].Location)
+		assert.Equal(t, int64(11), uploads[0].Size)
+		assert.Equal(t, "batch", uploads[0].User)
+		assert.Equal(t, "/inbox/notes.txt", uploads[1].Location)
+	})
+
+	t.Run("operations", func(t *testing.T) {
+		var ops []string
+		for _, op := range handler.Operations() {
+			ops = append(ops, op.Op+" "+op.Path+op.Target)
+		}
+		assert.Contains(t, ops, "open /inbox/orders.csv")
+		assert.Contains(t, ops, "write /inbox/orders.csv")
+		assert.Contains(t, ops, "mkdir /tmp")
+		assert.Contains(t, ops, "rename /inbox/notes.txt/tmp/notes.txt")
+	})
+}
+
+func TestSFTPPermissionsQuotaAndFaults(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9305,
+		Files: []schema.FileEntry{
+			{Path: "/reports/q1.csv", Content: "q1", Mode: "0440", Owner: "1001:100", ModTime: "2024-01-02T03:04:05Z"},
+			{Path: "/secret.txt", Content: "top", Mode: "0200"},
+			{Path: "/archive/2023.csv", Content: "old"},
+		},
+		SFTPAuth: &schema.SFTPAuth{Username: "user", Password: "pass"},
+		SFTP: &schema.SFTPConfig{
+			InMemory: true,
+			Dirs: []schema.DirEntry{
+				{Path: "/archive", ReadOnly: true},
+				{Path: "/upload", Mode: "0775", Owner: "1001"},
+			},
+			Quota: "1KB",
+			Faults: []schema.SFTPFault{
+				{Path: "/locked/*", Error: "permission"},
+				{Path: "*.bin", Op: "write", Error: "nospace", AfterBytes: 10},
+				{Path: "/upload/*", Op: "rename", Error: "failure"},
+			},
+		},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	client, err := dialSFTP(9305, "user", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	write := func(name string, data []byte) error {
+		f, err := client.Create(name)
+		if err != nil {
+			return err
+		}
+		_, werr := f.Write(data)
+		cerr := f.Close()
+		if werr != nil {
+			return werr
+		}
+		return cerr
+	}
+
+	t.Run("attributes", func(t *testing.T) {
+		info, err := client.Stat("/reports/q1.csv")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0440), info.Mode().Perm())
+		assert.True(t, info.ModTime().Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+		stat := info.Sys().(*sftp.FileStat)
+		assert.Equal(t, uint32(1001), stat.UID)
+		assert.Equal(t, uint32(100), stat.GID)
+
+		dir, err := client.Stat("/upload")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0775), dir.Mode().Perm())
+	})
+
+	t.Run("permission bits", func(t *testing.T) {
+		assert.True(t, os.IsPermission(write("/reports/q1.csv", []byte("x"))), "file without write bit")
+		_, err := client.Open("/secret.txt")
+		assert.True(t, os.IsPermission(err), "file without read bit")
+	})
+
+	t.Run("read-only directory", func(t *testing.T) {
+		assert.True(t, os.IsPermission(write("/archive/new.csv", []byte("x"))))
+		assert.True(t, os.IsPermission(client.Remove("/archive/2023.csv")))
+		assert.True(t, os.IsPermission(client.Mkdir("/archive/sub")))
+		assert.NoError(t, write("/upload/ok.csv", []byte("fine")))
+	})
+
+	t.Run("injected faults", func(t *testing.T) {
+		assert.True(t, os.IsPermission(write("/locked/a.txt", []byte("x"))))
+
+		err := write("/upload/data.bin", []byte("0123456789abcdef"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no space left")
+		data, err := handler.ReadFile("/upload/data.bin")
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(data), "partial transfer is kept")
+
+		err = client.Rename("/upload/ok.csv", "/upload/renamed.csv")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "simulated failure")
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		err := write("/upload/big.txt", make([]byte, 2048))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no space left")
+		info, err := client.Stat("/upload/big.txt")
+		require.NoError(t, err)
+		assert.Less(t, info.Size(), int64(1024))
+
+		err = client.Truncate("/upload/ok.csv", 4096)
+		require.Error(t, err, "growing a file counts against the quota")
+		assert.Contains(t, err.Error(), "no space left")
+		info, err = client.Stat("/upload/ok.csv")
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), info.Size())
+		assert.NoError(t, client.Truncate("/upload/ok.csv", 2))
+	})
+}
+
+func TestSFTPQuotaSharedByHandles(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9357,
+		Files:    []schema.FileEntry{{Path: "/seed.txt", Content: strings.Repeat("s", 200)}},
+		SFTPAuth: &schema.SFTPAuth{Username: "user", Password: "pass"},
+		SFTP:     &schema.SFTPConfig{InMemory: true, Quota: "1KB"},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	client, err := dialSFTP(9357, "user", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// both handles are open before either writes
+	a, err := client.Create("/a.bin")
+	require.NoError(t, err)
+	b, err := client.Create("/b.bin")
+	require.NoError(t, err)
+	_, errA := a.Write(make([]byte, 600))
+	_, errB := b.Write(make([]byte, 600))
+	a.Close()
+	b.Close()
+	assert.NoError(t, errA)
+	require.Error(t, errB, "the second upload finds the quota used by the first")
+	assert.Contains(t, errB.Error(), "no space left")
+
+	total := int64(0)
+	for _, name := range []string{"/seed.txt", "/a.bin", "/b.bin"} {
+		info, err := client.Stat(name)
+		require.NoError(t, err)
+		total += info.Size()
+	}
+	assert.Equal(t, int64(1024), total, "the part that fits is kept")
+
+	// removing and truncating give the space back
+	require.NoError(t, client.Remove("/a.bin"))
+	require.NoError(t, client.Truncate("/b.bin", 0))
+	f, err := client.Create("/c.bin")
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 800))
+	f.Close()
+	assert.NoError(t, err)
+}
+
+func TestSFTPHistoryIsBounded(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9360,
+		Files:    []schema.FileEntry{{Path: "/seed.txt", Content: "seed"}},
+		SFTPAuth: &schema.SFTPAuth{Username: "user", Password: "pass"},
+		SFTP:     &schema.SFTPConfig{InMemory: true},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	client, err := dialSFTP(9360, "user", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// opened for writing, nothing written
+	f, err := client.OpenFile("/seed.txt", os.O_WRONLY)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Empty(t, handler.Uploads(), "a handle that wrote nothing is not an upload")
+
+	for i := 0; i < 120; i++ {
+		f, err := client.Create(fmt.Sprintf("/f%d.txt", i))
+		require.NoError(t, err)
+		_, err = f.Write([]byte("x"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	uploads := handler.Uploads()
+	require.Len(t, uploads, 100)
+	assert.Equal(t, "/f20.txt", uploads[0].Path, "the oldest are dropped")
+	assert.Equal(t, "/f119.txt", uploads[99].Path)
+	assert.Len(t, handler.Operations(), 100)
+}
+
+func TestSFTPInMemoryOutOfRange(t *testing.T) {
+	useTempSettings(t)
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9356,
+		Files:    []schema.FileEntry{{Path: "/data.txt", Content: "data"}},
+		SFTPAuth: &schema.SFTPAuth{Username: "user", Password: "pass"},
+		SFTP:     &schema.SFTPConfig{InMemory: true},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	client, err := dialSFTP(9356, "user", ssh.Password("pass"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// SETSTAT with a size of 1<<63, a negative int64 on the server
+	assert.Error(t, client.Truncate("/data.txt", math.MinInt64))
+	assert.Error(t, client.Truncate("/data.txt", 1<<62), "past the in-memory limit")
+
+	f, err := client.OpenFile("/data.txt", os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("x"), 1<<62)
+	assert.Error(t, err)
+	_, err = f.WriteAt([]byte("x"), math.MaxInt64)
+	assert.Error(t, err)
+	f.Close()
+
+	// the server is still up and the file untouched
+	r, err := client.Open("/data.txt")
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestSFTPSeedFiles(t *testing.T) {
+	useTempSettings(t)
+
+	today := time.Now().Format("2006-01-02")
+	raw := false
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9306,
+		BaseDir:  getTestdataPath(""),
+		Context:  &schema.Context{Variables: map[string]any{"company": "ACME"}},
+		Files: []schema.FileEntry{
+			{Path: "/reports/report-{{ slice now 0 10 }}.csv", Content: "company,{{ .context.company }}"},
+			{Path: "/raw.txt", Content: "{{ not rendered }}", Template: &raw},
+			{Path: "/logo.bin", Content: "AAEC/w==", Encoding: "base64"},
+			{Path: "/keys/deploy.pub", Source: "id_rsa.pub"},
+			{Path: "/big/random.dat", Size: "64KB"},
+			{Path: "/big/pattern.txt", Content: "ab", Size: "5"},
+		},
+		SFTPAuth: &schema.SFTPAuth{Username: "user", Password: "pass"},
+		SFTP:     &schema.SFTPConfig{InMemory: true},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	read := func(name string) string {
+		t.Helper()
+		data, err := handler.ReadFile(name)
+		require.NoError(t, err, name)
+		return string(data)
+	}
+
+	assert.Equal(t, "company,ACME", read("/reports/report-"+today+".csv"))
+	assert.Equal(t, "{{ not rendered }}", read("/raw.txt"))
+	assert.Equal(t, "\x00\x01\x02\xff", read("/logo.bin"))
+
+	pub, err := os.ReadFile(getTestdataPath("id_rsa.pub"))
+	require.NoError(t, err)
+	assert.Equal(t, string(pub), read("/keys/deploy.pub"))
+
+	assert.Len(t, read("/big/random.dat"), 64*1024)
+	assert.Equal(t, "ababa", read("/big/pattern.txt"))
+
+	t.Run("missing source fails to start", func(t *testing.T) {
+		bad := *def
+		bad.Port = 9307
+		bad.Files = []schema.FileEntry{{Path: "/x", Source: "does-not-exist"}}
+		assert.Error(t, runtime.NewSFTPHandler().Start(&bad))
+	})
+}
+
+func TestSFTPSeedFileSizeBounds(t *testing.T) {
+	for size, msg := range map[string]string{
+		"9000000000G": "too large",
+		"1GB":         "more than the maximum of 256MB",
+	} {
+		def := &schema.MockDefinition{
+			Protocol: "sftp",
+			Port:     9359,
+			Files:    []schema.FileEntry{{Path: "/big.dat", Size: size}},
+			SFTPAuth: &schema.SFTPAuth{Username: "user", Password: "pass"},
+			SFTP:     &schema.SFTPConfig{InMemory: true},
+		}
+		err := schema.Validate(def)
+		require.Error(t, err, size)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
+
+func TestSFTPHostKeys(t *testing.T) {
+	useTempSettings(t)
+	auth := &schema.SFTPAuth{Username: "user", Password: "pass"}
+	files := []schema.FileEntry{{Path: "/a.txt", Content: "a"}}
+
+	// dialKey connects and returns the fingerprint the server presented
+	dialKey := func(port int) string {
+		var seen string
+		conn, err := ssh.Dial("tcp", fmt.Sprintf("localhost:%d", port), &ssh.ClientConfig{
+			User: "user",
+			Auth: []ssh.AuthMethod{ssh.Password("pass")},
+			HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+				seen = ssh.FingerprintSHA256(key)
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		conn.Close()
+		return seen
+	}
+
+	t.Run("generated defaults are persisted", func(t *testing.T) {
+		start := func() string {
+			handler := runtime.NewSFTPHandler()
+			require.NoError(t, handler.Start(&schema.MockDefinition{Protocol: "sftp", Port: 9308, Files: files, SFTPAuth: auth}))
+			defer handler.Stop()
+
+			keys := handler.HostKeys()
+			require.Len(t, keys, 2)
+			assert.Equal(t, "ssh-ed25519", keys[0].Type)
+			assert.Equal(t, "ssh-rsa", keys[1].Type)
+			assert.True(t, strings.HasPrefix(keys[0].KnownHost, "[localhost]:9308 ssh-ed25519 "))
+			assert.Contains(t, []string{keys[0].Fingerprint, keys[1].Fingerprint}, dialKey(9308))
+			return keys[0].Fingerprint + keys[1].Fingerprint
+		}
+		first := start()
+		_, err := os.Stat(filepath.Join("settings", "host_key_ed25519.pub"))
+		require.NoError(t, err)
+		assert.Equal(t, first, start(), "the key is reused")
+	})
+
+	t.Run("configured path and inline key", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "keys", "rsa_host")
+		handler := runtime.NewSFTPHandler()
+		require.NoError(t, handler.Start(&schema.MockDefinition{
+			Protocol: "sftp", Port: 9309, Files: files, SFTPAuth: auth,
+			SFTP: &schema.SFTPConfig{HostKeyPath: keyPath, HostKeyType: "rsa"},
+		}))
+		keys := handler.HostKeys()
+		require.Len(t, keys, 1)
+		assert.Equal(t, "ssh-rsa", keys[0].Type)
+		assert.Equal(t, keys[0].Fingerprint, dialKey(9309))
+		handler.Stop()
+
+		pem, err := os.ReadFile(getTestdataPath("id_rsa"))
+		require.NoError(t, err)
+		handler = runtime.NewSFTPHandler()
+		require.NoError(t, handler.Start(&schema.MockDefinition{
+			Protocol: "sftp", Port: 9310, Files: files, SFTPAuth: auth,
+			SFTP: &schema.SFTPConfig{HostKey: string(pem)},
+		}))
+		defer handler.Stop()
+
+		signer, err := ssh.ParsePrivateKey(pem)
+		require.NoError(t, err)
+		assert.Equal(t, ssh.FingerprintSHA256(signer.PublicKey()), dialKey(9310))
+	})
+}
+
+func TestSFTPUserHomes(t *testing.T) {
+	useTempSettings(t)
+
+	keyBytes, err := os.ReadFile(getTestdataPath("id_rsa"))
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	require.NoError(t, err)
+	pubKey := getTestdataPath("id_rsa.pub")
+
+	def := &schema.MockDefinition{
+		Protocol: "sftp",
+		Port:     9311,
+		Files:    []schema.FileEntry{{Path: "/motd.txt", Content: "shared"}},
+		SFTPAuth: &schema.SFTPAuth{
+			Username: "admin",
+			Password: "admin",
+			Users: []schema.SFTPUser{
+				{
+					Username: "acme",
+					Password: "acme-pass",
+					Auth:     "password",
+					Home:     "/partners/acme",
+					Files:    []schema.FileEntry{{Path: "inbox/welcome.txt", Content: "Hello {{ .context.hub }}"}},
+					Dirs:     []schema.DirEntry{{Path: "outbox", Mode: "0700"}},
+				},
+				{
+					Username:      "globex",
+					Password:      "globex-pass",
+					PublicKeyPath: pubKey,
+					Auth:          "publickey",
+					Home:          "/partners/globex",
+					ReadOnly:      true,
+					Files:         []schema.FileEntry{{Path: "reports/q1.csv", Content: "q1"}},
+				},
+				{Username: "ops", Password: "ops-pass", PublicKeyPath: pubKey, Auth: "both"},
+			},
+		},
+		Context: &schema.Context{Variables: map[string]any{"hub": "partner hub"}},
+		SFTP:    &schema.SFTPConfig{InMemory: true},
+	}
+	require.NoError(t, schema.Validate(def))
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	names := func(client *sftp.Client, dir string) []string {
+		t.Helper()
+		infos, err := client.ReadDir(dir)
+		require.NoError(t, err)
+		var out []string
+		for _, info := range infos {
+			out = append(out, info.Name())
+		}
+		return out
+	}
+
+	t.Run("home is the root", func(t *testing.T) {
+		client, err := dialSFTP(9311, "acme", ssh.Password("acme-pass"))
+		require.NoError(t, err)
+		defer client.Close()
+
+		assert.ElementsMatch(t, []string{"inbox", "outbox"}, names(client, "/"))
+		assert.ElementsMatch(t, []string{"inbox", "outbox"}, names(client, "/../.."))
+
+		f, err := client.Open("/inbox/welcome.txt")
+		require.NoError(t, err)
+		data, _ := io.ReadAll(f)
+		f.Close()
+		assert.Equal(t, "Hello partner hub", string(data))
+
+		info, err := client.Stat("/outbox")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+		_, err = client.Stat("/../globex/reports/q1.csv")
+		assert.Error(t, err)
+
+		f, err = client.Create("/outbox/ack.txt")
+		require.NoError(t, err)
+		f.Write([]byte("ok"))
+		f.Close()
+		data, err = handler.ReadFile("/partners/acme/outbox/ack.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(data))
+
+		uploads := handler.Uploads()
+		require.Len(t, uploads, 1)
+		assert.Equal(t, "acme", uploads[0].User)
+		assert.Equal(t, "/partners/acme/outbox/ack.txt", uploads[0].Path)
+	})
+
+	t.Run("auth methods", func(t *testing.T) {
+		_, err := dialSFTP(9311, "acme", ssh.PublicKeys(signer))
+		assert.Error(t, err, "acme only logs in with a password")
+		_, err = dialSFTP(9311, "globex", ssh.Password("globex-pass"))
+		assert.Error(t, err, "globex only logs in with a key")
+
+		_, err = dialSFTP(9311, "ops", ssh.Password("ops-pass"))
+		assert.Error(t, err, "ops needs a key and a password")
+		_, err = dialSFTP(9311, "ops", ssh.PublicKeys(signer))
+		assert.Error(t, err, "ops needs a key and a password")
+
+		client, err := dialSFTP(9311, "ops", ssh.PublicKeys(signer), ssh.Password("ops-pass"))
+		require.NoError(t, err)
+		defer client.Close()
+		assert.ElementsMatch(t, []string{"motd.txt", "partners"}, names(client, "/"))
+		assert.ElementsMatch(t, []string{"acme", "globex"}, names(client, "/partners"))
+	})
+
+	t.Run("read-only user", func(t *testing.T) {
+		client, err := dialSFTP(9311, "globex", ssh.PublicKeys(signer))
+		require.NoError(t, err)
+		defer client.Close()
+
+		assert.Equal(t, []string{"reports"}, names(client, "/"))
+		f, err := client.Open("/reports/q1.csv")
+		require.NoError(t, err)
+		f.Close()
+
+		_, err = client.Create("/reports/q2.csv")
+		assert.Error(t, err)
+		assert.Error(t, client.Remove("/reports/q1.csv"))
+		assert.Error(t, client.Mkdir("/new"))
+	})
+}
@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+func startSSHMock(t *testing.T, port int) {
+	t.Helper()
+	useTempSettings(t)
+
+	three := 3
+	def := &schema.MockDefinition{
+		Protocol: "ssh",
+		Port:     port,
+		SFTPAuth: &schema.SFTPAuth{Username: "admin", Password: "admin"},
+		Context:  &schema.Context{Variables: map[string]any{"hostname": "switch01"}},
+		SSH: &schema.SSHConfig{
+			Banner: "Welcome to {{ .context.hostname }}",
+			Prompt: "{{ .context.hostname }}({{ default .session.mode \"exec\" }})# ",
+			Commands: &schema.OnMessage{
+				Match: `^(?P<cmd>\S+)\s*(?P<arg>.*)$`,
+				Conditions: []schema.OnMessageRule{
+					{If: `{{ eq .input.cmd "uname" }}`, Respond: "Linux {{ .context.hostname }}"},
+					{If: `{{ eq .input.cmd "whoami" }}`, Respond: "{{ .session.user }}"},
+					{If: `{{ eq .input.cmd "configure" }}`, Set: map[string]string{"mode": "config"}},
+					{If: `{{ eq .input.cmd "reboot" }}`, Stderr: "reboot: permission denied", Exit: &three},
+				},
+			},
+		},
+	}
+
+	handler := runtime.NewSFTPHandler()
+	require.NoError(t, handler.Start(def))
+	t.Cleanup(func() { handler.Stop() })
+}
+
+func dialSSH(t *testing.T, port int) *ssh.Client {
+	t.Helper()
+	client, err := ssh.Dial("tcp", fmt.Sprintf("localhost:%d", port), &ssh.ClientConfig{
+		User:            "admin",
+		Auth:            []ssh.AuthMethod{ssh.Password("admin")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// runSSH executes a command and returns stdout, stderr and the exit status
+func runSSH(t *testing.T, client *ssh.Client, command string) (string, string, int) {
+	t.Helper()
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	err = session.Run(command)
+
+	status := 0
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		status = exitErr.ExitStatus()
+	} else {
+		require.NoError(t, err)
+	}
+	return stdout.String(), stderr.String(), status
+}
+
+func TestSSHExec(t *testing.T) {
+	startSSHMock(t, 9320)
+	client := dialSSH(t, 9320)
+
+	stdout, _, status := runSSH(t, client, "uname -a")
+	assert.Equal(t, "Linux switch01\n", stdout)
+	assert.Equal(t, 0, status)
+
+	stdout, _, _ = runSSH(t, client, "whoami")
+	assert.Equal(t, "admin\n", stdout)
+
+	stdout, stderr, status := runSSH(t, client, "reboot now")
+	assert.Empty(t, stdout)
+	assert.Equal(t, "reboot: permission denied\n", stderr)
+	assert.Equal(t, 3, status)
+
+	_, stderr, status = runSSH(t, client, "rm -rf /")
+	assert.Equal(t, "sh: rm: command not found\n", stderr)
+	assert.Equal(t, 127, status)
+}
+
+func TestSSHInteractiveShell(t *testing.T) {
+	startSSHMock(t, 9321)
+	client := dialSSH(t, 9321)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	require.NoError(t, session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := session.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+
+	out := &syncBuffer{}
+	go io.Copy(out, stdout)
+
+	waitFor := func(s string) {
+		t.Helper()
+		if !assert.Eventually(t, func() bool { return strings.Contains(out.String(), s) }, 2*time.Second, 10*time.Millisecond) {
+			t.Fatalf("waiting for %q in %q", s, out.String())
+		}
+	}
+
+	waitFor("Welcome to switch01\r\nswitch01(exec)# ")
+
+	// Typing goes through the echo and line editing of the PTY
+	stdin.Write([]byte("unamx\x7fe\r"))
+	waitFor("unamx\b \be\r\nLinux switch01\r\nswitch01(exec)# ")
+
+	stdin.Write([]byte("configure terminal\r"))
+	waitFor("switch01(config)# ")
+
+	stdin.Write([]byte("exit 2\r"))
+	err = session.Wait()
+	var exitErr *ssh.ExitError
+	require.True(t, errors.As(err, &exitErr), "got %v", err)
+	assert.Equal(t, 2, exitErr.ExitStatus())
+}
+
+// syncBuffer is a bytes.Buffer safe for a writer goroutine and a reader
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSSHOneProgramPerChannel(t *testing.T) {
+	startSSHMock(t, 9362)
+	client := dialSSH(t, 9362)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	out := &syncBuffer{}
+	session.Stdout = out
+	require.NoError(t, session.Shell())
+
+	// the shell already runs, later requests cannot start another program
+	// nor change the environment it reads
+	for _, req := range []struct {
+		name    string
+		payload []byte
+	}{
+		{"exec", ssh.Marshal(struct{ Command string }{"uname"})},
+		{"shell", nil},
+		{"subsystem", ssh.Marshal(struct{ Name string }{"sftp"})},
+	} {
+		ok, err := session.SendRequest(req.name, true, req.payload)
+		require.NoError(t, err)
+		assert.False(t, ok, req.name)
+	}
+	for i := 0; i < 20; i++ {
+		_, err := session.SendRequest("env", false, ssh.Marshal(struct{ Name, Value string }{"LANG", "C"}))
+		require.NoError(t, err)
+		stdin.Write([]byte("whoami\n"))
+	}
+
+	assert.Eventually(t, func() bool { return strings.Count(out.String(), "admin\n") == 20 }, 2*time.Second, 10*time.Millisecond, out.String())
+	stdin.Write([]byte("exit\n"))
+	session.Wait()
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/runtime"
+	"github.com/usekuro/usekuro/internal/schema"
+	"github.com/usekuro/usekuro/internal/store"
+)
+
+func TestHTTPStorePersistence(t *testing.T) {
+	dir := t.TempDir()
+	def := &schema.MockDefinition{
+		Protocol: "http",
+		Port:     8102,
+		BaseDir:  dir,
+		Context:  &schema.Context{Variables: map[string]any{}},
+		Store: &schema.StoreConfig{
+			Initial: map[string]any{"next_id": 100},
+			File:    "state.json",
+		},
+		Routes: []schema.Route{
+			{
+				Path:   "/users",
+				Method: "POST",
+				Response: schema.ResponseDefinition{
+					Status: 201,
+					Body:   `{{ $id := store.Incr "next_id" }}{{ $u := set .input "id" $id }}{{ store.Append "users" $u }}{{ toJSON $u }}`,
+				},
+			},
+			{
+				Path:   "/users",
+				Method: "GET",
+				Response: schema.ResponseDefinition{
+					Status: 200,
+					Body:   `{{ store.Get "users" (list) | toJSON }}`,
+				},
+			},
+		},
+	}
+	require.NoError(t, schema.Validate(def))
+
+	do := func(method, body string) string {
+		t.Helper()
+		req, err := http.NewRequest(method, "http://localhost:8102/users", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		out, _ := io.ReadAll(resp.Body)
+		return strings.TrimSpace(string(out))
+	}
+
+	handler := runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	assert.Equal(t, `{"id":101,"name":"Ana"}`, do("POST", `{"name":"Ana"}`))
+	assert.Equal(t, `{"id":102,"name":"Bob"}`, do("POST", `{"name":"Bob"}`))
+	assert.Equal(t, `[{"id":101,"name":"Ana"},{"id":102,"name":"Bob"}]`, do("GET", ""))
+	require.NoError(t, handler.Stop())
+	assert.FileExists(t, filepath.Join(dir, "state.json"))
+	time.Sleep(50 * time.Millisecond)
+
+	// The next run starts from the saved state
+	handler = runtime.NewHTTPHandler()
+	require.NoError(t, handler.Start(def))
+	defer handler.Stop()
+	assert.Equal(t, `{"id":103,"name":"Eve"}`, do("POST", `{"name":"Eve"}`))
+	assert.Len(t, handler.TemplateEnv().Store.Get("users"), 3)
+}
+
+func TestGlobalStoreAcrossProtocols(t *testing.T) {
+	defer store.Global.Delete("maintenance")
+
+	httpDef := &schema.MockDefinition{
+		Protocol: "http",
+		Port:     8103,
+		Context:  &schema.Context{Variables: map[string]any{}},
+		Routes: []schema.Route{{
+			Path:     "/maintenance",
+			Method:   "POST",
+			Response: schema.ResponseDefinition{Status: 204, Body: `{{ globalStore.Set "maintenance" true }}`},
+		}},
+	}
+	wsDef := &schema.MockDefinition{
+		Protocol: "ws",
+		Port:     9340,
+		Context:  &schema.Context{Variables: map[string]any{}},
+		OnMessage: &schema.OnMessage{
+			Conditions: []schema.OnMessageRule{{
+				If:      `{{ globalStore.Get "maintenance" false }}`,
+				Respond: "down for maintenance",
+			}},
+			Else: "ok",
+		},
+	}
+
+	httpHandler := runtime.NewHTTPHandler()
+	require.NoError(t, httpHandler.Start(httpDef))
+	defer httpHandler.Stop()
+	wsHandler := runtime.NewWSHandler()
+	require.NoError(t, wsHandler.Start(wsDef))
+	defer wsHandler.Stop()
+	time.Sleep(200 * time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9340/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	send := func() string {
+		t.Helper()
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("status")))
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		return string(msg)
+	}
+
+	assert.Equal(t, "ok", send())
+	resp, err := http.Post("http://localhost:8103/maintenance", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "down for maintenance", send())
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/usekuro/usekuro/internal/runtime"
 	"testing"
@@ -52,3 +53,335 @@ func TestWSWithExternalFunction(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "pong", string(msg))
 }
+
+func TestWSStopClosesConnectionsAndFreesPort(t *testing.T) {
+	newDef := func(port int, reply string) *schema.MockDefinition {
+		return &schema.MockDefinition{
+			Protocol: "ws",
+			Port:     port,
+			OnMessage: &schema.OnMessage{
+				Else: reply,
+			},
+		}
+	}
+
+	first := runtime.NewWSHandler()
+	second := runtime.NewWSHandler()
+	assert.NoError(t, first.Start(newDef(9202, "first")))
+	assert.NoError(t, second.Start(newDef(9203, "second")))
+	defer second.Stop()
+
+	conflict := runtime.NewWSHandler()
+	assert.Error(t, conflict.Start(newDef(9202, "conflict")), "port already taken")
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9202/", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hi")))
+	_, msg, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(msg))
+
+	assert.NoError(t, first.Stop())
+
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "expected close frame, got %v", err)
+
+	// the port is free again
+	restarted := runtime.NewWSHandler()
+	assert.NoError(t, restarted.Start(newDef(9202, "restarted")))
+	assert.NoError(t, restarted.Stop())
+}
+
+func TestWSEndpointsSubprotocolsAndHandshake(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "ws",
+		Port:     9204,
+		Endpoints: []schema.WSEndpoint{
+			{
+				Path:         "/ws/prices",
+				Subprotocols: []string{"prices.v2", "prices.v1"},
+				OnMessage: &schema.OnMessage{
+					Else: `prices:{{ .session.subprotocol }}`,
+				},
+			},
+			{
+				Path: "/ws/orders",
+				Reject: []schema.HandshakeRule{
+					{
+						If:     `{{ if not .input.query.token }}true{{ end }}`,
+						Status: 401,
+						Body:   "missing token",
+					},
+				},
+				OnMessage: &schema.OnMessage{
+					Else: `orders:{{ .session.query.token }}`,
+				},
+			},
+		},
+	}
+
+	handler := runtime.NewWSHandler()
+	assert.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	roundTrip := func(conn *websocket.Conn) string {
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hi")))
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		return string(msg)
+	}
+
+	t.Run("subprotocol negotiation", func(t *testing.T) {
+		dialer := websocket.Dialer{Subprotocols: []string{"prices.v1"}}
+		conn, _, err := dialer.Dial("ws://localhost:9204/ws/prices", nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, "prices.v1", conn.Subprotocol())
+		assert.Equal(t, "prices:prices.v1", roundTrip(conn))
+	})
+
+	t.Run("handshake rejected without token", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial("ws://localhost:9204/ws/orders", nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, 401, resp.StatusCode)
+		}
+	})
+
+	t.Run("handshake accepted with token", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9204/ws/orders?token=abc", nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, "orders:abc", roundTrip(conn))
+	})
+
+	t.Run("unknown path", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial("ws://localhost:9204/ws/unknown", nil)
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, 404, resp.StatusCode)
+		}
+	})
+}
+
+func TestWSJSONMatching(t *testing.T) {
+	yes := true
+	def := &schema.MockDefinition{
+		Protocol: "ws",
+		Port:     9205,
+		OnMessage: &schema.OnMessage{
+			Mode: "json",
+			Conditions: []schema.OnMessageRule{
+				{
+					When: []schema.JSONCondition{
+						{Path: "$.type", Equals: "subscribe"},
+						{Path: "$.channel", Type: "string", Regex: "^(btc|eth)$"},
+					},
+					Respond: `{"type":"subscribed","channel":"{{ .input.channel }}"}`,
+				},
+				{
+					When: []schema.JSONCondition{
+						{Path: "$.type", Equals: "order"},
+						{Path: "$.qty", Type: "number"},
+						{Path: "$.meta.dryRun", Exists: &yes},
+					},
+					If:      `{{ if .input.meta.dryRun }}true{{ end }}`,
+					Respond: `{"type":"dry-run","qty":{{ .input.qty }}}`,
+				},
+				{
+					When:    []schema.JSONCondition{{Path: "$.items[*].id", Type: "array"}},
+					Respond: `{"count":{{ len .input.items }}}`,
+				},
+			},
+			Else: `{"type":"error"}`,
+		},
+	}
+
+	handler := runtime.NewWSHandler()
+	assert.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9205/", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	cases := map[string]string{
+		`{"type":"subscribe","channel":"btc"}`:              `{"type":"subscribed","channel":"btc"}`,
+		`{"type":"subscribe","channel":"doge"}`:             `{"type":"error"}`,
+		`{"type":"subscribe","channel":42}`:                 `{"type":"error"}`,
+		`{"type":"order","qty":3,"meta":{"dryRun":true}}`:   `{"type":"dry-run","qty":3}`,
+		`{"type":"order","qty":3,"meta":{"dryRun":false}}`:  `{"type":"error"}`,
+		`{"type":"order","qty":"3","meta":{"dryRun":true}}`: `{"type":"error"}`,
+		`{"items":[{"id":1},{"id":2}]}`:                     `{"count":2}`,
+		`not json`:                                          `{"type":"error"}`,
+	}
+	for in, want := range cases {
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(in)))
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(msg), in)
+	}
+}
+
+func TestWSBinaryFramesAndCloseCodes(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "ws",
+		Port:     9206,
+		OnMessage: &schema.OnMessage{
+			Binary: "hex",
+			Conditions: []schema.OnMessageRule{
+				{
+					If:       `{{ if eq .input.msg "cafe" }}true{{ end }}`,
+					Respond:  "de ad be ef",
+					Encoding: "hex",
+				},
+				{
+					If:       `{{ if eq .input.msg "logo" }}true{{ end }}`,
+					Respond:  "aGVsbG8=",
+					Encoding: "base64",
+				},
+				{
+					If:      `{{ if eq .input.msg "kick" }}true{{ end }}`,
+					Respond: "bye",
+					Close:   &schema.CloseAction{Code: 4001, Reason: "kicked {{ .input.msg }}"},
+				},
+				{
+					If:    `{{ if eq .input.msg "crash" }}true{{ end }}`,
+					Close: &schema.CloseAction{Abrupt: true},
+				},
+			},
+		},
+	}
+
+	handler := runtime.NewWSHandler()
+	assert.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9206/", nil)
+		assert.NoError(t, err)
+		return conn
+	}
+
+	t.Run("binary in, binary out", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{0xca, 0xfe}))
+		mt, msg, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, mt)
+		assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, msg)
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("logo")))
+		mt, msg, err = conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, mt)
+		assert.Equal(t, "hello", string(msg))
+	})
+
+	t.Run("close with custom code", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("kick")))
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "bye", string(msg))
+
+		_, _, err = conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if assert.ErrorAs(t, err, &closeErr) {
+			assert.Equal(t, 4001, closeErr.Code)
+			assert.Equal(t, "kicked kick", closeErr.Text)
+		}
+	})
+
+	t.Run("abrupt disconnect", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("crash")))
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsUnexpectedCloseError(err), "expected abnormal closure, got %v", err)
+	})
+}
+
+func TestWSHeartbeat(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol: "ws",
+		Port:     9207,
+		Heartbeat: &schema.Heartbeat{
+			Interval: "50ms",
+			Timeout:  "100ms",
+		},
+		OnMessage: &schema.OnMessage{Else: "ok"},
+	}
+
+	handler := runtime.NewWSHandler()
+	assert.NoError(t, handler.Start(def))
+	defer handler.Stop()
+
+	t.Run("server pings the client", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9207/", nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		pinged := make(chan struct{}, 1)
+		conn.SetPingHandler(func(data string) error {
+			select {
+			case pinged <- struct{}{}:
+			default:
+			}
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		go conn.ReadMessage()
+
+		select {
+		case <-pinged:
+		case <-time.After(time.Second):
+			t.Fatal("no ping received")
+		}
+	})
+
+	t.Run("silent client is disconnected", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9207/", nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		// never read, so pings are never answered
+		time.Sleep(400 * time.Millisecond)
+		conn.SetPingHandler(func(string) error { return nil })
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err = conn.ReadMessage()
+		assert.Error(t, err)
+		assert.False(t, websocket.IsCloseError(err), "expected dropped connection, got %v", err)
+		var netErr interface{ Timeout() bool }
+		if errors.As(err, &netErr) {
+			assert.False(t, netErr.Timeout(), "server should have closed the connection")
+		}
+	})
+}
+
+func TestWSEndpointsRejectTopLevelOnMessage(t *testing.T) {
+	def := &schema.MockDefinition{
+		Protocol:  "ws",
+		Port:      9353,
+		OnMessage: &schema.OnMessage{Else: "root"},
+		Endpoints: []schema.WSEndpoint{
+			{Path: "/ws/prices", OnMessage: &schema.OnMessage{Else: "prices"}},
+		},
+	}
+	err := schema.Validate(def)
+	if assert.Error(t, err, "the top-level rules would never be served") {
+		assert.Contains(t, err.Error(), "'onMessage' cannot be combined with 'endpoints'")
+	}
+
+	def.OnMessage = nil
+	def.Endpoints = append(def.Endpoints, schema.WSEndpoint{Path: "/", OnMessage: &schema.OnMessage{Else: "root"}})
+	assert.NoError(t, schema.Validate(def))
+}
@@ -1,18 +1,27 @@
 package runtime
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+	"github.com/usekuro/usekuro/internal/extensions"
 	"github.com/usekuro/usekuro/internal/schema"
 	"github.com/usekuro/usekuro/internal/template"
 )
 
 type WSHandler struct {
 	upgrader websocket.Upgrader
+	server   *http.Server
+	hub      *hub
+	env      *template.Env
 	logger   *logrus.Entry
+
+	unsubscribe func()
 }
 
 func NewWSHandler() *WSHandler {
@@ -20,7 +29,7 @@ func NewWSHandler() *WSHandler {
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
-
+		hub:    newHub(),
 		logger: logrus.WithField("protocol", "ws"),
 	}
 }
@@ -29,72 +38,305 @@ func (h *WSHandler) Start(def *schema.MockDefinition) error {
 	h.logger.Logger.SetLevel(logrus.DebugLevel)
 	h.logger.Infof("starting WebSocket mock on port %d", def.Port)
 
-	registry := loadExtensions(def.Import, h.logger)
+	registry := loadExtensions(def, h.logger)
+
+	hb, err := parseHeartbeat(def.Heartbeat)
+	if err != nil {
+		return err
+	}
+	if h.env, err = newTemplateEnv(def); err != nil {
+		return err
+	}
+	compileTemplates(def, registry, h.env, h.logger)
+
+	// Each mock gets its own mux so several WS mocks can share a process
+	mux := http.NewServeMux()
+	if len(def.Endpoints) == 0 {
+		ep := schema.WSEndpoint{Path: "/", OnMessage: def.OnMessage}
+		h.logger.WithField("path", ep.Path).Info("registering WebSocket endpoint")
+		mux.HandleFunc(ep.Path, h.endpointHandler(def, ep, "", hb, registry))
+	}
+	for i, ep := range def.Endpoints {
+		h.logger.WithField("path", ep.Path).Info("registering WebSocket endpoint")
+		mux.HandleFunc(ep.Path, h.endpointHandler(def, ep, fmt.Sprintf("endpoints[%d]", i), hb, registry))
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", def.Port))
+	if err != nil {
+		h.logger.WithError(err).Error("failed to start WebSocket listener")
+		return fmt.Errorf("failed to start WebSocket server: %w", err)
+	}
+
+	h.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := h.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			h.logger.WithError(err).Error("WebSocket server failed")
+		}
+	}()
+
+	h.unsubscribe = subscribe(def, registry, h.env, h.logger, h.hub.reactBroadcast(h.logger))
+	h.logger.Info("WebSocket server started successfully")
+	return nil
+}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := h.upgrader.Upgrade(w, r, nil)
+// endpointHandler upgrades requests for a single endpoint after running its
+// handshake checks and then evaluates the endpoint's onMessage rules. field
+// is the endpoint's place in the mock, empty for the top-level onMessage.
+func (h *WSHandler) endpointHandler(def *schema.MockDefinition, ep schema.WSEndpoint, field string, hb heartbeat, registry *extensions.Registry) http.HandlerFunc {
+	rules := "onMessage"
+	if field != "" {
+		rules = field + ".onMessage"
+	}
+
+	upgrader := h.upgrader
+	upgrader.Subprotocols = ep.Subprotocols
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		handshake := handshakeVars(r)
+
+		if status, body, rejected := h.checkHandshake(def, ep, field, handshake, registry); rejected {
+			h.logger.WithFields(logrus.Fields{
+				"path":   r.URL.Path,
+				"status": status,
+			}).Info("rejected WebSocket handshake")
+			http.Error(w, body, status)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			h.logger.WithError(err).Error("failed to upgrade WebSocket connection")
 			return
 		}
 		defer conn.Close()
-		h.logger.Info("client connected")
+		h.logger.WithFields(logrus.Fields{
+			"path":        r.URL.Path,
+			"subprotocol": conn.Subprotocol(),
+		}).Info("client connected")
+
+		p := h.hub.add(func(data []byte, binary bool) error {
+			if binary {
+				return conn.WriteMessage(websocket.BinaryMessage, data)
+			}
+			return conn.WriteMessage(websocket.TextMessage, data)
+		}, func() error {
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return conn.Close()
+		})
+		defer h.hub.remove(p)
+
+		// Handshake data stays available to message templates via .session
+		for k, v := range handshake {
+			p.set(k, v)
+		}
+		p.set("subprotocol", conn.Subprotocol())
+
+		done := make(chan struct{})
+		defer close(done)
+		h.startHeartbeat(conn, hb, done)
 
 		for {
-			_, msg, err := conn.ReadMessage()
+			mt, msg, err := conn.ReadMessage()
 			if err != nil {
 				h.logger.WithError(err).Info("client disconnected")
 				break
 			}
 			raw := string(msg)
+			if mt == websocket.BinaryMessage {
+				raw = binaryInput(msg, ep.OnMessage.Binary)
+			}
 			h.logger.WithField("input", raw).Info("received message")
 
-			matches := extractVars(raw, def.OnMessage.Match)
-			ctx := template.MergeContext(matches, nil, def.Context.Variables)
-
-			tpl, err := template.NewRuntime(ctx, registry)
-			if err != nil {
-				h.logger.WithError(err).Error("template runtime error")
-				conn.WriteMessage(websocket.TextMessage, []byte("template error"))
+			r := h.hub.handleMessage(p, raw, ep.OnMessage, rules, def, registry, h.env, h.logger)
+			if r == nil {
 				continue
 			}
-
-			sent := false
-			for i, cond := range def.OnMessage.Conditions {
-				result, _ := tpl.Render(fmt.Sprintf("cond_%d", i), cond.If)
-				h.logger.WithFields(logrus.Fields{
-					"condition": i,
-					"if":        cond.If,
-					"result":    result,
-				}).Debug("evaluated condition")
-
-				if result == "true" {
-					resp, _ := tpl.Render(fmt.Sprintf("resp_%d", i), cond.Respond)
-					h.logger.WithField("response", resp).Info("sending matched response")
-					conn.WriteMessage(websocket.TextMessage, []byte(resp))
-					sent = true
-					break
-				}
+			if r.data != nil {
+				p.send(r.data, r.binary)
 			}
-
-			if !sent && def.OnMessage.Else != "" {
-				resp, _ := tpl.Render("else", def.OnMessage.Else)
-				h.logger.WithField("response", resp).Info("sending fallback response")
-				conn.WriteMessage(websocket.TextMessage, []byte(resp))
+			if r.close != nil {
+				h.closeConn(conn, r.close)
+				return
 			}
 		}
-	})
+	}
+}
+
+// closeConn ends a connection as requested by a condition: abruptly, by
+// dropping the TCP connection, or with a close frame carrying code and reason
+func (h *WSHandler) closeConn(conn *websocket.Conn, c *closeRequest) {
+	if c.abrupt {
+		h.logger.Info("dropping WebSocket connection")
+		conn.UnderlyingConn().Close()
+		return
+	}
+	code := c.code
+	if code == 0 {
+		code = websocket.CloseNormalClosure
+	}
+	h.logger.WithFields(logrus.Fields{
+		"code":   code,
+		"reason": c.reason,
+	}).Info("closing WebSocket connection")
+	msg := websocket.FormatCloseMessage(code, c.reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
+// heartbeat is a parsed schema.Heartbeat
+type heartbeat struct {
+	interval    time.Duration
+	timeout     time.Duration
+	ignorePings bool
+}
+
+func parseHeartbeat(hb *schema.Heartbeat) (heartbeat, error) {
+	var out heartbeat
+	if hb == nil {
+		return out, nil
+	}
+	out.ignorePings = hb.IgnorePings
+
+	var err error
+	if hb.Interval != "" {
+		if out.interval, err = time.ParseDuration(hb.Interval); err != nil {
+			return out, fmt.Errorf("invalid heartbeat interval: %w", err)
+		}
+	}
+	if hb.Timeout != "" {
+		if out.timeout, err = time.ParseDuration(hb.Timeout); err != nil {
+			return out, fmt.Errorf("invalid heartbeat timeout: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// startHeartbeat installs the ping/pong handlers of a connection and, when an
+// interval is configured, pings the client until done is closed. With a
+// timeout, a client that stops answering pings is disconnected.
+func (h *WSHandler) startHeartbeat(conn *websocket.Conn, hb heartbeat, done chan struct{}) {
+	if hb.ignorePings {
+		conn.SetPingHandler(func(string) error { return nil })
+	}
+
+	if hb.interval <= 0 {
+		return
+	}
+
+	if hb.timeout > 0 {
+		deadline := hb.interval + hb.timeout
+		conn.SetReadDeadline(time.Now().Add(deadline))
+		conn.SetPongHandler(func(string) error {
+			h.logger.Debug("received pong")
+			return conn.SetReadDeadline(time.Now().Add(deadline))
+		})
+	}
 
 	go func() {
-		err := http.ListenAndServe(fmt.Sprintf(":%d", def.Port), nil)
-		if err != nil {
-			h.logger.WithError(err).Fatal("failed to start WebSocket server")
+		ticker := time.NewTicker(hb.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+					return
+				}
+			}
 		}
 	}()
-	return nil
+}
+
+// checkHandshake evaluates the endpoint's reject rules against the upgrade
+// request and returns the status and body of the first one that matches
+func (h *WSHandler) checkHandshake(def *schema.MockDefinition, ep schema.WSEndpoint, field string, handshake map[string]any, registry *extensions.Registry) (int, string, bool) {
+	if len(ep.Reject) == 0 {
+		return 0, "", false
+	}
+
+	ctx := template.MergeContext(handshake, nil, contextVariables(def))
+	tpl, err := template.NewRuntimeEnv(ctx, registry, h.env)
+	if err != nil {
+		h.logger.WithError(err).Error("template runtime error")
+		return http.StatusInternalServerError, "template error", true
+	}
+
+	for i, rule := range ep.Reject {
+		result, err := tpl.Render(fmt.Sprintf("%s.reject[%d].if", field, i), rule.If)
+		if err != nil {
+			logTemplateError(err, h.logger)
+			continue
+		}
+		if result != "true" {
+			continue
+		}
+		status := rule.Status
+		if status == 0 {
+			status = http.StatusForbidden
+		}
+		body, err := tpl.Render(fmt.Sprintf("%s.reject[%d].body", field, i), rule.Body)
+		if err != nil {
+			logTemplateError(err, h.logger)
+		}
+		if body == "" {
+			body = http.StatusText(status)
+		}
+		return status, body, true
+	}
+	return 0, "", false
+}
+
+// handshakeVars exposes the upgrade request as .input.path, .input.query and
+// .input.headers (first value of each key)
+func handshakeVars(r *http.Request) map[string]any {
+	query := map[string]any{}
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			query[k] = v[0]
+		}
+	}
+	headers := map[string]any{}
+	for k, v := range r.Header {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	return map[string]any{
+		"path":    r.URL.Path,
+		"query":   query,
+		"headers": headers,
+	}
+}
+
+// TemplateEnv returns the clock and random source of the mock's templates,
+// nil before Start
+func (h *WSHandler) TemplateEnv() *template.Env {
+	return h.env
 }
 
 func (h *WSHandler) Stop() error {
-	// No-op for now
+	if h.server == nil {
+		return nil
+	}
+	h.logger.Info("stopping WebSocket mock")
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+	}
+
+	// Upgraded connections are hijacked and not tracked by the http.Server,
+	// so close them explicitly with a close frame first
+	h.hub.closeAll()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.server.Shutdown(ctx); err != nil {
+		h.logger.WithError(err).Warn("graceful shutdown failed, forcing close")
+		return h.server.Close()
+	}
+
+	h.logger.Info("WebSocket server stopped gracefully")
 	return nil
 }
@@ -1,5 +1,7 @@
 package schema
 
+import "github.com/usekuro/usekuro/internal/diagnostics"
+
 type Meta struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -10,6 +12,7 @@ type Route struct {
 	Path     string             `json:"path"`
 	Method   string             `json:"method"`
 	Response ResponseDefinition `json:"response"`
+	Publish  *PublishAction     `json:"publish"` // optional event sent after responding
 }
 
 type ResponseDefinition struct {
@@ -20,26 +23,182 @@ type ResponseDefinition struct {
 
 // TCP / WS conditional logic
 type OnMessageRule struct {
-	If      string `json:"if"`
-	Respond string `json:"respond"`
+	If        string            `json:"if"`
+	When      []JSONCondition   `json:"when"` // optional, json mode only
+	Respond   string            `json:"respond"`
+	Encoding  string            `json:"encoding"`  // optional: text (default), base64 or hex
+	Close     *CloseAction      `json:"close"`     // optional, ends the connection after responding
+	Set       map[string]string `json:"set"`       // optional session attributes
+	Join      string            `json:"join"`      // optional room to join
+	Leave     string            `json:"leave"`     // optional room to leave
+	Broadcast *Broadcast        `json:"broadcast"` // optional fan-out
+	Publish   *PublishAction    `json:"publish"`   // optional event for other mocks
+	Stderr    string            `json:"stderr"`    // ssh, optional error output
+	Exit      *int              `json:"exit"`      // ssh, optional exit status, 0 by default
+}
+
+// Broadcast sends a rendered message to other connections of the same mock
+type Broadcast struct {
+	Message     string            `json:"message"`
+	Room        string            `json:"room"`        // optional, every connection when empty
+	To          map[string]string `json:"to"`          // optional session attribute filter
+	ExcludeSelf bool              `json:"excludeSelf"` // optional
+}
+
+// PublishAction sends an event to the other mocks of the process
+type PublishAction struct {
+	Event string `json:"event"` // event name, may be a template
+	Data  string `json:"data"`  // optional template, sent as JSON when it renders valid JSON
+}
+
+// Subscription reacts to the events mocks publish. Its templates see the
+// event data as .input and the event name and source as .event.
+type Subscription struct {
+	Event     string         `json:"event"`     // event name or a pattern such as orders.*
+	If        string         `json:"if"`        // optional template, the subscription only reacts when it renders "true"
+	Do        string         `json:"do"`        // optional template rendered for its side effects, e.g. store updates
+	Broadcast *Broadcast     `json:"broadcast"` // tcp/ws, message for the connected clients
+	Write     []FileEntry    `json:"write"`     // sftp/ftp, files to create, path and content are templates
+	Publish   *PublishAction `json:"publish"`   // optional follow-up event
+}
+
+// CloseAction ends a TCP/WS connection from a condition
+type CloseAction struct {
+	Code   int    `json:"code"`   // optional WebSocket close code, defaults to 1000
+	Reason string `json:"reason"` // optional
+	Abrupt bool   `json:"abrupt"` // drop the connection without a close frame
+}
+
+// Heartbeat configures WebSocket ping/pong handling
+type Heartbeat struct {
+	Interval    string `json:"interval"`    // server ping interval, e.g. 30s
+	Timeout     string `json:"timeout"`     // optional, disconnect when no pong arrives in time
+	IgnorePings bool   `json:"ignorePings"` // optional, never answer client pings
+}
+
+// JSONCondition matches the value at a JSONPath of a JSON message
+type JSONCondition struct {
+	Path   string `json:"path"`   // e.g. $.type or $.items[0].id
+	Equals any    `json:"equals"` // optional
+	Type   string `json:"type"`   // optional: string, number, boolean, object, array, null
+	Regex  string `json:"regex"`  // optional, applied to string values
+	Exists *bool  `json:"exists"` // optional
 }
 
 type OnMessage struct {
+	Mode       string          `json:"mode"`   // regex (default) or json
+	Binary     string          `json:"binary"` // optional: how binary data reaches rules: raw (default), base64 or hex
 	Match      string          `json:"match"`
 	Conditions []OnMessageRule `json:"conditions"`
 	Else       string          `json:"else"`
 }
 
+// WebSocket endpoint served by a ws mock
+type WSEndpoint struct {
+	Path         string          `json:"path"`
+	Subprotocols []string        `json:"subprotocols"` // optional Sec-WebSocket-Protocol values
+	Reject       []HandshakeRule `json:"reject"`       // optional upgrade-time checks
+	OnMessage    *OnMessage      `json:"onMessage"`
+}
+
+// HandshakeRule refuses the upgrade with Status when If renders "true"
+type HandshakeRule struct {
+	If     string `json:"if"`
+	Status int    `json:"status"` // optional, defaults to 403
+	Body   string `json:"body"`   // optional
+}
+
 // SFTP file system
 type FileEntry struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
+	Path     string `json:"path"` // may be a template
+	Content  string `json:"content"`
+	Template *bool  `json:"template"` // optional, inline text is rendered with the mock's context unless false
+	Encoding string `json:"encoding"` // optional text (default) or base64
+	Source   string `json:"source"`   // optional file to copy, relative to the mock file
+	Size     string `json:"size"`     // optional generated size, e.g. "10MB", repeating content or random bytes
+	Mode     string `json:"mode"`     // optional octal permissions, e.g. "0600"
+	Owner    string `json:"owner"`    // optional "uid" or "uid:gid"
+	ModTime  string `json:"modTime"`  // optional RFC3339 modification time
+}
+
+// DirEntry describes a directory of an SFTP mock
+type DirEntry struct {
+	Path     string `json:"path"`
+	Mode     string `json:"mode"`     // optional octal permissions, e.g. "0755"
+	Owner    string `json:"owner"`    // optional "uid" or "uid:gid"
+	ModTime  string `json:"modTime"`  // optional RFC3339 modification time
+	ReadOnly bool   `json:"readOnly"` // optional, refuse any change below this directory
+}
+
+// SFTPFault injects an error into matching SFTP operations
+type SFTPFault struct {
+	Path       string `json:"path"`       // glob, matched against the name only when it has no "/"
+	Op         string `json:"op"`         // optional open, read, write, rename, remove, mkdir, rmdir, setstat or list
+	Error      string `json:"error"`      // permission, nospace, notfound or failure
+	AfterBytes int64  `json:"afterBytes"` // optional, writes fail once the file reaches this size
 }
 
 type SFTPAuth struct {
-	Username      string `json:"username"`
-	Password      string `json:"password"`
-	PublicKeyPath string `json:"publicKeyPath"` // optional
+	Username      string     `json:"username"`
+	Password      string     `json:"password"`
+	PublicKeyPath string     `json:"publicKeyPath"` // optional authorized_keys file, relative to the mock file
+	Users         []SFTPUser `json:"users"`         // optional additional accounts
+	MaxAttempts   int        `json:"maxAttempts"`   // optional, lock a user out after N failed attempts
+	Lockout       string     `json:"lockout"`       // optional lockout duration, until restart when empty
+}
+
+// SFTPUser is an additional SFTP account. With a home directory the user
+// only sees that part of the tree, as its root.
+type SFTPUser struct {
+	Username      string      `json:"username"`
+	Password      string      `json:"password"`      // optional when keys are configured
+	PublicKeyPath string      `json:"publicKeyPath"` // optional authorized_keys file, relative to the mock file
+	PublicKeys    []string    `json:"publicKeys"`    // optional inline authorized keys
+	Auth          string      `json:"auth"`          // optional: any (default), password, publickey or both (key then password)
+	Home          string      `json:"home"`          // optional directory the user is confined to, created when missing
+	Files         []FileEntry `json:"files"`         // optional seed files, relative to home
+	Dirs          []DirEntry  `json:"dirs"`          // optional directories with their attributes, relative to home
+	ReadOnly      bool        `json:"readOnly"`      // optional, the user cannot change anything
+}
+
+// SSHConfig scripts the exec requests and interactive shells of an SSH or
+// SFTP mock
+type SSHConfig struct {
+	Commands *OnMessage `json:"commands"` // rules matched against each command line
+	Prompt   string     `json:"prompt"`   // optional shell prompt template, "$ " by default
+	Banner   string     `json:"banner"`   // optional template written when a shell starts
+}
+
+// SFTPConfig controls where an SFTP mock keeps its files
+type SFTPConfig struct {
+	Root     string      `json:"root"`     // optional directory, a temporary one removed on stop when empty
+	InMemory bool        `json:"inMemory"` // optional, keep every file in memory instead of on disk
+	Dirs     []DirEntry  `json:"dirs"`     // optional directories with their attributes
+	Quota    string      `json:"quota"`    // optional total size of the tree, e.g. "10MB"
+	Faults   []SFTPFault `json:"faults"`   // optional injected errors
+
+	HostKey     string `json:"hostKey"`     // optional inline PEM private key
+	HostKeyPath string `json:"hostKeyPath"` // optional private key file, generated when missing
+	HostKeyType string `json:"hostKeyType"` // optional type of a generated key: ed25519 (default) or rsa
+}
+
+// FTPConfig controls the control and data channels of an FTP mock. Files,
+// credentials and storage are shared with SFTP mocks (files, sftpAuth, sftp).
+type FTPConfig struct {
+	TLS          string `json:"tls"`          // optional: none (default), explicit (AUTH TLS required), optional or implicit
+	CertFile     string `json:"certFile"`     // optional PEM certificate, self-signed when empty
+	KeyFile      string `json:"keyFile"`      // optional PEM private key of certFile
+	PassivePorts string `json:"passivePorts"` // optional data port range, e.g. "30000-30009"
+	PublicHost   string `json:"publicHost"`   // optional IPv4 address announced in PASV replies
+	Welcome      string `json:"welcome"`      // optional templated greeting
+}
+
+// UploadRule reacts to a file uploaded to an SFTP mock
+type UploadRule struct {
+	Path    string         `json:"path"`    // glob matched against the uploaded path, or its name when it has no "/"
+	Write   []FileEntry    `json:"write"`   // optional files to create, path and content are templates
+	MoveTo  string         `json:"moveTo"`  // optional templated target, a directory when it ends with "/"
+	Publish *PublishAction `json:"publish"` // optional event for other mocks
 }
 
 type Session struct {
@@ -50,16 +209,53 @@ type Context struct {
 	Variables map[string]any `json:"variables"`
 }
 
+// ClockConfig sets the initial time of a mock's virtual clock. It can be
+// changed while the mock runs through the admin API.
+type ClockConfig struct {
+	Freeze string `json:"freeze"` // optional RFC3339 instant the clock stays at
+	Offset string `json:"offset"` // optional duration added to the real time, e.g. -24h
+}
+
+// StoreConfig sets the initial values of a mock's key-value store and the
+// file it is saved to between runs
+type StoreConfig struct {
+	Initial map[string]any `json:"initial"` // optional values the store starts with
+	File    string         `json:"file"`    // optional JSON file, relative to the mock file; its values win over initial
+}
+
+// LimitsConfig bounds every render of a mock's templates. Unset fields keep
+// the defaults: 5s, 10MB and 32 nested calls.
+type LimitsConfig struct {
+	Timeout   string   `json:"timeout"`   // optional duration per render, e.g. 500ms
+	MaxOutput string   `json:"maxOutput"` // optional size per render, e.g. 1MB
+	MaxDepth  int      `json:"maxDepth"`  // optional nesting of templates and inline function calls
+	Allow     []string `json:"allow"`     // optional risky functions the templates may call, e.g. publish; all when unset
+}
+
 type MockDefinition struct {
-	Protocol  string            `json:"protocol"` // http, tcp, ws, sftp
+	Protocol  string            `json:"protocol"` // http, tcp, ws, sftp, ssh, ftp
 	Port      int               `json:"port"`
 	Meta      Meta              `json:"meta"`
 	Routes    []Route           `json:"routes"`    // http
 	OnMessage *OnMessage        `json:"onMessage"` // tcp/ws
-	Files     []FileEntry       `json:"files"`     // sftp
-	SFTPAuth  *SFTPAuth         `json:"sftpAuth"`  // sftp credentials
+	Endpoints []WSEndpoint      `json:"endpoints"` // ws, optional multi-path
+	Heartbeat *Heartbeat        `json:"heartbeat"` // ws, optional
+	Files     []FileEntry       `json:"files"`     // sftp/ftp
+	SFTPAuth  *SFTPAuth         `json:"sftpAuth"`  // sftp/ftp credentials
+	SFTP      *SFTPConfig       `json:"sftp"`      // sftp/ftp, optional storage settings
+	OnUpload  []UploadRule      `json:"onUpload"`  // sftp/ftp, optional
+	SSH       *SSHConfig        `json:"ssh"`       // ssh/sftp, optional exec and shell rules
+	FTP       *FTPConfig        `json:"ftp"`       // ftp, optional TLS and data channel settings
 	Session   *Session          `json:"session"`   // optional
 	Context   *Context          `json:"context"`   // optional
 	Functions map[string]string `json:"functions"` // optional
 	Import    []string          `json:"import"`    // optional
+	Seed      *int64            `json:"seed"`      // optional, makes uuid and random template functions deterministic
+	Clock     *ClockConfig      `json:"clock"`     // optional virtual clock for time template functions
+	Store     *StoreConfig      `json:"store"`     // optional state shared by the mock's templates
+	Subscribe []Subscription    `json:"subscribe"` // optional reactions to events of other mocks
+	Limits    *LimitsConfig     `json:"limits"`    // optional bounds of the template renders
+
+	BaseDir string              `json:"-"` // directory of the mock file, set by the loader
+	Source  *diagnostics.Source `json:"-"` // field positions in the mock file, set by the loader
 }
@@ -0,0 +1,139 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TemplateField is a template of a mock with the field it comes from
+type TemplateField struct {
+	Field string // e.g. routes[3].response.body
+	Text  string
+}
+
+// Templates lists the inline templates of a mock, named after their fields
+// as the runtime renders them, so they can be checked before it starts.
+// Inline functions and the content of source files are not included.
+func (def *MockDefinition) Templates() []TemplateField {
+	var out []TemplateField
+	add := func(field, text string) {
+		if text != "" {
+			out = append(out, TemplateField{Field: field, Text: text})
+		}
+	}
+	addMap := func(field string, m map[string]string) {
+		for _, k := range sortedKeys(m) {
+			add(field+"."+k, m[k])
+		}
+	}
+	addPublish := func(field string, p *PublishAction) {
+		if p != nil {
+			add(field+".event", p.Event)
+			add(field+".data", p.Data)
+		}
+	}
+	addBroadcast := func(field string, b *Broadcast) {
+		if b != nil {
+			add(field+".message", b.Message)
+			add(field+".room", b.Room)
+			addMap(field+".to", b.To)
+		}
+	}
+	// seed files render their content unless it is base64 or marked
+	// template: false
+	addFiles := func(field string, files []FileEntry) {
+		for i, f := range files {
+			name := fmt.Sprintf("%s[%d]", field, i)
+			add(name+".path", f.Path)
+			if f.Source == "" && (f.Template == nil && f.Encoding != "base64" || f.Template != nil && *f.Template) {
+				add(name+".content", f.Content)
+			}
+		}
+	}
+	// the files of onUpload and subscribe always render their content
+	addWrites := func(field string, files []FileEntry) {
+		for i, f := range files {
+			name := fmt.Sprintf("%s[%d]", field, i)
+			add(name+".path", f.Path)
+			add(name+".content", f.Content)
+		}
+	}
+	addRules := func(field string, on *OnMessage) {
+		if on == nil {
+			return
+		}
+		for i, cond := range on.Conditions {
+			name := fmt.Sprintf("%s.conditions[%d]", field, i)
+			add(name+".if", cond.If)
+			addMap(name+".set", cond.Set)
+			add(name+".join", cond.Join)
+			add(name+".leave", cond.Leave)
+			addBroadcast(name+".broadcast", cond.Broadcast)
+			addPublish(name+".publish", cond.Publish)
+			add(name+".respond", cond.Respond)
+			if cond.Close != nil {
+				add(name+".close.reason", cond.Close.Reason)
+			}
+			add(name+".stderr", cond.Stderr)
+		}
+		add(field+".else", on.Else)
+	}
+
+	for i, route := range def.Routes {
+		name := fmt.Sprintf("routes[%d]", i)
+		if strings.Contains(route.Path, "{{") {
+			add(name+".path", route.Path)
+		}
+		addMap(name+".response.headers", route.Response.Headers)
+		add(name+".response.body", route.Response.Body)
+		addPublish(name+".publish", route.Publish)
+	}
+	addRules("onMessage", def.OnMessage)
+	for i, ep := range def.Endpoints {
+		name := fmt.Sprintf("endpoints[%d]", i)
+		for j, rule := range ep.Reject {
+			add(fmt.Sprintf("%s.reject[%d].if", name, j), rule.If)
+			add(fmt.Sprintf("%s.reject[%d].body", name, j), rule.Body)
+		}
+		addRules(name+".onMessage", ep.OnMessage)
+	}
+	addFiles("files", def.Files)
+	if def.SFTPAuth != nil {
+		for i, u := range def.SFTPAuth.Users {
+			addFiles(fmt.Sprintf("sftpAuth.users[%d].files", i), u.Files)
+		}
+	}
+	for i, rule := range def.OnUpload {
+		name := fmt.Sprintf("onUpload[%d]", i)
+		addWrites(name+".write", rule.Write)
+		add(name+".moveTo", rule.MoveTo)
+		addPublish(name+".publish", rule.Publish)
+	}
+	if def.SSH != nil {
+		add("ssh.banner", def.SSH.Banner)
+		add("ssh.prompt", def.SSH.Prompt)
+		addRules("ssh.commands", def.SSH.Commands)
+	}
+	if def.FTP != nil {
+		add("ftp.welcome", def.FTP.Welcome)
+	}
+	for i, sub := range def.Subscribe {
+		name := fmt.Sprintf("subscribe[%d]", i)
+		add(name+".if", sub.If)
+		add(name+".do", sub.Do)
+		addBroadcast(name+".broadcast", sub.Broadcast)
+		addWrites(name+".write", sub.Write)
+		addPublish(name+".publish", sub.Publish)
+	}
+	return out
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
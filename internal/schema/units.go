@@ -0,0 +1,88 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// MaxGeneratedSize bounds the size of generated seed files, which are built
+// in memory at once
+const MaxGeneratedSize = 256 << 20
+
+// ParseSize parses a byte size such as "512", "64KB", "10MB" or "1G".
+// Units are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/factor {
+		return 0, fmt.Errorf("invalid size %q, too large", s)
+	}
+	return n * factor, nil
+}
+
+// ParseFileMode parses octal permission bits such as "0644" or "755"
+func ParseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// ParseOwner parses a numeric "uid" or "uid:gid" owner. A missing gid
+// equals the uid.
+func ParseOwner(s string) (uid, gid uint32, err error) {
+	u, g, found := strings.Cut(strings.TrimSpace(s), ":")
+	uid64, err := strconv.ParseUint(u, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid owner %q", s)
+	}
+	gid64 := uid64
+	if found {
+		if gid64, err = strconv.ParseUint(g, 10, 32); err != nil {
+			return 0, 0, fmt.Errorf("invalid owner %q", s)
+		}
+	}
+	return uint32(uid64), uint32(gid64), nil
+}
+
+// ParsePortRange parses a port range such as "30000-30009" or a single port
+func ParsePortRange(s string) (int, int, error) {
+	lo, hi, found := strings.Cut(strings.TrimSpace(s), "-")
+	if !found {
+		hi = lo
+	}
+	first, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	last, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return first, last, nil
+}
@@ -1,8 +1,16 @@
 package schema
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/usekuro/usekuro/internal/jsonpath"
 )
 
 func Validate(def *MockDefinition) error {
@@ -11,22 +19,449 @@ func Validate(def *MockDefinition) error {
 		if len(def.Routes) == 0 {
 			return errors.New("⚠️ 'routes' must be defined for HTTP protocol")
 		}
-	case "tcp", "ws":
+		for i, r := range def.Routes {
+			if err := validatePublish(fmt.Sprintf("routes[%d].publish", i), r.Publish); err != nil {
+				return err
+			}
+		}
+	case "tcp":
 		if def.OnMessage == nil {
 			return errors.New("⚠️ 'onMessage' must be defined for TCP/WS protocol")
 		}
-	case "sftp":
-		if len(def.Files) == 0 {
+		if err := validateOnMessage("onMessage", def.OnMessage); err != nil {
+			return err
+		}
+	case "ws":
+		if def.OnMessage == nil && len(def.Endpoints) == 0 {
+			return errors.New("⚠️ 'onMessage' must be defined for TCP/WS protocol")
+		}
+		if def.OnMessage != nil && len(def.Endpoints) > 0 {
+			return errors.New("⚠️ 'onMessage' cannot be combined with 'endpoints', add it as an endpoint with path /")
+		}
+		if def.OnMessage != nil {
+			if err := validateOnMessage("onMessage", def.OnMessage); err != nil {
+				return err
+			}
+		}
+		if hb := def.Heartbeat; hb != nil {
+			for name, d := range map[string]string{"interval": hb.Interval, "timeout": hb.Timeout} {
+				if d == "" {
+					continue
+				}
+				if _, err := time.ParseDuration(d); err != nil {
+					return fmt.Errorf("⚠️ heartbeat.%s: invalid duration %q", name, d)
+				}
+			}
+		}
+		seen := map[string]bool{}
+		for i, ep := range def.Endpoints {
+			if ep.Path == "" || ep.Path[0] != '/' {
+				return fmt.Errorf("⚠️ endpoints[%d]: 'path' must start with /", i)
+			}
+			if seen[ep.Path] {
+				return fmt.Errorf("⚠️ endpoints[%d]: duplicate path %s", i, ep.Path)
+			}
+			seen[ep.Path] = true
+			if ep.OnMessage == nil {
+				return fmt.Errorf("⚠️ endpoints[%d]: 'onMessage' must be defined", i)
+			}
+			if err := validateOnMessage(fmt.Sprintf("endpoints[%d].onMessage", i), ep.OnMessage); err != nil {
+				return err
+			}
+		}
+	case "sftp", "ssh", "ftp":
+		if def.Protocol == "sftp" && len(def.Files) == 0 && !hasUserFiles(def.SFTPAuth) {
 			return errors.New("⚠️ 'files' must be defined for SFTP protocol")
 		}
+		if def.Protocol == "ssh" && (def.SSH == nil || def.SSH.Commands == nil) {
+			return errors.New("⚠️ 'ssh.commands' must be defined for SSH protocol")
+		}
 		if def.SFTPAuth == nil {
-			return errors.New("⚠️ 'sftpAuth' must be defined for SFTP protocol")
+			return fmt.Errorf("⚠️ 'sftpAuth' must be defined for %s protocol", strings.ToUpper(def.Protocol))
 		}
-		if def.SFTPAuth.Username == "" || def.SFTPAuth.Password == "" {
-			return errors.New("⚠️ 'sftpAuth' must include username and password")
+		if def.FTP != nil {
+			if def.Protocol != "ftp" {
+				return fmt.Errorf("⚠️ 'ftp' is only supported by the ftp protocol")
+			}
+			if err := validateFTPConfig(def.FTP); err != nil {
+				return err
+			}
+		}
+		if def.SSH != nil && def.SSH.Commands != nil {
+			if err := validateOnMessage("ssh.commands", def.SSH.Commands); err != nil {
+				return err
+			}
+		}
+		if err := validateSFTPAuth(def.SFTPAuth); err != nil {
+			return err
+		}
+		for i, f := range def.Files {
+			if err := validateFileEntry(fmt.Sprintf("files[%d]", i), f); err != nil {
+				return err
+			}
+		}
+		if def.SFTP != nil {
+			if err := validateSFTPConfig(def.SFTP); err != nil {
+				return err
+			}
+		}
+		for i, rule := range def.OnUpload {
+			if rule.Path == "" {
+				return fmt.Errorf("⚠️ onUpload[%d]: 'path' is required", i)
+			}
+			if _, err := path.Match(rule.Path, ""); err != nil {
+				return fmt.Errorf("⚠️ onUpload[%d]: invalid path pattern %q", i, rule.Path)
+			}
+			if len(rule.Write) == 0 && rule.MoveTo == "" && rule.Publish == nil {
+				return fmt.Errorf("⚠️ onUpload[%d]: 'write', 'moveTo' or 'publish' is required", i)
+			}
+			if err := validatePublish(fmt.Sprintf("onUpload[%d].publish", i), rule.Publish); err != nil {
+				return err
+			}
 		}
 	default:
 		return fmt.Errorf("❌ unsupported protocol: %s", def.Protocol)
 	}
+	if def.Clock != nil {
+		if err := validateClock(def.Clock); err != nil {
+			return err
+		}
+	}
+	if def.Limits != nil {
+		if err := validateLimits(def.Limits); err != nil {
+			return err
+		}
+	}
+	for i, sub := range def.Subscribe {
+		if err := validateSubscription(fmt.Sprintf("subscribe[%d]", i), def.Protocol, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLimits(l *LimitsConfig) error {
+	if l.Timeout != "" {
+		if d, err := time.ParseDuration(l.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("⚠️ limits.timeout: invalid duration %q", l.Timeout)
+		}
+	}
+	if l.MaxOutput != "" {
+		if n, err := ParseSize(l.MaxOutput); err != nil || n == 0 {
+			return fmt.Errorf("⚠️ limits.maxOutput: invalid size %q", l.MaxOutput)
+		}
+	}
+	if l.MaxDepth < 0 {
+		return fmt.Errorf("⚠️ limits.maxDepth: must not be negative, got %d", l.MaxDepth)
+	}
+	return nil
+}
+
+func validatePublish(field string, p *PublishAction) error {
+	if p != nil && p.Event == "" {
+		return fmt.Errorf("⚠️ %s: 'event' is required", field)
+	}
+	return nil
+}
+
+func validateSubscription(field, protocol string, sub Subscription) error {
+	if sub.Event == "" {
+		return fmt.Errorf("⚠️ %s: 'event' is required", field)
+	}
+	if _, err := path.Match(sub.Event, ""); err != nil {
+		return fmt.Errorf("⚠️ %s: invalid event pattern %q", field, sub.Event)
+	}
+	if sub.Broadcast != nil && protocol != "tcp" && protocol != "ws" {
+		return fmt.Errorf("⚠️ %s: 'broadcast' is only supported by tcp and ws mocks", field)
+	}
+	if len(sub.Write) > 0 && protocol != "sftp" && protocol != "ssh" && protocol != "ftp" {
+		return fmt.Errorf("⚠️ %s: 'write' is only supported by sftp and ftp mocks", field)
+	}
+	for j, f := range sub.Write {
+		if f.Path == "" {
+			return fmt.Errorf("⚠️ %s.write[%d]: 'path' is required", field, j)
+		}
+	}
+	if sub.Do == "" && sub.Broadcast == nil && len(sub.Write) == 0 && sub.Publish == nil {
+		return fmt.Errorf("⚠️ %s: 'do', 'broadcast', 'write' or 'publish' is required", field)
+	}
+	return validatePublish(field+".publish", sub.Publish)
+}
+
+func validateClock(cfg *ClockConfig) error {
+	if cfg.Freeze != "" && cfg.Offset != "" {
+		return errors.New("⚠️ clock.freeze and clock.offset are mutually exclusive")
+	}
+	if cfg.Freeze != "" {
+		if _, err := time.Parse(time.RFC3339, cfg.Freeze); err != nil {
+			return fmt.Errorf("⚠️ clock.freeze must be an RFC3339 time, got %q", cfg.Freeze)
+		}
+	}
+	if cfg.Offset != "" {
+		if _, err := time.ParseDuration(cfg.Offset); err != nil {
+			return fmt.Errorf("⚠️ clock.offset: invalid duration %q", cfg.Offset)
+		}
+	}
+	return nil
+}
+
+var jsonTypes = map[string]bool{
+	"string": true, "number": true, "boolean": true,
+	"object": true, "array": true, "null": true,
+}
+
+func validateOnMessage(field string, on *OnMessage) error {
+	switch on.Mode {
+	case "", "regex":
+		if on.Match != "" {
+			if _, err := regexp.Compile(on.Match); err != nil {
+				return fmt.Errorf("⚠️ %s.match: invalid regex: %w", field, err)
+			}
+		}
+	case "json":
+	default:
+		return fmt.Errorf("⚠️ %s.mode: unsupported mode %q (use regex or json)", field, on.Mode)
+	}
+
+	switch on.Binary {
+	case "", "raw", "base64", "hex":
+	default:
+		return fmt.Errorf("⚠️ %s.binary: unsupported value %q (use raw, base64 or hex)", field, on.Binary)
+	}
+
+	for i, rule := range on.Conditions {
+		switch rule.Encoding {
+		case "", "text", "base64", "hex":
+		default:
+			return fmt.Errorf("⚠️ %s.conditions[%d].encoding: unsupported value %q (use text, base64 or hex)", field, i, rule.Encoding)
+		}
+		if err := validatePublish(fmt.Sprintf("%s.conditions[%d].publish", field, i), rule.Publish); err != nil {
+			return err
+		}
+		if len(rule.When) > 0 && on.Mode != "json" {
+			return fmt.Errorf("⚠️ %s.conditions[%d]: 'when' requires mode: json", field, i)
+		}
+		for j, c := range rule.When {
+			where := fmt.Sprintf("%s.conditions[%d].when[%d]", field, i, j)
+			if _, _, err := jsonpath.Get(nil, c.Path); err != nil {
+				return fmt.Errorf("⚠️ %s: %w", where, err)
+			}
+			if c.Type != "" && !jsonTypes[c.Type] {
+				return fmt.Errorf("⚠️ %s: unknown type %q", where, c.Type)
+			}
+			if c.Regex != "" {
+				if _, err := regexp.Compile(c.Regex); err != nil {
+					return fmt.Errorf("⚠️ %s: invalid regex: %w", where, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateSFTPAuth(auth *SFTPAuth) error {
+	if auth.Username == "" && len(auth.Users) == 0 {
+		return errors.New("⚠️ 'sftpAuth' must include username and password")
+	}
+	if auth.Username != "" && auth.Password == "" && auth.PublicKeyPath == "" {
+		return errors.New("⚠️ 'sftpAuth' must include username and password")
+	}
+
+	seen := map[string]bool{auth.Username: auth.Username != ""}
+	for i, u := range auth.Users {
+		if u.Username == "" {
+			return fmt.Errorf("⚠️ sftpAuth.users[%d]: 'username' is required", i)
+		}
+		if seen[u.Username] {
+			return fmt.Errorf("⚠️ sftpAuth.users[%d]: duplicate username %s", i, u.Username)
+		}
+		seen[u.Username] = true
+		if u.Password == "" && u.PublicKeyPath == "" && len(u.PublicKeys) == 0 {
+			return fmt.Errorf("⚠️ sftpAuth.users[%d]: a password or public key is required", i)
+		}
+		if err := validateSFTPUser(fmt.Sprintf("sftpAuth.users[%d]", i), u); err != nil {
+			return err
+		}
+	}
+
+	if auth.MaxAttempts < 0 {
+		return errors.New("⚠️ sftpAuth.maxAttempts must not be negative")
+	}
+	if auth.Lockout != "" {
+		if _, err := time.ParseDuration(auth.Lockout); err != nil {
+			return fmt.Errorf("⚠️ sftpAuth.lockout: invalid duration %q", auth.Lockout)
+		}
+	}
+	return nil
+}
+
+// hasUserFiles reports whether any user brings their own seed files
+func hasUserFiles(auth *SFTPAuth) bool {
+	if auth == nil {
+		return false
+	}
+	for _, u := range auth.Users {
+		if len(u.Files) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func validateSFTPUser(field string, u SFTPUser) error {
+	hasKeys := u.PublicKeyPath != "" || len(u.PublicKeys) > 0
+	switch u.Auth {
+	case "", "any":
+	case "password":
+		if u.Password == "" {
+			return fmt.Errorf("⚠️ %s: auth password requires a password", field)
+		}
+	case "publickey":
+		if !hasKeys {
+			return fmt.Errorf("⚠️ %s: auth publickey requires public keys", field)
+		}
+	case "both":
+		if u.Password == "" || !hasKeys {
+			return fmt.Errorf("⚠️ %s: auth both requires a password and public keys", field)
+		}
+	default:
+		return fmt.Errorf("⚠️ %s: auth must be any, password, publickey or both, got %q", field, u.Auth)
+	}
+
+	for j, f := range u.Files {
+		if err := validateFileEntry(fmt.Sprintf("%s.files[%d]", field, j), f); err != nil {
+			return err
+		}
+	}
+	for j, d := range u.Dirs {
+		dirField := fmt.Sprintf("%s.dirs[%d]", field, j)
+		if d.Path == "" {
+			return fmt.Errorf("⚠️ %s: 'path' is required", dirField)
+		}
+		if err := validateFileAttrs(dirField, d.Mode, d.Owner, d.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var faultErrors = map[string]bool{"permission": true, "nospace": true, "notfound": true, "failure": true}
+
+var faultOps = map[string]bool{
+	"": true, "open": true, "read": true, "write": true, "rename": true,
+	"remove": true, "mkdir": true, "rmdir": true, "setstat": true, "list": true,
+}
+
+func validateFTPConfig(cfg *FTPConfig) error {
+	switch cfg.TLS {
+	case "", "none", "explicit", "optional", "implicit":
+	default:
+		return fmt.Errorf("⚠️ ftp.tls must be none, explicit, optional or implicit, got %q", cfg.TLS)
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("⚠️ ftp.certFile and ftp.keyFile must be set together")
+	}
+	if cfg.PassivePorts != "" {
+		if _, _, err := ParsePortRange(cfg.PassivePorts); err != nil {
+			return fmt.Errorf("⚠️ ftp.passivePorts: %v", err)
+		}
+	}
+	if cfg.PublicHost != "" {
+		if ip := net.ParseIP(cfg.PublicHost); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("⚠️ ftp.publicHost must be an IPv4 address, got %q", cfg.PublicHost)
+		}
+	}
+	return nil
+}
+
+func validateSFTPConfig(cfg *SFTPConfig) error {
+	if cfg.InMemory && cfg.Root != "" {
+		return errors.New("⚠️ sftp.root cannot be used with sftp.inMemory")
+	}
+	for i, d := range cfg.Dirs {
+		field := fmt.Sprintf("sftp.dirs[%d]", i)
+		if d.Path == "" {
+			return fmt.Errorf("⚠️ %s: 'path' is required", field)
+		}
+		if err := validateFileAttrs(field, d.Mode, d.Owner, d.ModTime); err != nil {
+			return err
+		}
+	}
+	if cfg.HostKey != "" && cfg.HostKeyPath != "" {
+		return errors.New("⚠️ sftp.hostKey and sftp.hostKeyPath are mutually exclusive")
+	}
+	switch cfg.HostKeyType {
+	case "", "ed25519", "rsa":
+	default:
+		return fmt.Errorf("⚠️ sftp.hostKeyType must be ed25519 or rsa, got %q", cfg.HostKeyType)
+	}
+	if cfg.Quota != "" {
+		if _, err := ParseSize(cfg.Quota); err != nil {
+			return fmt.Errorf("⚠️ sftp.quota: %v", err)
+		}
+	}
+	for i, f := range cfg.Faults {
+		if _, err := path.Match(f.Path, ""); err != nil || f.Path == "" {
+			return fmt.Errorf("⚠️ sftp.faults[%d]: invalid path pattern %q", i, f.Path)
+		}
+		if !faultOps[f.Op] {
+			return fmt.Errorf("⚠️ sftp.faults[%d]: unknown op %q", i, f.Op)
+		}
+		if !faultErrors[f.Error] {
+			return fmt.Errorf("⚠️ sftp.faults[%d]: error must be permission, nospace, notfound or failure", i)
+		}
+		if f.AfterBytes < 0 || (f.AfterBytes > 0 && f.Op != "write") {
+			return fmt.Errorf("⚠️ sftp.faults[%d]: 'afterBytes' needs op write and a positive value", i)
+		}
+	}
+	return nil
+}
+
+func validateFileEntry(field string, f FileEntry) error {
+	if f.Path == "" {
+		return fmt.Errorf("⚠️ %s: 'path' is required", field)
+	}
+	if f.Source != "" && f.Content != "" {
+		return fmt.Errorf("⚠️ %s: 'content' and 'source' are mutually exclusive", field)
+	}
+	switch f.Encoding {
+	case "", "text":
+	case "base64":
+		if (f.Template == nil || !*f.Template) && f.Source == "" {
+			if _, err := base64.StdEncoding.DecodeString(f.Content); err != nil {
+				return fmt.Errorf("⚠️ %s.content: invalid base64: %v", field, err)
+			}
+		}
+	default:
+		return fmt.Errorf("⚠️ %s.encoding must be text or base64", field)
+	}
+	if f.Size != "" {
+		size, err := ParseSize(f.Size)
+		if err != nil {
+			return fmt.Errorf("⚠️ %s.size: %v", field, err)
+		}
+		if size > MaxGeneratedSize {
+			return fmt.Errorf("⚠️ %s.size: %s is more than the maximum of 256MB", field, f.Size)
+		}
+	}
+	return validateFileAttrs(field, f.Mode, f.Owner, f.ModTime)
+}
+
+func validateFileAttrs(field, mode, owner, modTime string) error {
+	if mode != "" {
+		if _, err := ParseFileMode(mode); err != nil {
+			return fmt.Errorf("⚠️ %s.mode: %v", field, err)
+		}
+	}
+	if owner != "" {
+		if _, _, err := ParseOwner(owner); err != nil {
+			return fmt.Errorf("⚠️ %s.owner: %v", field, err)
+		}
+	}
+	if modTime != "" {
+		if _, err := time.Parse(time.RFC3339, modTime); err != nil {
+			return fmt.Errorf("⚠️ %s.modTime: expected RFC3339, got %q", field, modTime)
+		}
+	}
 	return nil
 }
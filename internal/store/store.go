@@ -0,0 +1,283 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Global is the store shared by every mock of the process
+var Global = New(nil)
+
+// Store is a concurrent key-value store templates use to keep state across
+// requests and protocols: counters, created entities or flags. Values are
+// JSON-like (strings, numbers, booleans, maps and lists). When the store is
+// bound to a file, every change is saved to it.
+//
+// The methods are called from templates, e.g. {{ store.Incr "hits" }}, so
+// the ones that only change the store return an empty string.
+type Store struct {
+	mu   sync.RWMutex
+	data map[string]any
+	file string
+}
+
+// New returns an in-memory store holding a copy of initial
+func New(initial map[string]any) *Store {
+	data := make(map[string]any, len(initial))
+	for k, v := range initial {
+		data[k] = v
+	}
+	return &Store{data: data}
+}
+
+// Load binds the store to a JSON file. The file's values replace the current
+// ones when it exists; otherwise it is created with them.
+func (s *Store) Load(file string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := os.ReadFile(file)
+	switch {
+	case err == nil:
+		data, err := decode(raw)
+		if err != nil {
+			return fmt.Errorf("❌ invalid store file %s: %w", file, err)
+		}
+		s.data = data
+		s.file = file
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		s.file = file
+		return s.save()
+	default:
+		return fmt.Errorf("❌ reading store file %s: %w", file, err)
+	}
+}
+
+// File returns the file the store is saved to, if any
+func (s *Store) File() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.file
+}
+
+// Get returns the value of key, or fallback when it is not set
+func (s *Store) Get(key string, fallback ...any) any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if v, ok := s.data[key]; ok {
+		return v
+	}
+	if len(fallback) > 0 {
+		return fallback[0]
+	}
+	return nil
+}
+
+// Has reports whether key is set
+func (s *Store) Has(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.data[key]
+	return ok
+}
+
+// Set stores value under key
+func (s *Store) Set(key string, value any) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return "", s.save()
+}
+
+// Incr adds by, 1 by default, to the number under key and returns the
+// result. A missing key counts as 0.
+func (s *Store) Incr(key string, by ...any) (any, error) {
+	delta := any(1)
+	if len(by) > 0 {
+		delta = by[0]
+	}
+	d, dInt, err := number(delta)
+	if err != nil {
+		return nil, fmt.Errorf("store.Incr: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, curInt := 0.0, true
+	if v, ok := s.data[key]; ok {
+		if cur, curInt, err = number(v); err != nil {
+			return nil, fmt.Errorf("store.Incr: key %q: %w", key, err)
+		}
+	}
+	var result any = cur + d
+	if curInt && dInt {
+		result = int64(cur) + int64(d)
+	}
+	s.data[key] = result
+	return result, s.save()
+}
+
+// Append adds value to the end of the list under key, creating it when the
+// key is not set
+func (s *Store) Append(key string, value any) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []any
+	if v, ok := s.data[key]; ok {
+		if items, ok = v.([]any); !ok {
+			return "", fmt.Errorf("store.Append: key %q holds %T, not a list", key, v)
+		}
+	}
+	// copy, so lists already handed to templates do not change
+	next := make([]any, len(items), len(items)+1)
+	copy(next, items)
+	s.data[key] = append(next, value)
+	return "", s.save()
+}
+
+// Delete removes key
+func (s *Store) Delete(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return "", nil
+	}
+	delete(s.data, key)
+	return "", s.save()
+}
+
+// Keys returns the keys of the store in order
+func (s *Store) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// All returns a copy of the store's values
+func (s *Store) All() map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]any, len(s.data))
+	for k, v := range s.data {
+		out[k] = v
+	}
+	return out
+}
+
+// Clear removes every key
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = map[string]any{}
+	return s.save()
+}
+
+// save writes the store to its file, if any, through a temporary file so a
+// crash never leaves it half written. The caller holds the lock.
+func (s *Store) save() error {
+	if s.file == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("❌ saving store: %w", err)
+	}
+	if dir := filepath.Dir(s.file); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("❌ saving store: %w", err)
+		}
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("❌ saving store: %w", err)
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		return fmt.Errorf("❌ saving store: %w", err)
+	}
+	return nil
+}
+
+// decode reads a store file, keeping whole numbers as integers so counters
+// stay integers after a restart
+func decode(raw []byte) (map[string]any, error) {
+	data := map[string]any{}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return nil, err
+	}
+	for k, v := range data {
+		data[k] = fromJSON(v)
+	}
+	return data, nil
+}
+
+func fromJSON(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, item := range t {
+			t[k] = fromJSON(item)
+		}
+	case []any:
+		for i, item := range t {
+			t[i] = fromJSON(item)
+		}
+	}
+	return v
+}
+
+// number converts a stored or template value to float64 and reports whether
+// it is an integer
+func number(v any) (float64, bool, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true, nil
+	case int32:
+		return float64(n), true, nil
+	case int64:
+		return float64(n), true, nil
+	case uint:
+		return float64(n), true, nil
+	case uint64:
+		return float64(n), true, nil
+	case float32:
+		return float64(n), false, nil
+	case float64:
+		return n, n == math.Trunc(n), nil
+	case json.Number:
+		return number(string(n))
+	case string:
+		s := strings.TrimSpace(n)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return float64(i), true, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, false, nil
+		}
+	}
+	return 0, false, fmt.Errorf("%v (%T) is not a number", v, v)
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreOperations(t *testing.T) {
+	s := New(map[string]any{"hits": 5})
+
+	n, err := s.Incr("hits")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), n)
+	n, err = s.Incr("total", "2.5")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, n)
+
+	_, err = s.Append("users", "ana")
+	require.NoError(t, err)
+	before := s.Get("users")
+	_, err = s.Append("users", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, []any{"ana"}, before, "lists already read do not change")
+	assert.Equal(t, []any{"ana", "bob"}, s.Get("users"))
+
+	_, err = s.Set("flag", true)
+	require.NoError(t, err)
+	assert.Equal(t, true, s.Get("flag"))
+	assert.Equal(t, "none", s.Get("missing", "none"))
+	assert.Nil(t, s.Get("missing"))
+
+	_, err = s.Delete("flag")
+	require.NoError(t, err)
+	assert.False(t, s.Has("flag"))
+	assert.Equal(t, []string{"hits", "total", "users"}, s.Keys())
+
+	_, err = s.Incr("users")
+	assert.EqualError(t, err, `store.Incr: key "users": [ana bob] ([]interface {}) is not a number`)
+	_, err = s.Append("hits", 1)
+	assert.EqualError(t, err, `store.Append: key "hits" holds int64, not a list`)
+
+	require.NoError(t, s.Clear())
+	assert.Empty(t, s.Keys())
+}
+
+func TestStoreConcurrentIncr(t *testing.T) {
+	s := New(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = s.Incr("n")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(50), s.Get("n"))
+}
+
+func TestStorePersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state", "store.json")
+
+	s := New(map[string]any{"hits": 1})
+	require.NoError(t, s.Load(file))
+	_, err := s.Incr("hits")
+	require.NoError(t, err)
+	_, err = s.Append("orders", map[string]any{"id": 7, "total": 9.5})
+	require.NoError(t, err)
+
+	// The file wins over the initial values on the next run
+	restored := New(map[string]any{"hits": 1})
+	require.NoError(t, restored.Load(file))
+	assert.Equal(t, int64(2), restored.Get("hits"))
+	assert.Equal(t, []any{map[string]any{"id": int64(7), "total": 9.5}}, restored.Get("orders"))
+	assert.Equal(t, file, restored.File())
+
+	require.NoError(t, os.WriteFile(file, []byte("{broken"), 0644))
+	assert.Error(t, New(nil).Load(file))
+}
@@ -0,0 +1,94 @@
+package template
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time seen by template functions. It follows the real time,
+// optionally shifted by an offset, or stays frozen at a fixed instant.
+type Clock struct {
+	mu     sync.Mutex
+	frozen bool
+	at     time.Time     // the frozen instant
+	offset time.Duration // added to the real time when not frozen
+}
+
+// ClockState describes a clock for admin endpoints
+type ClockState struct {
+	Now    time.Time `json:"now"`
+	Frozen bool      `json:"frozen"`
+	Offset string    `json:"offset"`
+}
+
+func NewClock() *Clock {
+	return &Clock{}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now()
+}
+
+func (c *Clock) now() time.Time {
+	if c.frozen {
+		return c.at
+	}
+	return time.Now().Add(c.offset)
+}
+
+// Freeze stops the clock at t, or at the current time when t is zero
+func (c *Clock) Freeze(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.IsZero() {
+		t = c.now()
+	}
+	c.frozen, c.at = true, t
+}
+
+// Resume lets a frozen clock run again from the instant it was frozen at
+func (c *Clock) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		c.offset = time.Until(c.at)
+		c.frozen = false
+	}
+}
+
+// SetOffset runs the clock at the real time plus d
+func (c *Clock) SetOffset(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frozen, c.offset = false, d
+}
+
+// Advance moves the clock by d, which may be negative
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		c.at = c.at.Add(d)
+	} else {
+		c.offset += d
+	}
+}
+
+// Reset goes back to the real time
+func (c *Clock) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frozen, c.at, c.offset = false, time.Time{}, 0
+}
+
+func (c *Clock) State() ClockState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := ClockState{Now: c.now(), Frozen: c.frozen}
+	if !c.frozen {
+		state.Offset = c.offset.String()
+	}
+	return state
+}
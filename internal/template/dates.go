@@ -0,0 +1,178 @@
+package template
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namedLayouts are the layouts date and parseDate accept by name, besides
+// Go reference layouts such as "2006-01-02"
+var namedLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"RFC850":      time.RFC850,
+	"ANSIC":       time.ANSIC,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// inputLayouts are tried in order when a string is used as a time
+var inputLayouts = []string{
+	time.RFC3339Nano, time.RFC3339, time.DateTime, "2006-01-02T15:04:05", time.DateOnly,
+	time.RFC1123Z, time.RFC1123,
+}
+
+// dateFuncs format, parse and shift times. Times are time.Time values,
+// strings in RFC3339 or another common layout, or Unix seconds. A missing
+// time means now on the mock's clock.
+func dateFuncs(clock *Clock) map[string]any {
+	at := func(fn string, t []any) (time.Time, error) {
+		if len(t) == 0 {
+			return clock.Now(), nil
+		}
+		if len(t) > 1 {
+			return time.Time{}, fmt.Errorf("%s: expected at most one time, got %d", fn, len(t))
+		}
+		v, err := toTime(t[0])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", fn, err)
+		}
+		return v, nil
+	}
+
+	return map[string]any{
+		// date formats a time with a Go layout or a layout name, e.g.
+		// now | date "2006-01-02"
+		"date": func(layout string, t ...any) (string, error) {
+			v, err := at("date", t)
+			if err != nil {
+				return "", err
+			}
+			return v.Format(layoutOf(layout)), nil
+		},
+		// dateAdd shifts a time by a duration such as 90m, -24h or 7d
+		"dateAdd": func(duration string, t ...any) (string, error) {
+			v, err := at("dateAdd", t)
+			if err != nil {
+				return "", err
+			}
+			d, err := parseDuration(duration)
+			if err != nil {
+				return "", fmt.Errorf("dateAdd: %w", err)
+			}
+			return v.Add(d).Format(time.RFC3339), nil
+		},
+		// dateDiff returns the seconds from a to b
+		"dateDiff": func(a, b any) (int64, error) {
+			from, err := toTime(a)
+			if err != nil {
+				return 0, fmt.Errorf("dateDiff: %w", err)
+			}
+			to, err := toTime(b)
+			if err != nil {
+				return 0, fmt.Errorf("dateDiff: %w", err)
+			}
+			return int64(to.Sub(from).Seconds()), nil
+		},
+		"unix": func(t ...any) (int64, error) {
+			v, err := at("unix", t)
+			if err != nil {
+				return 0, err
+			}
+			return v.Unix(), nil
+		},
+		"unixMilli": func(t ...any) (int64, error) {
+			v, err := at("unixMilli", t)
+			if err != nil {
+				return 0, err
+			}
+			return v.UnixMilli(), nil
+		},
+		"fromUnix":  fromUnix,
+		"parseDate": parseDate,
+	}
+}
+
+// fromUnix turns Unix seconds into an RFC3339 time in UTC
+func fromUnix(secs any) (string, error) {
+	n, _, err := toNumber(secs)
+	if err != nil {
+		return "", fmt.Errorf("fromUnix: %w", err)
+	}
+	whole := int64(n)
+	return time.Unix(whole, int64((n-float64(whole))*1e9)).UTC().Format(time.RFC3339), nil
+}
+
+// parseDate reads a time written with layout and returns it in RFC3339
+func parseDate(layout string, value any) (string, error) {
+	s, err := toText("parseDate", value)
+	if err != nil {
+		return "", err
+	}
+	t, err := time.Parse(layoutOf(layout), s)
+	if err != nil {
+		return "", fmt.Errorf("parseDate: %w", err)
+	}
+	return t.Format(time.RFC3339), nil
+}
+
+func layoutOf(layout string) string {
+	if l, ok := namedLayouts[layout]; ok {
+		return l
+	}
+	return layout
+}
+
+func toTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t != nil {
+			return *t, nil
+		}
+	case string:
+		s := strings.TrimSpace(t)
+		for _, layout := range inputLayouts {
+			if parsed, err := time.Parse(layout, s); err == nil {
+				return parsed, nil
+			}
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.Time{}, fmt.Errorf("%q is not a time", t)
+	default:
+		if n, _, err := toNumber(v); err == nil {
+			return time.Unix(int64(n), 0).UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%v (%T) is not a time", v, v)
+}
+
+// parseDuration extends time.ParseDuration with days (d) and weeks (w),
+// e.g. 7d or -2w
+func parseDuration(s string) (time.Duration, error) {
+	v := strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(v, suffix); ok {
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(f * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
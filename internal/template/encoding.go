@@ -0,0 +1,114 @@
+package template
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+)
+
+// hashes are the algorithms of the hash functions and hmac
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// encodingFuncs encode, decode and hash text. Hashes are hex encoded.
+func encodingFuncs() map[string]any {
+	funcs := map[string]any{
+		"base64Encode": func(v any) (string, error) {
+			s, err := toText("base64Encode", v)
+			return base64.StdEncoding.EncodeToString([]byte(s)), err
+		},
+		"base64Decode": func(v any) (string, error) {
+			s, err := toText("base64Decode", v)
+			if err != nil {
+				return "", err
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				// tolerate URL-safe and unpadded input
+				if b, err = base64.RawURLEncoding.DecodeString(trimPadding(s)); err != nil {
+					return "", fmt.Errorf("base64Decode: invalid base64 input")
+				}
+			}
+			return string(b), nil
+		},
+		"hexEncode": func(v any) (string, error) {
+			s, err := toText("hexEncode", v)
+			return hex.EncodeToString([]byte(s)), err
+		},
+		"hexDecode": func(v any) (string, error) {
+			s, err := toText("hexDecode", v)
+			if err != nil {
+				return "", err
+			}
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				return "", fmt.Errorf("hexDecode: %w", err)
+			}
+			return string(b), nil
+		},
+		"urlEncode": func(v any) (string, error) {
+			s, err := toText("urlEncode", v)
+			return url.QueryEscape(s), err
+		},
+		"urlDecode": func(v any) (string, error) {
+			s, err := toText("urlDecode", v)
+			if err != nil {
+				return "", err
+			}
+			out, err := url.QueryUnescape(s)
+			if err != nil {
+				return "", fmt.Errorf("urlDecode: %w", err)
+			}
+			return out, nil
+		},
+		// hmac signs a message, e.g. .input.body | hmac "sha256" "secret"
+		"hmac": func(algorithm string, key, message any) (string, error) {
+			newHash, ok := hashes[algorithm]
+			if !ok {
+				return "", fmt.Errorf("hmac: unknown algorithm %q, expected md5, sha1, sha256 or sha512", algorithm)
+			}
+			k, err := toText("hmac", key)
+			if err != nil {
+				return "", err
+			}
+			m, err := toText("hmac", message)
+			if err != nil {
+				return "", err
+			}
+			mac := hmac.New(newHash, []byte(k))
+			mac.Write([]byte(m))
+			return hex.EncodeToString(mac.Sum(nil)), nil
+		},
+	}
+	for name, newHash := range hashes {
+		name, newHash := name, newHash
+		funcs[name] = func(v any) (string, error) {
+			s, err := toText(name, v)
+			if err != nil {
+				return "", err
+			}
+			h := newHash()
+			h.Write([]byte(s))
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+	return funcs
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
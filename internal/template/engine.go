@@ -2,36 +2,136 @@ package template
 
 import (
 	"bytes"
-	"text/template"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/usekuro/usekuro/internal/extensions"
 )
 
+// builtins are the text/template functions an inline function cannot replace
+var builtins = map[string]bool{
+	"and": true, "call": true, "html": true, "index": true, "slice": true,
+	"js": true, "len": true, "not": true, "or": true, "print": true,
+	"printf": true, "println": true, "urlquery": true, "eq": true, "ge": true,
+	"gt": true, "le": true, "lt": true, "ne": true,
+}
+
 type Runtime struct {
-	templates *template.Template
-	context   map[string]any
+	set        *Set
+	context    map[string]any
+	eventDepth int
 }
 
 // Nuevo: ahora acepta un Registry de extensiones
 func NewRuntime(ctx map[string]any, registry *extensions.Registry) (*Runtime, error) {
-	t := template.New("base").Funcs(FuncMap())
+	return NewRuntimeEnv(ctx, registry, nil)
+}
+
+// NewRuntimeEnv creates a runtime whose functions use the mock's environment,
+// or a fresh one when env is nil. The registry's inline functions can be
+// called like any other function and from its .kurof extensions. With an
+// environment, the templates are compiled once and shared by every runtime
+// of the mock.
+func NewRuntimeEnv(ctx map[string]any, registry *extensions.Registry, env *Env) (*Runtime, error) {
+	var (
+		set *Set
+		err error
+	)
+	if env == nil {
+		set, err = NewSet(registry, nil)
+	} else {
+		set, err = env.Templates(registry)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return set.Runtime(ctx)
+}
+
+// function returns the template function of an inline function. The body
+// sees the caller's context plus the arguments as .args and, when the
+// function declares parameters, by name.
+func (b *binding) function(fn extensions.Function) func(args ...any) (string, error) {
+	return func(args ...any) (string, error) {
+		if fn.Params != nil && len(args) != len(fn.Params) {
+			return "", fmt.Errorf("%s: expected %d arguments, got %d", fn.Signature(), len(fn.Params), len(args))
+		}
+		x := b.cur
+		if err := x.enter(fn.Name); err != nil {
+			return "", err
+		}
+		defer x.leave()
+
+		data := make(map[string]any, len(x.context)+len(fn.Params)+1)
+		for k, v := range x.context {
+			data[k] = v
+		}
+		data["args"] = args
+		for i, p := range fn.Params {
+			data[p] = args[i]
+		}
 
-	// Cargar extensiones kurof si existen
-	for _, ext := range registry.Extensions {
-		if _, err := t.New(ext.Name).Parse(ext.Content); err != nil {
-			return nil, err
+		var out bytes.Buffer
+		if err := b.templates.ExecuteTemplate(x.writer(&out), functionTemplate(fn), data); err != nil {
+			return "", err
 		}
+		return strings.TrimSpace(out.String()), nil
 	}
+}
 
-	return &Runtime{templates: t, context: ctx}, nil
+// functionTemplate names the template of an inline function apart from the
+// templates Render parses. Legacy bodies define their own, under the
+// function's name.
+func functionTemplate(fn extensions.Function) string {
+	if fn.Legacy() {
+		return fn.Name
+	}
+	return "func." + fn.Name
 }
 
+// Render executes raw, compiled once per set, within the limits of the
+// mock. Errors come as a *diagnostics.Diagnostic placing them in the mock
+// file; a render stopped by the limits wraps a *LimitError.
 func (r *Runtime) Render(name, raw string) (string, error) {
-	tmpl, err := r.templates.New(name).Parse(raw)
+	tname, err := r.set.Compile(name, raw)
 	if err != nil {
 		return "", err
 	}
+	b, err := r.set.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer r.set.release(b)
+	b.cur = newRender(r.set.limits, r.context)
+	b.cur.eventDepth = r.eventDepth
+
 	var out bytes.Buffer
-	err = tmpl.Execute(&out, r.context)
-	return out.String(), err
+	if err := b.templates.ExecuteTemplate(b.cur.writer(&out), tname, r.context); err != nil {
+		var limit *LimitError
+		if errors.As(err, &limit) {
+			return "", r.set.locate(name, limit)
+		}
+		return out.String(), r.set.locate(name, err)
+	}
+	return out.String(), nil
+}
+
+// SetEventDepth sets the depth of the events the runtime's templates
+// publish: the number of subscriptions that led to the render
+func (r *Runtime) SetEventDepth(depth int) {
+	r.eventDepth = depth
+}
+
+// CheckFunctions parses the functions block of a mock and its templates,
+// returning the functions in a registry
+func CheckFunctions(defs map[string]string) (*extensions.Registry, error) {
+	registry := extensions.NewRegistry()
+	if err := registry.RegisterFunctions(defs); err != nil {
+		return nil, err
+	}
+	if _, err := NewSet(registry, nil); err != nil {
+		return nil, err
+	}
+	return registry, nil
 }
@@ -0,0 +1,115 @@
+package template
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/usekuro/usekuro/internal/diagnostics"
+	"github.com/usekuro/usekuro/internal/events"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/store"
+)
+
+// Env is the state template functions share across the renders of a mock:
+// the random source behind uuid, random, randomItem and the fake* functions, and
+// the clock behind now. With a seed, the same sequence of renders produces
+// the same output on every run. Store keeps the mock's state between
+// renders and Global the state shared with every other mock; Events carries
+// what the mock publishes, as Mock, to the other mocks. Limits bounds each
+// render.
+type Env struct {
+	Clock  *Clock
+	Store  *store.Store
+	Global *store.Store
+	Events *events.Bus
+	Mock   string
+	Limits Limits
+	Source *diagnostics.Source // the mock's file, to place template errors in it
+
+	mu    sync.Mutex
+	seed  *int64
+	faker *faker
+
+	setMu    sync.Mutex
+	set      *Set
+	registry *extensions.Registry // the set was compiled from
+}
+
+// NewEnv returns an environment with the real clock, seeded with seed or
+// randomly when it is nil
+func NewEnv(seed *int64) *Env {
+	return &Env{
+		Clock:  NewClock(),
+		Store:  store.New(nil),
+		Global: store.Global,
+		Events: events.Default,
+		Limits: DefaultLimits,
+		seed:   seed,
+		faker:  newFaker(seed),
+	}
+}
+
+// Seed returns the seed of the random functions, if any
+func (e *Env) Seed() (int64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.seed == nil {
+		return 0, false
+	}
+	return *e.seed, true
+}
+
+// Reseed makes the random functions start over from seed, or from the
+// current seed when it is nil
+func (e *Env) Reseed(seed *int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if seed != nil {
+		e.seed = seed
+	}
+	e.faker.reseed(e.seed)
+}
+
+// Templates returns the compiled templates of the mock, compiling the
+// extensions and inline functions of registry on first use. A mock renders
+// with a single registry, so another one replaces the set.
+func (e *Env) Templates(registry *extensions.Registry) (*Set, error) {
+	e.setMu.Lock()
+	defer e.setMu.Unlock()
+	if e.set != nil && e.registry == registry {
+		return e.set, nil
+	}
+	set, err := NewSet(registry, e)
+	if err != nil {
+		return nil, err
+	}
+	e.set, e.registry = set, registry
+	return set, nil
+}
+
+func (e *Env) uuid() (string, error) {
+	id, err := uuid.NewRandomFromReader(e.faker)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// publish sends an event to the other mocks, e.g.
+// {{ publish "orders.created" .input }}. depth is the number of
+// subscriptions that led to it.
+func (e *Env) publish(depth int, name string, data ...any) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("publish: empty event name")
+	}
+	if len(data) > 1 {
+		return "", fmt.Errorf("publish: expected at most one value, got %d", len(data))
+	}
+	ev := events.Event{Name: name, Source: e.Mock, Depth: depth}
+	if len(data) == 1 {
+		ev.Data = data[0]
+	}
+	e.Events.Publish(ev)
+	return "", nil
+}
@@ -0,0 +1,443 @@
+package template
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// faker generates realistic random data for templates. Every function takes
+// an optional locale (en, es, fr or de) and defaults to en.
+type faker struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFaker(seed *int64) *faker {
+	f := &faker{}
+	f.reseed(seed)
+	return f
+}
+
+// reseed restarts the random sequence from seed, or from a random seed when
+// it is nil
+func (f *faker) reseed(seed *int64) {
+	src := rand.NewPCG(rand.Uint64(), rand.Uint64())
+	if seed != nil {
+		src = rand.NewPCG(uint64(*seed), uint64(*seed))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rng = rand.New(src)
+}
+
+// Read fills p with random bytes, so the faker can back uuid generation
+func (f *faker) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range p {
+		p[i] = byte(f.rng.Uint32())
+	}
+	return len(p), nil
+}
+
+func (f *faker) funcs() map[string]any {
+	return map[string]any{
+		"fakeFirstName":  f.firstName,
+		"fakeLastName":   f.lastName,
+		"fakeName":       f.name,
+		"fakeEmail":      f.email,
+		"fakeUsername":   f.username,
+		"fakePhone":      f.phone,
+		"fakeStreet":     f.street,
+		"fakeCity":       f.city,
+		"fakePostcode":   f.postcode,
+		"fakeCountry":    f.country,
+		"fakeAddress":    f.address,
+		"fakeCompany":    f.company,
+		"fakeIBAN":       f.iban,
+		"fakeCreditCard": f.creditCard,
+		"fakeLorem":      f.lorem,
+		"fakeSentence":   f.sentence,
+		"fakeParagraph":  f.paragraph,
+		"fakeIPv4":       f.ipv4,
+		"fakeIPv6":       f.ipv6,
+		"fakeUserAgent":  f.userAgent,
+		"random":         f.random,
+		"randomItem":     f.randomItem,
+		"repeat":         repeat,
+	}
+}
+
+func (f *faker) intn(n int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.IntN(n)
+}
+
+func (f *faker) float() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64()
+}
+
+func (f *faker) one(list []string) string {
+	return list[f.intn(len(list))]
+}
+
+// digits replaces every # in format with a random digit and every A with a
+// random uppercase letter
+func (f *faker) digits(format string) string {
+	var b strings.Builder
+	for _, r := range format {
+		switch r {
+		case '#':
+			b.WriteByte(byte('0' + f.intn(10)))
+		case 'A':
+			b.WriteByte(byte('A' + f.intn(26)))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func locale(args []string) (*fakerLocale, error) {
+	if len(args) == 0 || args[0] == "" {
+		return fakerLocales["en"], nil
+	}
+	l, ok := fakerLocales[strings.ToLower(args[0])]
+	if !ok {
+		return nil, fmt.Errorf("unknown locale %q, expected en, es, fr or de", args[0])
+	}
+	return l, nil
+}
+
+func (f *faker) firstName(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return f.one(l.firstNames), nil
+}
+
+func (f *faker) lastName(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return f.one(l.lastNames), nil
+}
+
+func (f *faker) name(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return f.one(l.firstNames) + " " + f.one(l.lastNames), nil
+}
+
+func (f *faker) username(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	first, last := asciiLower(f.one(l.firstNames)), asciiLower(f.one(l.lastNames))
+	switch f.intn(3) {
+	case 0:
+		return first + "." + last, nil
+	case 1:
+		return first[:1] + last + strconv.Itoa(f.intn(100)), nil
+	default:
+		return first + "_" + last + strconv.Itoa(1950+f.intn(60)), nil
+	}
+}
+
+func (f *faker) email(args ...string) (string, error) {
+	user, err := f.username(args...)
+	if err != nil {
+		return "", err
+	}
+	l, _ := locale(args)
+	return user + "@" + f.one(l.domains), nil
+}
+
+func (f *faker) phone(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return f.digits(l.phone), nil
+}
+
+func (f *faker) street(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(l.streetFmt, f.one(l.streets), strconv.Itoa(1+f.intn(200))), nil
+}
+
+func (f *faker) city(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return f.one(l.cities), nil
+}
+
+func (f *faker) postcode(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return f.digits(l.postcode), nil
+}
+
+func (f *faker) country(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return l.country, nil
+}
+
+func (f *faker) address(args ...string) (string, error) {
+	street, err := f.street(args...)
+	if err != nil {
+		return "", err
+	}
+	l, _ := locale(args)
+	return fmt.Sprintf("%s, %s %s, %s", street, f.digits(l.postcode), f.one(l.cities), l.country), nil
+}
+
+func (f *faker) company(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	return f.one(l.companies) + " " + f.one(l.suffixes), nil
+}
+
+// iban returns an IBAN with valid check digits, for the locale's country or
+// GB for en
+func (f *faker) iban(args ...string) (string, error) {
+	l, err := locale(args)
+	if err != nil {
+		return "", err
+	}
+	country := l.countryCode
+	if l.ibanCountry != "" {
+		country = l.ibanCountry
+	}
+	bban := f.digits(l.iban)
+	return country + ibanCheckDigits(country, bban) + bban, nil
+}
+
+// ibanCheckDigits computes the ISO 13616 check digits: the BBAN followed by
+// the country code and 00, with letters as numbers from 10, modulo 97
+func ibanCheckDigits(country, bban string) string {
+	var b strings.Builder
+	for _, r := range bban + country + "00" {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteString(strconv.Itoa(int(r-'A') + 10))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	n, _ := new(big.Int).SetString(b.String(), 10)
+	mod := new(big.Int).Mod(n, big.NewInt(97)).Int64()
+	return fmt.Sprintf("%02d", 98-mod)
+}
+
+// creditCard returns a card number that passes the Luhn check, for visa
+// (default), mastercard, amex or discover
+func (f *faker) creditCard(args ...string) (string, error) {
+	brand := "visa"
+	if len(args) > 0 && args[0] != "" {
+		brand = strings.ToLower(args[0])
+	}
+	card, ok := cardPrefixes[brand]
+	if !ok {
+		return "", fmt.Errorf("unknown card brand %q, expected visa, mastercard, amex or discover", brand)
+	}
+	number := f.one(card.prefixes)
+	for len(number) < card.length-1 {
+		number += strconv.Itoa(f.intn(10))
+	}
+	return number + luhnDigit(number), nil
+}
+
+// luhnDigit returns the check digit that makes number valid under Luhn
+func luhnDigit(number string) string {
+	sum := 0
+	double := true
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return strconv.Itoa((10 - sum%10) % 10)
+}
+
+// The text functions build their text at once, before the output limit or
+// the timeout of the render can stop them, so their counts stay small
+const (
+	maxWords     = 10_000
+	maxSentences = 1_000
+)
+
+// wordCount returns the count asked of fn, or def, up to max
+func wordCount(fn string, n []int, def, max int) (int, error) {
+	if len(n) == 0 || n[0] <= 0 {
+		return def, nil
+	}
+	if n[0] > max {
+		return 0, fmt.Errorf("%s: %d is more than the maximum of %d", fn, n[0], max)
+	}
+	return n[0], nil
+}
+
+// lorem returns n lorem ipsum words, 5 by default
+func (f *faker) lorem(n ...int) (string, error) {
+	count, err := wordCount("fakeLorem", n, 5, maxWords)
+	if err != nil {
+		return "", err
+	}
+	words := make([]string, count)
+	for i := range words {
+		words[i] = f.one(loremWords)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// sentence returns a capitalized lorem ipsum sentence of n words, or between
+// 6 and 12 words
+func (f *faker) sentence(n ...int) (string, error) {
+	count, err := wordCount("fakeSentence", n, 6+f.intn(7), maxWords)
+	if err != nil {
+		return "", err
+	}
+	s, _ := f.lorem(count)
+	return strings.ToUpper(s[:1]) + s[1:] + ".", nil
+}
+
+// paragraph returns n sentences, or between 3 and 5
+func (f *faker) paragraph(n ...int) (string, error) {
+	count, err := wordCount("fakeParagraph", n, 3+f.intn(3), maxSentences)
+	if err != nil {
+		return "", err
+	}
+	sentences := make([]string, count)
+	for i := range sentences {
+		sentences[i], _ = f.sentence()
+	}
+	return strings.Join(sentences, " "), nil
+}
+
+func (f *faker) ipv4() string {
+	return fmt.Sprintf("%d.%d.%d.%d", 1+f.intn(223), f.intn(256), f.intn(256), 1+f.intn(254))
+}
+
+func (f *faker) ipv6() string {
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0x20, 0x01
+	for i := 2; i < len(ip); i++ {
+		ip[i] = byte(f.intn(256))
+	}
+	return ip.String()
+}
+
+func (f *faker) userAgent() string {
+	return f.one(userAgents)
+}
+
+// random returns a number between min and max, both included. It is an int
+// when both bounds are integers and a float otherwise.
+func (f *faker) random(min, max any) (any, error) {
+	lo, loInt, err := toNumber(min)
+	if err != nil {
+		return nil, fmt.Errorf("random: %w", err)
+	}
+	hi, hiInt, err := toNumber(max)
+	if err != nil {
+		return nil, fmt.Errorf("random: %w", err)
+	}
+	if math.IsNaN(lo) || math.IsNaN(hi) {
+		return nil, fmt.Errorf("random: expected numbers, got %v and %v", min, max)
+	}
+	if hi < lo {
+		return nil, fmt.Errorf("random: max %v is lower than min %v", max, min)
+	}
+	span := hi - lo
+	if math.IsInf(span, 0) {
+		return nil, fmt.Errorf("random: the range from %v to %v is too large", min, max)
+	}
+	if loInt && hiInt {
+		// integers are exact as float64 up to 2^53
+		if math.Abs(lo) > 1<<53 || math.Abs(hi) > 1<<53 || span >= 1<<53 {
+			return nil, fmt.Errorf("random: the range from %v to %v is too large", min, max)
+		}
+		return int(lo) + f.intn(int(span)+1), nil
+	}
+	return lo + f.float()*span, nil
+}
+
+// randomItem returns a random element of a list
+func (f *faker) randomItem(list any) (any, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("randomItem: expected a list, got %T", list)
+	}
+	if v.Len() == 0 {
+		return nil, nil
+	}
+	return v.Index(f.intn(v.Len())).Interface(), nil
+}
+
+// maxRepeat bounds the lists repeat builds, which are allocated at once
+// before any limit of the render can stop it
+const maxRepeat = 1_000_000
+
+// repeat returns the list 0..n-1, so templates can range over it to build
+// lists of generated items
+func repeat(n any) ([]int, error) {
+	count, _, err := toNumber(n)
+	if err != nil || count != float64(int(count)) || count < 0 {
+		return nil, fmt.Errorf("repeat: expected a non-negative integer, got %v", n)
+	}
+	if count > maxRepeat {
+		return nil, fmt.Errorf("repeat: %v is more than the maximum of %d", n, maxRepeat)
+	}
+	list := make([]int, int(count))
+	for i := range list {
+		list[i] = i
+	}
+	return list, nil
+}
+
+var asciiFold = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "ae", "ã", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "oe", "õ", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "ue",
+	"ñ", "n", "ç", "c", "ß", "ss", " ", "", "'", "",
+)
+
+// asciiLower turns a name into something usable in usernames and emails
+func asciiLower(s string) string {
+	return asciiFold.Replace(strings.ToLower(s))
+}
@@ -0,0 +1,143 @@
+package template
+
+// fakerLocale holds the word lists and formats used to generate fake data for
+// one language or country. In formats, # is replaced by a digit.
+type fakerLocale struct {
+	firstNames  []string
+	lastNames   []string
+	streets     []string
+	streetFmt   string // %[1]s is the street name, %[2]s the number
+	cities      []string
+	country     string
+	countryCode string
+	postcode    string
+	phone       string
+	domains     []string
+	companies   []string
+	suffixes    []string
+	iban        string // BBAN layout, # digit and A letter
+	ibanCountry string // when it differs from countryCode
+}
+
+var fakerLocales = map[string]*fakerLocale{
+	"en": {
+		firstNames: []string{"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda",
+			"William", "Elizabeth", "David", "Susan", "Richard", "Jessica", "Joseph", "Sarah", "Thomas", "Karen",
+			"Charles", "Emily", "Daniel", "Olivia", "Matthew", "Sophia"},
+		lastNames: []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Miller", "Davis", "Wilson",
+			"Anderson", "Taylor", "Thomas", "Moore", "Martin", "Jackson", "Thompson", "White", "Harris", "Clark",
+			"Lewis", "Walker", "Hall", "Young"},
+		streets: []string{"Main Street", "Oak Avenue", "Maple Drive", "Cedar Lane", "Park Road", "Elm Street",
+			"Washington Avenue", "Lake View Drive", "Hillside Road", "Sunset Boulevard", "River Road", "Church Street"},
+		streetFmt: "%[2]s %[1]s",
+		cities: []string{"Springfield", "Riverside", "Franklin", "Greenville", "Bristol", "Clinton", "Fairview",
+			"Madison", "Georgetown", "Salem", "Arlington", "Ashland"},
+		country:     "United States",
+		countryCode: "US",
+		postcode:    "#####",
+		phone:       "+1 (###) ###-####",
+		domains:     []string{"example.com", "example.org", "example.net", "mail.test"},
+		companies: []string{"Acme", "Globex", "Initech", "Umbrella", "Stark", "Wayne", "Hooli", "Vandelay",
+			"Cyberdyne", "Soylent", "Wonka", "Tyrell"},
+		suffixes:    []string{"Inc.", "LLC", "Corp.", "Group", "Holdings"},
+		iban:        "AAAA##############",
+		ibanCountry: "GB",
+	},
+	"es": {
+		firstNames: []string{"Antonio", "María", "José", "Carmen", "Manuel", "Ana", "Francisco", "Isabel",
+			"David", "Laura", "Javier", "Lucía", "Carlos", "Marta", "Miguel", "Elena", "Pablo", "Sara",
+			"Alejandro", "Paula", "Sergio", "Cristina"},
+		lastNames: []string{"García", "Rodríguez", "González", "Fernández", "López", "Martínez", "Sánchez",
+			"Pérez", "Gómez", "Martín", "Jiménez", "Ruiz", "Hernández", "Díaz", "Moreno", "Álvarez", "Romero",
+			"Navarro", "Torres", "Domínguez"},
+		streets: []string{"Calle Mayor", "Calle Real", "Avenida de la Constitución", "Calle del Sol",
+			"Plaza de España", "Calle de Alcalá", "Paseo de Gracia", "Calle Nueva", "Avenida de América",
+			"Calle de la Paz", "Gran Vía", "Calle San Juan"},
+		streetFmt: "%[1]s, %[2]s",
+		cities: []string{"Madrid", "Barcelona", "Valencia", "Sevilla", "Zaragoza", "Málaga", "Bilbao",
+			"Alicante", "Córdoba", "Valladolid", "Granada", "Salamanca"},
+		country:     "España",
+		countryCode: "ES",
+		postcode:    "#####",
+		phone:       "+34 6## ### ###",
+		domains:     []string{"ejemplo.es", "correo.test", "example.com"},
+		companies: []string{"Iberia Digital", "Soluciones Norte", "Grupo Levante", "Tecnologías Atlas",
+			"Logística Sur", "Consultores Ebro", "Datos Meseta", "Servicios Cantábrico"},
+		suffixes: []string{"S.L.", "S.A.", "S.L.U."},
+		iban:     "####################",
+	},
+	"fr": {
+		firstNames: []string{"Jean", "Marie", "Pierre", "Nathalie", "Michel", "Isabelle", "Philippe", "Sylvie",
+			"Alain", "Catherine", "Nicolas", "Camille", "Julien", "Claire", "Thomas", "Léa", "Antoine", "Chloé",
+			"Louis", "Manon"},
+		lastNames: []string{"Martin", "Bernard", "Dubois", "Thomas", "Robert", "Richard", "Petit", "Durand",
+			"Leroy", "Moreau", "Simon", "Laurent", "Lefèbvre", "Michel", "Garcia", "David", "Bertrand", "Roux",
+			"Fournier", "Girard"},
+		streets: []string{"rue de la Paix", "avenue des Champs-Élysées", "rue Victor Hugo", "boulevard Saint-Michel",
+			"rue de la République", "place de la Mairie", "rue du Moulin", "avenue Jean Jaurès", "rue Pasteur",
+			"rue de l'Église"},
+		streetFmt: "%[2]s %[1]s",
+		cities: []string{"Paris", "Lyon", "Marseille", "Toulouse", "Nice", "Nantes", "Strasbourg",
+			"Montpellier", "Bordeaux", "Lille", "Rennes", "Reims"},
+		country:     "France",
+		countryCode: "FR",
+		postcode:    "#####",
+		phone:       "+33 6 ## ## ## ##",
+		domains:     []string{"exemple.fr", "courriel.test", "example.com"},
+		companies: []string{"Groupe Hexagone", "Atelier Lumière", "Solutions Rhône", "Numérique Loire",
+			"Conseil Alpin", "Transports Garonne", "Données Seine", "Industries Provence"},
+		suffixes: []string{"SA", "SARL", "SAS"},
+		iban:     "#######################",
+	},
+	"de": {
+		firstNames: []string{"Lukas", "Anna", "Maximilian", "Sophie", "Paul", "Marie", "Jonas", "Laura",
+			"Felix", "Lea", "Leon", "Hannah", "Finn", "Julia", "Elias", "Lena", "Noah", "Emma", "Ben", "Mia"},
+		lastNames: []string{"Müller", "Schmidt", "Schneider", "Fischer", "Weber", "Meyer", "Wagner", "Becker",
+			"Schulz", "Hoffmann", "Schäfer", "Koch", "Bauer", "Richter", "Klein", "Wolf", "Schröder", "Neumann",
+			"Schwarz", "Zimmermann"},
+		streets: []string{"Hauptstraße", "Schulstraße", "Gartenstraße", "Bahnhofstraße", "Dorfstraße",
+			"Bergstraße", "Birkenweg", "Lindenstraße", "Kirchstraße", "Waldstraße", "Ringstraße", "Goethestraße"},
+		streetFmt: "%[1]s %[2]s",
+		cities: []string{"Berlin", "Hamburg", "München", "Köln", "Frankfurt am Main", "Stuttgart", "Düsseldorf",
+			"Leipzig", "Dortmund", "Bremen", "Dresden", "Hannover"},
+		country:     "Deutschland",
+		countryCode: "DE",
+		postcode:    "#####",
+		phone:       "+49 1## #######",
+		domains:     []string{"beispiel.de", "post.test", "example.com"},
+		companies: []string{"Nordlicht", "Rheintal", "Alpenblick", "Hansa Logistik", "Elbe Daten",
+			"Schwarzwald Technik", "Spree Software", "Bavaria Systeme"},
+		suffixes: []string{"GmbH", "AG", "KG", "GmbH & Co. KG"},
+		iban:     "##################",
+	},
+}
+
+var loremWords = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit",
+	"sed", "do", "eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua",
+	"enim", "ad", "minim", "veniam", "quis", "nostrud", "exercitation", "ullamco", "laboris", "nisi",
+	"aliquip", "ex", "ea", "commodo", "consequat", "duis", "aute", "irure", "in", "reprehenderit",
+	"voluptate", "velit", "esse", "cillum", "fugiat", "nulla", "pariatur", "excepteur", "sint", "occaecat",
+	"cupidatat", "non", "proident", "sunt", "culpa", "qui", "officia", "deserunt", "mollit", "anim", "id",
+	"est", "laborum"}
+
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0",
+	"curl/8.7.1",
+	"PostmanRuntime/7.37.3",
+}
+
+// cardPrefixes maps each card brand to its IIN prefixes and number length
+var cardPrefixes = map[string]struct {
+	prefixes []string
+	length   int
+}{
+	"visa":       {[]string{"4"}, 16},
+	"mastercard": {[]string{"51", "52", "53", "54", "55", "2221", "2720"}, 16},
+	"amex":       {[]string{"34", "37"}, 15},
+	"discover":   {[]string{"6011", "65"}, 16},
+}
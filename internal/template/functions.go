@@ -8,20 +8,24 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/usekuro/usekuro/internal/store"
 )
 
+// FuncMap returns the template functions with a random seed and the real
+// clock
 func FuncMap() map[string]any {
-	return map[string]any{
-		"now":  func() string { return time.Now().Format(time.RFC3339) },
-		"uuid": func() string { return uuid.NewString() },
-		"toJSON": func(v any) string {
-			b, err := json.Marshal(v)
-			if err != nil {
-				return fmt.Sprintf(`"error: %v"`, err)
-			}
-			return string(b)
-		},
+	return NewEnv(nil).FuncMap()
+}
+
+// FuncMap returns the template functions bound to the environment. Functions
+// fail the render with an error naming the function, e.g. "upper: expected
+// text, got map[string]interface {}", instead of writing the error into the
+// output.
+func (e *Env) FuncMap() map[string]any {
+	funcs := map[string]any{
+		"now":        func() string { return e.Clock.Now().Format(time.RFC3339) },
+		"uuid":       e.uuid,
+		"toJSON":     toJSON,
 		"contains":   safeContains,
 		"regexMatch": safeRegexMatch,
 		"upper":      safeUpper,
@@ -33,78 +37,162 @@ func FuncMap() map[string]any {
 		"replace":    safeReplace,
 		"len":        safeLen,
 		"default":    safeDefault,
+		// store and globalStore keep state between renders, e.g.
+		// {{ store.Incr "hits" }} or {{ globalStore.Get "flag" false }}
+		"store":       func() *store.Store { return e.Store },
+		"globalStore": func() *store.Store { return e.Global },
+		"publish": func(name string, data ...any) (string, error) {
+			return e.publish(0, name, data...)
+		},
+	}
+	for _, group := range []map[string]any{
+		e.faker.funcs(),
+		jsonFuncs(),
+		mathFuncs(),
+		dateFuncs(e.Clock),
+		encodingFuncs(),
+	} {
+		for name, fn := range group {
+			funcs[name] = fn
+		}
 	}
+	return funcs
 }
 
-func safeContains(s, substr any) bool {
-	str, ok1 := s.(string)
-	sub, ok2 := substr.(string)
-	return ok1 && ok2 && strings.Contains(str, sub)
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("toJSON: %w", err)
+	}
+	return string(b), nil
 }
 
-func safeRegexMatch(pat, val any) bool {
-	p, ok1 := pat.(string)
-	v, ok2 := val.(string)
-	if !ok1 || !ok2 {
-		return false
+// toText returns the text of a scalar value: strings as they are, numbers
+// and booleans formatted and nil, such as a missing input field, as "".
+// Maps and lists are an error.
+func toText(fn string, v any) (string, error) {
+	switch s := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return s, nil
+	case []byte:
+		return string(s), nil
+	case fmt.Stringer:
+		return s.String(), nil
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Func, reflect.Chan:
+		return "", fmt.Errorf("%s: expected text, got %T", fn, v)
 	}
-	m, _ := regexp.MatchString(p, v)
-	return m
+	return fmt.Sprint(v), nil
 }
 
-func safeUpper(val any) string {
-	return strings.ToUpper(fmt.Sprint(val))
+// safeContains reports whether a text contains substr, a list contains an
+// item equal to substr or a map has the key substr. Missing values contain
+// nothing.
+func safeContains(s, substr any) bool {
+	if s == nil {
+		return false
+	}
+	switch v := normalize(s).(type) {
+	case []any:
+		for _, item := range v {
+			if looseEqual(item, substr) {
+				return true
+			}
+		}
+		return false
+	case map[string]any:
+		_, ok := v[fmt.Sprint(substr)]
+		return ok
+	}
+	str, err1 := toText("contains", s)
+	sub, err2 := toText("contains", substr)
+	return err1 == nil && err2 == nil && strings.Contains(str, sub)
 }
 
-func safeLower(val any) string {
-	s, ok := val.(string)
-	if !ok {
-		return "error: not a string"
+func safeRegexMatch(pat, val any) (bool, error) {
+	p, err := toText("regexMatch", pat)
+	if err != nil {
+		return false, err
+	}
+	v, err := toText("regexMatch", val)
+	if err != nil {
+		return false, err
 	}
-	return strings.ToLower(s)
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return false, fmt.Errorf("regexMatch: %w", err)
+	}
+	return re.MatchString(v), nil
 }
 
-func safeTitle(val any) string {
-	s, ok := val.(string)
-	if !ok {
-		return "error: not a string"
-	}
-	return strings.Title(s)
+func safeUpper(val any) (string, error) {
+	s, err := toText("upper", val)
+	return strings.ToUpper(s), err
 }
 
-func safeTrim(val any) string {
-	s, ok := val.(string)
-	if !ok {
-		return "error: not a string"
-	}
-	return strings.TrimSpace(s)
+func safeLower(val any) (string, error) {
+	s, err := toText("lower", val)
+	return strings.ToLower(s), err
 }
 
-func safeSplit(str, sep any) []string {
-	s, ok1 := str.(string)
-	sepStr, ok2 := sep.(string)
-	if !ok1 || !ok2 {
-		return []string{}
+func safeTitle(val any) (string, error) {
+	s, err := toText("title", val)
+	return strings.Title(s), err
+}
+
+func safeTrim(val any) (string, error) {
+	s, err := toText("trim", val)
+	return strings.TrimSpace(s), err
+}
+
+func safeSplit(str, sep any) ([]string, error) {
+	if str == nil {
+		return []string{}, nil
+	}
+	s, err := toText("split", str)
+	if err != nil {
+		return nil, err
 	}
-	return strings.Split(s, sepStr)
+	sepStr, err := toText("split", sep)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(s, sepStr), nil
 }
 
-func safeJoin(arr any, sep string) string {
-	list, ok := arr.([]string)
+// safeJoin joins the items of any list with sep
+func safeJoin(arr any, sep string) (string, error) {
+	if arr == nil {
+		return "", nil
+	}
+	items, ok := normalize(arr).([]any)
 	if !ok {
-		return "error: expected []string"
+		return "", fmt.Errorf("join: expected a list, got %T", arr)
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		s, err := toText("join", item)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = s
 	}
-	return strings.Join(list, sep)
+	return strings.Join(parts, sep), nil
 }
 
-func safeReplace(s, old, new any) string {
-	str, ok1 := s.(string)
-	oldStr, ok2 := old.(string)
-	newStr, ok3 := new.(string)
-	if !ok1 || !ok2 || !ok3 {
-		return "error: invalid args"
+func safeReplace(s, old, new any) (string, error) {
+	var args [3]string
+	for i, v := range []any{s, old, new} {
+		text, err := toText("replace", v)
+		if err != nil {
+			return "", err
+		}
+		args[i] = text
 	}
-	return strings.ReplaceAll(str, oldStr, newStr)
+	return strings.ReplaceAll(args[0], args[1], args[2]), nil
 }
 
 func safeLen(v any) int {
@@ -0,0 +1,360 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/usekuro/usekuro/internal/jsonpath"
+	"gopkg.in/yaml.v3"
+)
+
+// jsonFuncs parse, query and reshape JSON-like data. Functions that change a
+// map or a list return a copy, so the mock's context is never modified.
+func jsonFuncs() map[string]any {
+	return map[string]any{
+		"fromJSON":     fromJSON,
+		"toPrettyJSON": toPrettyJSON,
+		"toYAML":       toYAML,
+		"query":        query,
+		"dict":         dict,
+		"list":         list,
+		"set":          set,
+		"merge":        merge,
+		"pick":         pick,
+		"omit":         omit,
+		"filter":       filter,
+		"map":          mapField,
+		"sort":         sortList,
+		"find":         find,
+	}
+}
+
+// fromJSON decodes a JSON document, e.g. a string field of the input
+func fromJSON(v any) (any, error) {
+	var data []byte
+	switch s := v.(type) {
+	case string:
+		data = []byte(s)
+	case []byte:
+		data = s
+	default:
+		return nil, fmt.Errorf("fromJSON: expected a string, got %T", v)
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("fromJSON: %w", err)
+	}
+	return out, nil
+}
+
+func toPrettyJSON(v any) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("toPrettyJSON: %w", err)
+	}
+	return string(b), nil
+}
+
+func toYAML(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(normalize(v)); err != nil {
+		return "", fmt.Errorf("toYAML: %w", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// query evaluates a JSONPath such as $.users[0].name, .users[*].id or
+// users[-1] against doc. It returns nil when the path does not resolve.
+func query(doc any, path string) (any, error) {
+	v, _, err := jsonpath.Get(normalize(doc), path)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return v, nil
+}
+
+// dict builds a map from key and value pairs
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict: expected key and value pairs, got %d arguments", len(pairs))
+	}
+	out := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		out[key] = pairs[i+1]
+	}
+	return out, nil
+}
+
+func list(items ...any) []any {
+	return append([]any{}, items...)
+}
+
+// set returns a copy of m with key set to value
+func set(m any, key string, value any) (map[string]any, error) {
+	obj, err := asMap("set", m)
+	if err != nil {
+		return nil, err
+	}
+	out := copyMap(obj)
+	out[key] = value
+	return out, nil
+}
+
+// merge combines maps into a new one. Later maps win, nested maps are merged.
+func merge(maps ...any) (map[string]any, error) {
+	out := map[string]any{}
+	for _, m := range maps {
+		obj, err := asMap("merge", m)
+		if err != nil {
+			return nil, err
+		}
+		mergeInto(out, obj)
+	}
+	return out, nil
+}
+
+func mergeInto(dst, src map[string]any) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]any); ok {
+			if existing, ok := dst[k].(map[string]any); ok {
+				merged := copyMap(existing)
+				mergeInto(merged, sub)
+				dst[k] = merged
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// pick returns a map with only the given keys of m
+func pick(m any, keys ...string) (map[string]any, error) {
+	obj, err := asMap("pick", m)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, len(keys))
+	for _, k := range keys {
+		if v, ok := obj[k]; ok {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// omit returns a copy of m without the given keys
+func omit(m any, keys ...string) (map[string]any, error) {
+	obj, err := asMap("omit", m)
+	if err != nil {
+		return nil, err
+	}
+	out := copyMap(obj)
+	for _, k := range keys {
+		delete(out, k)
+	}
+	return out, nil
+}
+
+// filter returns the items whose field equals value, or is truthy when no
+// value is given. field may be a path such as address.city.
+func filter(items any, field string, value ...any) ([]any, error) {
+	l, err := asList("filter", items)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > 1 {
+		return nil, fmt.Errorf("filter: expected at most one value, got %d", len(value))
+	}
+	out := []any{}
+	for _, item := range l {
+		v, found := fieldOf(item, field)
+		if len(value) == 0 {
+			if found && truthy(v) {
+				out = append(out, item)
+			}
+		} else if found && looseEqual(v, value[0]) {
+			out = append(out, item)
+		}
+	}
+	return out, nil
+}
+
+// find returns the first item whose field equals value, or nil
+func find(items any, field string, value any) (any, error) {
+	l, err := asList("find", items)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range l {
+		if v, found := fieldOf(item, field); found && looseEqual(v, value) {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+// mapField returns the value of field in every item
+func mapField(items any, field string) ([]any, error) {
+	l, err := asList("map", items)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]any, len(l))
+	for i, item := range l {
+		out[i], _ = fieldOf(item, field)
+	}
+	return out, nil
+}
+
+// sortList returns a sorted copy of items, by field when given. A field
+// starting with - sorts in descending order.
+func sortList(items any, field ...string) ([]any, error) {
+	l, err := asList("sort", items)
+	if err != nil {
+		return nil, err
+	}
+	if len(field) > 1 {
+		return nil, fmt.Errorf("sort: expected at most one field, got %d", len(field))
+	}
+	key, desc := "", false
+	if len(field) == 1 {
+		key = field[0]
+		if strings.HasPrefix(key, "-") {
+			key, desc = key[1:], true
+		}
+	}
+	value := func(item any) any {
+		if key == "" {
+			return item
+		}
+		v, _ := fieldOf(item, key)
+		return v
+	}
+
+	out := append([]any{}, l...)
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := value(out[i]), value(out[j])
+		if desc {
+			a, b = b, a
+		}
+		return less(a, b)
+	})
+	return out, nil
+}
+
+// less orders numbers numerically and anything else by its text; missing
+// values come first
+func less(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	x, _, errA := toNumber(a)
+	y, _, errB := toNumber(b)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// looseEqual compares values the way they look in JSON, so the string "2"
+// from a path or query parameter matches the number 2
+func looseEqual(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	x, _, errA := toNumber(a)
+	y, _, errB := toNumber(b)
+	if errA == nil && errB == nil {
+		return x == y
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func truthy(v any) bool {
+	if v == nil {
+		return false
+	}
+	rv := reflect.ValueOf(v)
+	return !rv.IsZero() && !((rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0)
+}
+
+// fieldOf returns the value at a field or dotted path of a map
+func fieldOf(item any, field string) (any, bool) {
+	v, found, err := jsonpath.Get(normalize(item), field)
+	if err != nil {
+		return nil, false
+	}
+	return v, found
+}
+
+func asMap(fn string, v any) (map[string]any, error) {
+	if v == nil {
+		return map[string]any{}, nil
+	}
+	m, ok := normalize(v).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a map, got %T", fn, v)
+	}
+	return m, nil
+}
+
+func asList(fn string, v any) ([]any, error) {
+	if v == nil {
+		return []any{}, nil
+	}
+	l, ok := normalize(v).([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a list, got %T", fn, v)
+	}
+	return l, nil
+}
+
+func copyMap(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// normalize turns typed maps and slices, such as map[string]string or
+// []string, into the map[string]any and []any shapes of decoded JSON
+func normalize(v any) any {
+	switch v.(type) {
+	case nil, map[string]any, []any, string, bool, float64, int:
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v
+		}
+		out := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			out[iter.Key().String()] = normalize(iter.Value().Interface())
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		out := make([]any, rv.Len())
+		for i := range out {
+			out[i] = normalize(rv.Index(i).Interface())
+		}
+		return out
+	}
+	return v
+}
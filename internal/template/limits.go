@@ -0,0 +1,188 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"time"
+)
+
+// Limits bounds the renders of a mock, so a template looping over a huge
+// list or calling itself fails instead of hanging or flooding the process
+type Limits struct {
+	Timeout   time.Duration // per render, 0 for none
+	MaxOutput int64         // bytes per render, 0 for any
+	MaxDepth  int           // nested templates and inline function calls
+	Allow     []string      // risky functions the templates may call, nil for all
+}
+
+// DefaultLimits apply to every mock, field by field, unless it sets its own
+var DefaultLimits = Limits{
+	Timeout:   5 * time.Second,
+	MaxOutput: 10 << 20,
+	MaxDepth:  32,
+}
+
+// Risky are the functions that reach beyond the mock's own renders, with
+// what they do. A mock setting limits.allow can only call the ones it lists.
+var Risky = map[string]string{
+	"globalStore": "reads and changes the state shared by every mock",
+	"publish":     "sends events to the other mocks",
+}
+
+// LimitError is a render stopped by the limits of its mock
+type LimitError struct {
+	Limit string // timeout, maxOutput, maxDepth or allow
+	Msg   string
+}
+
+func (e *LimitError) Error() string {
+	return e.Msg
+}
+
+// CheckAllow reports the names of a limits.allow list that are not risky
+// functions
+func CheckAllow(names []string) error {
+	for _, name := range names {
+		if _, ok := Risky[name]; !ok {
+			known := make([]string, 0, len(Risky))
+			for k := range Risky {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("⚠️ limits.allow: %q is not a risky function, expected one of %s", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// blocked replaces a risky function the mock does not allow
+func blocked(name string) func(args ...any) (string, error) {
+	return func(args ...any) (string, error) {
+		return "", &LimitError{Limit: "allow", Msg: fmt.Sprintf("%s: not allowed, add it to limits.allow", name)}
+	}
+}
+
+// The hooks are functions the set adds to its templates: enter and leave
+// around every named template, tick at the start of every range iteration
+const (
+	hookEnter = "__kuro_enter"
+	hookLeave = "__kuro_leave"
+	hookTick  = "__kuro_tick"
+)
+
+// render is the state of one Render call
+type render struct {
+	limits     Limits
+	context    map[string]any
+	deadline   time.Time
+	depth      int
+	eventDepth int // of the events the render publishes
+}
+
+func newRender(limits Limits, ctx map[string]any) *render {
+	x := &render{limits: limits, context: ctx}
+	if limits.Timeout > 0 {
+		x.deadline = time.Now().Add(limits.Timeout)
+	}
+	return x
+}
+
+// check fails once the render runs past its timeout
+func (x *render) check() error {
+	if !x.deadline.IsZero() && time.Now().After(x.deadline) {
+		return &LimitError{Limit: "timeout", Msg: fmt.Sprintf("render exceeded limits.timeout of %s", x.limits.Timeout)}
+	}
+	return nil
+}
+
+// enter counts a nested template or function call named name
+func (x *render) enter(name string) error {
+	if err := x.check(); err != nil {
+		return err
+	}
+	if x.depth >= x.limits.MaxDepth {
+		return &LimitError{Limit: "maxDepth", Msg: fmt.Sprintf("%s: more than %d nested calls (limits.maxDepth)", name, x.limits.MaxDepth)}
+	}
+	x.depth++
+	return nil
+}
+
+func (x *render) leave() {
+	x.depth--
+}
+
+// writer returns buf bounded by the render's output limit and timeout
+func (x *render) writer(buf *bytes.Buffer) *limitWriter {
+	return &limitWriter{buf: buf, x: x}
+}
+
+type limitWriter struct {
+	buf *bytes.Buffer
+	x   *render
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if err := w.x.check(); err != nil {
+		return 0, err
+	}
+	if max := w.x.limits.MaxOutput; max > 0 && int64(w.buf.Len()+len(p)) > max {
+		return 0, &LimitError{Limit: "maxOutput", Msg: fmt.Sprintf("render exceeded limits.maxOutput of %d bytes", max)}
+	}
+	return w.buf.Write(p)
+}
+
+// instrument adds the hooks to a parsed template: enter and leave around
+// its body unless calls reaches it some other way, and tick in its loops.
+// hook parses a hook call into nodes.
+func instrument(tree *parse.Tree, calls bool, hook func(call string) (parse.Node, error)) error {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+	tick, err := hook(hookTick)
+	if err != nil {
+		return err
+	}
+	addTicks(tree.Root, tick)
+	if !calls {
+		return nil
+	}
+	enter, err := hook(hookEnter + " " + strconv.Quote(tree.Name))
+	if err != nil {
+		return err
+	}
+	leave, err := hook(hookLeave)
+	if err != nil {
+		return err
+	}
+	nodes := make([]parse.Node, 0, len(tree.Root.Nodes)+2)
+	nodes = append(nodes, enter)
+	nodes = append(nodes, tree.Root.Nodes...)
+	tree.Root.Nodes = append(nodes, leave)
+	return nil
+}
+
+func addTicks(list *parse.ListNode, tick parse.Node) {
+	if list == nil {
+		return
+	}
+	for _, n := range list.Nodes {
+		switch n := n.(type) {
+		case *parse.RangeNode:
+			addTicks(n.List, tick)
+			addTicks(n.ElseList, tick)
+			n.List.Nodes = append([]parse.Node{tick}, n.List.Nodes...)
+		case *parse.IfNode:
+			addTicks(n.List, tick)
+			addTicks(n.ElseList, tick)
+		case *parse.WithNode:
+			addTicks(n.List, tick)
+			addTicks(n.ElseList, tick)
+		case *parse.ListNode:
+			addTicks(n, tick)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// mathFuncs do arithmetic on template numbers. The result is an int when
+// every operand is an integer and a float otherwise; numeric strings, such as
+// path parameters, count as numbers.
+func mathFuncs() map[string]any {
+	return map[string]any{
+		"add":          add,
+		"sub":          sub,
+		"mul":          mul,
+		"div":          div,
+		"mod":          mod,
+		"min":          minOf,
+		"max":          maxOf,
+		"abs":          abs,
+		"round":        round,
+		"floor":        floor,
+		"ceil":         ceil,
+		"toInt":        toInt,
+		"atoi":         toInt,
+		"toFloat":      toFloat,
+		"formatNumber": formatNumber,
+	}
+}
+
+// toNumber converts template numbers, including numeric strings, to float64
+// and reports whether the value is an integer
+func toNumber(v any) (float64, bool, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true, nil
+	case int8:
+		return float64(n), true, nil
+	case int16:
+		return float64(n), true, nil
+	case int32:
+		return float64(n), true, nil
+	case int64:
+		return float64(n), true, nil
+	case uint:
+		return float64(n), true, nil
+	case uint8:
+		return float64(n), true, nil
+	case uint16:
+		return float64(n), true, nil
+	case uint32:
+		return float64(n), true, nil
+	case uint64:
+		return float64(n), true, nil
+	case float64:
+		return n, false, nil
+	case float32:
+		return float64(n), false, nil
+	case json.Number:
+		return toNumber(string(n))
+	case string:
+		if i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64); err == nil {
+			return float64(i), true, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("%q is not a number", n)
+		}
+		return f, false, nil
+	default:
+		return 0, false, fmt.Errorf("%v (%T) is not a number", v, v)
+	}
+}
+
+// numbers converts the operands of fn and reports whether all are integers
+func numbers(fn string, args []any) ([]float64, bool, error) {
+	out := make([]float64, len(args))
+	allInt := true
+	for i, a := range args {
+		n, isInt, err := toNumber(a)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", fn, err)
+		}
+		out[i], allInt = n, allInt && isInt
+	}
+	return out, allInt, nil
+}
+
+// result returns n as an int when the operands were integers
+func result(n float64, isInt bool) any {
+	if isInt {
+		return int(n)
+	}
+	return n
+}
+
+func add(a any, rest ...any) (any, error) {
+	ns, isInt, err := numbers("add", append([]any{a}, rest...))
+	if err != nil {
+		return nil, err
+	}
+	sum := 0.0
+	for _, n := range ns {
+		sum += n
+	}
+	return result(sum, isInt), nil
+}
+
+func sub(a, b any) (any, error) {
+	ns, isInt, err := numbers("sub", []any{a, b})
+	if err != nil {
+		return nil, err
+	}
+	return result(ns[0]-ns[1], isInt), nil
+}
+
+func mul(a any, rest ...any) (any, error) {
+	ns, isInt, err := numbers("mul", append([]any{a}, rest...))
+	if err != nil {
+		return nil, err
+	}
+	product := 1.0
+	for _, n := range ns {
+		product *= n
+	}
+	return result(product, isInt), nil
+}
+
+// div divides a by b. Integers that do not divide evenly give a float.
+func div(a, b any) (any, error) {
+	ns, isInt, err := numbers("div", []any{a, b})
+	if err != nil {
+		return nil, err
+	}
+	if ns[1] == 0 {
+		return nil, fmt.Errorf("div: division by zero")
+	}
+	q := ns[0] / ns[1]
+	return result(q, isInt && q == math.Trunc(q)), nil
+}
+
+func mod(a, b any) (any, error) {
+	ns, isInt, err := numbers("mod", []any{a, b})
+	if err != nil {
+		return nil, err
+	}
+	if !isInt {
+		return nil, fmt.Errorf("mod: expected integers, got %v and %v", a, b)
+	}
+	if ns[1] == 0 {
+		return nil, fmt.Errorf("mod: division by zero")
+	}
+	return int(ns[0]) % int(ns[1]), nil
+}
+
+func minOf(a any, rest ...any) (any, error) {
+	ns, isInt, err := numbers("min", append([]any{a}, rest...))
+	if err != nil {
+		return nil, err
+	}
+	m := ns[0]
+	for _, n := range ns[1:] {
+		m = math.Min(m, n)
+	}
+	return result(m, isInt), nil
+}
+
+func maxOf(a any, rest ...any) (any, error) {
+	ns, isInt, err := numbers("max", append([]any{a}, rest...))
+	if err != nil {
+		return nil, err
+	}
+	m := ns[0]
+	for _, n := range ns[1:] {
+		m = math.Max(m, n)
+	}
+	return result(m, isInt), nil
+}
+
+func abs(a any) (any, error) {
+	n, isInt, err := toNumber(a)
+	if err != nil {
+		return nil, fmt.Errorf("abs: %w", err)
+	}
+	return result(math.Abs(n), isInt), nil
+}
+
+// round rounds half away from zero, to an integer or to the given number of
+// decimals
+func round(a any, decimals ...int) (any, error) {
+	n, _, err := toNumber(a)
+	if err != nil {
+		return nil, fmt.Errorf("round: %w", err)
+	}
+	if len(decimals) == 0 || decimals[0] == 0 {
+		return int(math.Round(n)), nil
+	}
+	p := math.Pow10(decimals[0])
+	return math.Round(n*p) / p, nil
+}
+
+func floor(a any) (int, error) {
+	n, _, err := toNumber(a)
+	if err != nil {
+		return 0, fmt.Errorf("floor: %w", err)
+	}
+	return int(math.Floor(n)), nil
+}
+
+func ceil(a any) (int, error) {
+	n, _, err := toNumber(a)
+	if err != nil {
+		return 0, fmt.Errorf("ceil: %w", err)
+	}
+	return int(math.Ceil(n)), nil
+}
+
+// toInt converts a number or numeric string, truncating decimals
+func toInt(a any) (int, error) {
+	n, _, err := toNumber(a)
+	if err != nil {
+		return 0, fmt.Errorf("toInt: %w", err)
+	}
+	return int(n), nil
+}
+
+func toFloat(a any) (float64, error) {
+	n, _, err := toNumber(a)
+	if err != nil {
+		return 0, fmt.Errorf("toFloat: %w", err)
+	}
+	return n, nil
+}
+
+// formatNumber writes a number with the given decimals and comma thousands
+// separators, e.g. 1234.5 | formatNumber 2 gives 1,234.50
+func formatNumber(decimals int, a any) (string, error) {
+	n, _, err := toNumber(a)
+	if err != nil {
+		return "", fmt.Errorf("formatNumber: %w", err)
+	}
+	if decimals < 0 {
+		return "", fmt.Errorf("formatNumber: negative decimals %d", decimals)
+	}
+	s := strconv.FormatFloat(math.Abs(n), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	if n < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	if frac != "" {
+		b.WriteString("." + frac)
+	}
+	return b.String(), nil
+}
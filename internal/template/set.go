@@ -0,0 +1,242 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/usekuro/usekuro/internal/diagnostics"
+	"github.com/usekuro/usekuro/internal/extensions"
+)
+
+// Set holds the compiled templates of a mock: its .kurof extensions, its
+// inline functions and every template rendered with it, each parsed once.
+// The runtimes of all requests share it, so a request only executes its
+// templates.
+type Set struct {
+	registry *extensions.Registry
+	base     *template.Template
+	limits   Limits
+	source   *diagnostics.Source
+	fields   map[string]string // inline function template -> its field
+	pool     sync.Pool         // *binding
+
+	// publish sends the events of renders, nil when limits.allow leaves it out
+	publish func(depth int, name string, data ...any) (string, error)
+
+	mu           sync.RWMutex
+	gen          int               // templates compiled, so bindings know when they are stale
+	names        map[string]string // name and text -> name of its compiled template
+	used         map[string]int    // render name -> templates compiled under it
+	instrumented map[*parse.Tree]bool
+	entries      map[string]bool // templates renders and inline functions start from
+}
+
+// binding is a clone of the set's templates whose inline functions and
+// hooks serve one render at a time. Renders take one from the set's pool and
+// put it back when they end.
+type binding struct {
+	templates *template.Template
+	gen       int
+	cur       *render
+}
+
+// NewSet compiles the extensions and inline functions of registry with the
+// functions and limits of env, or of a fresh environment when env is nil
+func NewSet(registry *extensions.Registry, env *Env) (*Set, error) {
+	if env == nil {
+		env = NewEnv(nil)
+	}
+	if registry == nil {
+		registry = extensions.NewRegistry()
+	}
+	s := &Set{
+		registry:     registry,
+		limits:       env.Limits,
+		source:       env.Source,
+		fields:       make(map[string]string),
+		names:        make(map[string]string),
+		used:         make(map[string]int),
+		instrumented: make(map[*parse.Tree]bool),
+		entries:      make(map[string]bool),
+	}
+
+	funcs := env.FuncMap()
+	s.publish = env.publish
+	if s.limits.Allow != nil {
+		allowed := make(map[string]bool, len(s.limits.Allow))
+		for _, name := range s.limits.Allow {
+			allowed[name] = true
+		}
+		for name := range Risky {
+			if !allowed[name] {
+				funcs[name] = blocked(name)
+			}
+		}
+		if !allowed["publish"] {
+			s.publish = nil
+		}
+	}
+	for _, name := range []string{hookEnter, hookLeave, hookTick} {
+		funcs[name] = unbound(name)
+	}
+	for name, fn := range registry.Functions {
+		if _, taken := funcs[name]; taken || builtins[name] {
+			return nil, fmt.Errorf("⚠️ functions.%s: %s is a built-in function", name, name)
+		}
+		funcs[name] = unbound(fn.Name)
+		s.entries[functionTemplate(fn)] = true
+		s.fields[functionTemplate(fn)] = "functions." + fn.Key
+	}
+	t := template.New("base").Funcs(funcs)
+
+	// Cargar extensiones kurof si existen
+	for _, ext := range registry.Extensions {
+		if _, err := t.New(ext.Name).Parse(ext.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, fn := range registry.Functions {
+		if _, err := t.New(functionTemplate(fn)).Parse(fn.Body); err != nil {
+			return nil, s.locate("functions."+fn.Key, fmt.Errorf("⚠️ functions.%s: %w", name, err))
+		}
+	}
+	for name, fn := range registry.Functions {
+		tmpl := t.Lookup(functionTemplate(fn))
+		if tmpl != nil && tmpl.Tree != nil && !parse.IsEmptyTree(tmpl.Tree.Root) {
+			continue
+		}
+		if fn.Legacy() {
+			return nil, fmt.Errorf("⚠️ functions.%s: the body must define template %q", name, name)
+		}
+		return nil, fmt.Errorf("⚠️ functions.%s: empty body", name)
+	}
+
+	s.base = t
+	if err := s.instrument(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// unbound stands for an inline function or hook in the set's own
+// templates, which only renders execute, through a binding
+func unbound(name string) func(args ...any) (string, error) {
+	return func(args ...any) (string, error) {
+		return "", fmt.Errorf("%s: called outside a render", name)
+	}
+}
+
+// instrument adds the limit hooks to the templates not instrumented yet.
+// The caller holds the lock or owns the set.
+func (s *Set) instrument() error {
+	for _, tmpl := range s.base.Templates() {
+		if tmpl.Tree == nil || s.instrumented[tmpl.Tree] {
+			continue
+		}
+		if err := instrument(tmpl.Tree, !s.entries[tmpl.Name()], hookNode); err != nil {
+			return err
+		}
+		s.instrumented[tmpl.Tree] = true
+	}
+	return nil
+}
+
+// hookNode parses a hook call into the node added to templates
+func hookNode(call string) (parse.Node, error) {
+	trees, err := parse.Parse("hook", "{{ "+call+" }}", "", "", map[string]any{
+		hookEnter: true, hookLeave: true, hookTick: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trees["hook"].Root.Nodes[0], nil
+}
+
+// Compile parses raw, unless the set already holds it under name, and
+// returns the name of its template. The template is named name when that is
+// free, so errors point at it, and name#N otherwise. Renders name their
+// templates after the mock field they come from, e.g.
+// routes[3].response.body, and parse errors come as a
+// *diagnostics.Diagnostic placing them in the mock.
+func (s *Set) Compile(name, raw string) (string, error) {
+	key := name + "\x00" + raw
+	s.mu.RLock()
+	tname, ok := s.names[key]
+	s.mu.RUnlock()
+	if ok {
+		return tname, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tname, ok := s.names[key]; ok {
+		return tname, nil
+	}
+	tname = name
+	if n := s.used[name]; n > 0 || s.base.Lookup(name) != nil {
+		tname = fmt.Sprintf("%s#%d", name, n+1)
+	}
+	if strings.HasPrefix(tname, "__kuro") {
+		return "", fmt.Errorf("template: %s: reserved name", tname)
+	}
+	if _, err := s.base.New(tname).Parse(raw); err != nil {
+		return "", s.locate(name, err)
+	}
+	s.entries[tname] = true
+	if err := s.instrument(); err != nil {
+		return "", err
+	}
+	s.used[name]++
+	s.names[key] = tname
+	s.gen++
+	return tname, nil
+}
+
+// locate places an error of the template of field in the mock
+func (s *Set) locate(field string, err error) *diagnostics.Diagnostic {
+	return s.source.Locate(field, err, s.fields)
+}
+
+// Runtime returns a runtime rendering the set's templates with ctx
+func (s *Set) Runtime(ctx map[string]any) (*Runtime, error) {
+	return &Runtime{set: s, context: ctx}, nil
+}
+
+// acquire returns a binding holding every template compiled so far
+func (s *Set) acquire() (*binding, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b, ok := s.pool.Get().(*binding); ok && b.gen == s.gen {
+		return b, nil
+	}
+	t, err := s.base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	b := &binding{gen: s.gen}
+	funcs := template.FuncMap{
+		hookEnter: func(name string) (string, error) { return "", b.cur.enter(fmt.Sprintf("template %q", name)) },
+		hookLeave: func() string { b.cur.leave(); return "" },
+		hookTick:  func() (string, error) { return "", b.cur.check() },
+	}
+	if s.publish != nil {
+		// events published while handling one carry its depth
+		funcs["publish"] = func(name string, data ...any) (string, error) {
+			return s.publish(b.cur.eventDepth, name, data...)
+		}
+	}
+	for name, fn := range s.registry.Functions {
+		funcs[name] = b.function(fn)
+	}
+	b.templates = t.Funcs(funcs)
+	return b, nil
+}
+
+func (s *Set) release(b *binding) {
+	b.cur = nil
+	s.pool.Put(b)
+}
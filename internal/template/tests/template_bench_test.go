@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// benchRegistry is a mock with a prelude-sized extension and a couple of
+// inline functions, the case where parsing dominated every request
+func benchRegistry(b *testing.B) *extensions.Registry {
+	b.Helper()
+	registry := extensions.NewRegistry()
+	if err := registry.RegisterFunctions(map[string]string{
+		"price(amount)": `{{ formatNumber 2 .amount }} {{ .context.currency }}`,
+		"greet(name)":   `Hello {{ .name | title }}`,
+	}); err != nil {
+		b.Fatal(err)
+	}
+	registry.Register("helpers.kurof", `
+{{ define "stars" }}***{{ end }}
+{{ define "user" }}{"id":"{{ uuid }}","name":"{{ .input.name }}","at":"{{ now }}"}{{ end }}
+{{ define "list" }}[{{ range $i, $x := .input.items }}{{ if $i }},{{ end }}{{ toJSON $x }}{{ end }}]{{ end }}
+`, "helpers.kurof")
+	return registry
+}
+
+const benchBody = `{"user":{{ template "user" . }},"items":{{ template "list" . }},"total":"{{ price .input.total }}","greeting":"{{ greet .input.name }}"}`
+
+func benchContext() map[string]any {
+	return template.MergeContext(map[string]any{
+		"name":  "ana",
+		"total": 1234.5,
+		"items": []any{"a", "b", "c"},
+	}, nil, map[string]any{"currency": "EUR"})
+}
+
+func runBench(b *testing.B, runtime func() (*template.Runtime, error)) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, err := runtime()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := r.Render("hdr", `application/json`); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := r.Render("body", benchBody); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRenderUncompiled parses everything per request, as a runtime
+// without a mock environment does
+func BenchmarkRenderUncompiled(b *testing.B) {
+	registry := benchRegistry(b)
+	runBench(b, func() (*template.Runtime, error) {
+		return template.NewRuntime(benchContext(), registry)
+	})
+}
+
+// BenchmarkRenderCompiled renders with the mock's compiled set, as handlers do
+func BenchmarkRenderCompiled(b *testing.B) {
+	registry := benchRegistry(b)
+	env := template.NewEnv(nil)
+	runBench(b, func() (*template.Runtime, error) {
+		return template.NewRuntimeEnv(benchContext(), registry, env)
+	})
+}
+
+// BenchmarkRenderCompiledNoFunctions skips the per-request clone inline
+// functions need
+func BenchmarkRenderCompiledNoFunctions(b *testing.B) {
+	registry := benchRegistry(b)
+	registry.Functions = map[string]extensions.Function{}
+	registry.Register("helpers.kurof", `{{ define "user" }}{"name":"{{ .input.name }}"}{{ end }}{{ define "list" }}[]{{ end }}`, "helpers.kurof")
+	env := template.NewEnv(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, err := template.NewRuntimeEnv(benchContext(), registry, env)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := r.Render("body", `{"user":{{ template "user" . }},"items":{{ template "list" . }}}`); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderCompiledParallel(b *testing.B) {
+	registry := benchRegistry(b)
+	env := template.NewEnv(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r, err := template.NewRuntimeEnv(benchContext(), registry, env)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := r.Render("body", benchBody); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func TestDateFunctions(t *testing.T) {
+	env := template.NewEnv(nil)
+	env.Clock.Freeze(time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC))
+
+	for raw, want := range map[string]string{
+		`{{ now | date "2006-01-02" }}`:                    "2024-01-15",
+		`{{ date "DateTime" }}`:                            "2024-01-15 10:30:00",
+		`{{ date "RFC1123" "2024-02-29T08:00:00+01:00" }}`: "Thu, 29 Feb 2024 08:00:00 +0100",
+		`{{ now | dateAdd "36h" }}`:                        "2024-01-16T22:30:00Z",
+		`{{ now | dateAdd "-2w" | date "DateOnly" }}`:      "2024-01-01",
+		`{{ dateAdd "7d" "2024-01-15" }}`:                  "2024-01-22T00:00:00Z",
+		`{{ unix now }}`:                                   "1705314600",
+		`{{ unix }}`:                                       "1705314600",
+		`{{ unixMilli "2024-01-15 10:30:00" }}`:            "1705314600000",
+		`{{ fromUnix 1705314600 }}`:                        "2024-01-15T10:30:00Z",
+		`{{ date "Kitchen" 1705314600 }}`:                  "10:30AM",
+		`{{ parseDate "02/01/2006" "15/01/2024" }}`:        "2024-01-15T00:00:00Z",
+		`{{ dateDiff "2024-01-15" now }}`:                  "37800",
+		`{{ mod (unix now) 1000 }}`:                        "600",
+	} {
+		assert.Equal(t, want, renderEnv(t, env, raw), raw)
+	}
+}
+
+func TestDateErrors(t *testing.T) {
+	env := template.NewEnv(nil)
+	for raw, msg := range map[string]string{
+		`{{ date "2006" "yesterday" }}`:    `date: "yesterday" is not a time`,
+		`{{ dateAdd "1 day" }}`:            `dateAdd: invalid duration "1 day"`,
+		`{{ parseDate "2006-01-02" "x" }}`: "parseDate:",
+		`{{ unix .input }}`:                "unix: map[] (map[string]interface {}) is not a time",
+	} {
+		r, err := template.NewRuntimeEnv(template.MergeContext(nil, nil, nil), extensions.NewRegistry(), env)
+		require.NoError(t, err)
+		_, err = r.Render("err", raw)
+		require.Error(t, err, raw)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
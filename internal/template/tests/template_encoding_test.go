@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func TestEncodingFunctions(t *testing.T) {
+	input := map[string]any{"msg": "The quick brown fox jumps over the lazy dog"}
+
+	for raw, want := range map[string]string{
+		`{{ base64Encode "user:pass" }}`:         "dXNlcjpwYXNz",
+		`{{ base64Decode "dXNlcjpwYXNz" }}`:      "user:pass",
+		`{{ base64Decode "dXNlcjpwYXNzMQ" }}`:    "user:pass1",
+		`{{ hexEncode "kuro" }}`:                 "6b75726f",
+		`{{ hexDecode "6b75726f" }}`:             "kuro",
+		`{{ urlEncode "a b&c=d" }}`:              "a+b%26c%3Dd",
+		`{{ urlDecode "a+b%26c%3Dd" }}`:          "a b&c=d",
+		`{{ md5 "" }}`:                           "d41d8cd98f00b204e9800998ecf8427e",
+		`{{ sha1 "" }}`:                          "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		`{{ sha256 "" }}`:                        "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		`{{ .input.msg | hmac "sha256" "key" }}`: "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+	} {
+		assert.Equal(t, want, renderWith(t, input, nil, raw), raw)
+	}
+}
+
+func TestEncodingErrors(t *testing.T) {
+	r, err := template.NewRuntime(template.MergeContext(nil, nil, nil), extensions.NewRegistry())
+	require.NoError(t, err)
+
+	for raw, msg := range map[string]string{
+		`{{ base64Decode "%%%" }}`:  "base64Decode: invalid base64 input",
+		`{{ hexDecode "zz" }}`:      "hexDecode:",
+		`{{ urlDecode "%zz" }}`:     "urlDecode:",
+		`{{ hmac "sha3" "k" "m" }}`: `hmac: unknown algorithm "sha3"`,
+		`{{ sha256 .input }}`:       "sha256: expected text",
+	} {
+		_, err := r.Render("err", raw)
+		require.Error(t, err, raw)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+const randomBody = `{{ uuid }} {{ random 1 1000 }} {{ fakeName }} {{ fakeIBAN }}`
+
+func renderEnv(t *testing.T, env *template.Env, raw string) string {
+	t.Helper()
+	r, err := template.NewRuntimeEnv(template.MergeContext(nil, nil, nil), extensions.NewRegistry(), env)
+	require.NoError(t, err)
+	out, err := r.Render("test", raw)
+	require.NoError(t, err)
+	return out
+}
+
+func TestSeededEnv(t *testing.T) {
+	seed := int64(42)
+	a, b := template.NewEnv(&seed), template.NewEnv(&seed)
+
+	first := renderEnv(t, a, randomBody)
+	assert.Equal(t, first, renderEnv(t, b, randomBody))
+
+	// Later renders continue the sequence
+	second := renderEnv(t, a, randomBody)
+	assert.NotEqual(t, first, second)
+	assert.Regexp(t, `^[0-9a-f-]{36} `, second)
+
+	a.Reseed(nil)
+	assert.Equal(t, first, renderEnv(t, a, randomBody))
+
+	other := int64(7)
+	a.Reseed(&other)
+	got, ok := a.Seed()
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), got)
+	assert.NotEqual(t, first, renderEnv(t, a, randomBody))
+
+	_, ok = template.NewEnv(nil).Seed()
+	assert.False(t, ok)
+	assert.NotEqual(t, renderEnv(t, template.NewEnv(nil), randomBody), renderEnv(t, template.NewEnv(nil), randomBody))
+}
+
+func TestVirtualClock(t *testing.T) {
+	env := template.NewEnv(nil)
+	at := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)
+
+	env.Clock.Freeze(at)
+	assert.Equal(t, "2024-02-29T12:00:00Z", renderEnv(t, env, `{{ now }}`))
+	assert.True(t, env.Clock.State().Frozen)
+
+	env.Clock.Advance(36 * time.Hour)
+	assert.Equal(t, "2024-03-02T00:00:00Z", renderEnv(t, env, `{{ now }}`))
+
+	env.Clock.Resume()
+	assert.False(t, env.Clock.State().Frozen)
+	assert.WithinDuration(t, at.Add(36*time.Hour), env.Clock.Now(), time.Second)
+
+	env.Clock.SetOffset(-24 * time.Hour)
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), env.Clock.Now(), time.Second)
+	env.Clock.Advance(time.Hour)
+	assert.Equal(t, "-23h0m0s", env.Clock.State().Offset)
+
+	env.Clock.Freeze(time.Time{})
+	frozen := env.Clock.Now()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, frozen, env.Clock.Now())
+
+	env.Clock.Reset()
+	assert.WithinDuration(t, time.Now(), env.Clock.Now(), time.Second)
+}
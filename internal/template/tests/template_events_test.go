@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/events"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func TestPublishFunction(t *testing.T) {
+	env := template.NewEnv(nil)
+	env.Events = events.NewBus()
+	env.Mock = "orders-api"
+
+	got := make(chan events.Event, 2)
+	defer env.Events.Subscribe("orders.*", func(e events.Event) { got <- e })()
+
+	assert.Equal(t, "ok", renderEnv(t, env, `{{ dict "id" 7 | publish "orders.created" }}ok`))
+	assert.Equal(t, "", renderEnv(t, env, `{{ publish "orders.ping" }}`))
+
+	for _, want := range []string{"orders.created", "orders.ping"} {
+		select {
+		case e := <-got:
+			assert.Equal(t, want, e.Name)
+			assert.Equal(t, "orders-api", e.Source)
+		case <-time.After(time.Second):
+			t.Fatalf("%s not delivered", want)
+		}
+	}
+
+	r, err := template.NewRuntimeEnv(template.MergeContext(nil, nil, nil), extensions.NewRegistry(), env)
+	require.NoError(t, err)
+	_, err = r.Render("err", `{{ publish "" }}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "publish: empty event name")
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"encoding/json"
+	"math/big"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func render(t *testing.T, raw string) string {
+	t.Helper()
+	r, err := template.NewRuntime(template.MergeContext(nil, nil, nil), extensions.NewRegistry())
+	require.NoError(t, err)
+	out, err := r.Render("test", raw)
+	require.NoError(t, err, raw)
+	return out
+}
+
+func luhnValid(number string) bool {
+	sum := 0
+	for i := range number {
+		d := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func ibanValid(iban string) bool {
+	rearranged := iban[4:] + iban[:4]
+	var digits strings.Builder
+	for _, r := range rearranged {
+		if r >= 'A' && r <= 'Z' {
+			digits.WriteString(strconv.Itoa(int(r-'A') + 10))
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func TestFakerPeople(t *testing.T) {
+	name := render(t, `{{ fakeName }}`)
+	assert.Len(t, strings.Fields(name), 2)
+
+	assert.Regexp(t, `^[a-z0-9._]+@[a-z.]+$`, render(t, `{{ fakeEmail }}`))
+	assert.Regexp(t, `^[a-z0-9._]+@[a-z.]+$`, render(t, `{{ fakeEmail "de" }}`))
+	assert.Regexp(t, `^\+1 \(\d{3}\) \d{3}-\d{4}$`, render(t, `{{ fakePhone }}`))
+	assert.Regexp(t, `^\+34 6\d{2} \d{3} \d{3}$`, render(t, `{{ fakePhone "es" }}`))
+	assert.Equal(t, "España", render(t, `{{ fakeCountry "es" }}`))
+	assert.Regexp(t, `^.+, \d{5} .+, France$`, render(t, `{{ fakeAddress "fr" }}`))
+	assert.Regexp(t, `(GmbH|AG|KG)`, render(t, `{{ fakeCompany "de" }}`))
+}
+
+func TestFakerFinance(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		card := render(t, `{{ fakeCreditCard }}`)
+		assert.Regexp(t, `^4\d{15}$`, card)
+		assert.True(t, luhnValid(card), card)
+
+		amex := render(t, `{{ fakeCreditCard "amex" }}`)
+		assert.Regexp(t, `^3[47]\d{13}$`, amex)
+		assert.True(t, luhnValid(amex), amex)
+	}
+
+	for locale, pattern := range map[string]string{
+		"en": `^GB\d{2}[A-Z]{4}\d{14}$`,
+		"es": `^ES\d{22}$`,
+		"fr": `^FR\d{25}$`,
+		"de": `^DE\d{20}$`,
+	} {
+		for i := 0; i < 20; i++ {
+			iban := render(t, `{{ fakeIBAN "`+locale+`" }}`)
+			assert.Regexp(t, pattern, iban)
+			assert.True(t, ibanValid(iban), iban)
+		}
+	}
+}
+
+func TestFakerInternet(t *testing.T) {
+	ip := net.ParseIP(render(t, `{{ fakeIPv4 }}`))
+	require.NotNil(t, ip)
+	assert.NotNil(t, ip.To4())
+
+	ip = net.ParseIP(render(t, `{{ fakeIPv6 }}`))
+	require.NotNil(t, ip)
+	assert.Nil(t, ip.To4())
+
+	assert.NotEmpty(t, render(t, `{{ fakeUserAgent }}`))
+}
+
+func TestFakerText(t *testing.T) {
+	assert.Len(t, strings.Fields(render(t, `{{ fakeLorem 7 }}`)), 7)
+	assert.Regexp(t, `^[A-Z][a-z ]+\.$`, render(t, `{{ fakeSentence }}`))
+	assert.Len(t, regexp.MustCompile(`\.`).FindAllString(render(t, `{{ fakeParagraph 4 }}`), -1), 4)
+}
+
+func TestRandomItemAndRepeat(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		n, err := strconv.Atoi(render(t, `{{ random 1 6 }}`))
+		require.NoError(t, err)
+		assert.True(t, n >= 1 && n <= 6, n)
+
+		f, err := strconv.ParseFloat(render(t, `{{ random 18.0 25.0 }}`), 64)
+		require.NoError(t, err)
+		assert.True(t, f >= 18 && f <= 25, f)
+	}
+
+	ctx := template.MergeContext(nil, nil, map[string]any{"plans": []any{"free", "pro"}})
+	r, err := template.NewRuntime(ctx, extensions.NewRegistry())
+	require.NoError(t, err)
+	out, err := r.Render("randomItem", `{{ randomItem .context.plans }}`)
+	require.NoError(t, err)
+	assert.Contains(t, []string{"free", "pro"}, out)
+
+	out = render(t, `[{{ range $i := repeat 3 }}{{ if $i }},{{ end }}{"id": {{ $i }}, "name": "{{ fakeName "es" }}"}{{ end }}]`)
+	var users []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out), &users), out)
+	require.Len(t, users, 3)
+	assert.Equal(t, float64(2), users[2]["id"])
+}
+
+func TestFakerErrors(t *testing.T) {
+	r, err := template.NewRuntime(template.MergeContext(nil, nil, nil), extensions.NewRegistry())
+	require.NoError(t, err)
+
+	for _, raw := range []string{
+		`{{ fakeName "xx" }}`,
+		`{{ fakeCreditCard "diners" }}`,
+		`{{ random 5 1 }}`,
+		`{{ random "a" 1 }}`,
+		`{{ repeat -1 }}`,
+		`{{ randomItem "abc" }}`,
+	} {
+		_, err := r.Render("err", raw)
+		assert.Error(t, err, raw)
+	}
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func functionRuntime(t *testing.T, defs map[string]string, kurof string) *template.Runtime {
+	t.Helper()
+	registry := extensions.NewRegistry()
+	require.NoError(t, registry.RegisterFunctions(defs))
+	if kurof != "" {
+		registry.Register("helpers.kurof", kurof, "helpers.kurof")
+	}
+	ctx := template.MergeContext(map[string]any{"name": "ana", "total": 1234.5}, nil, map[string]any{"currency": "EUR"})
+	r, err := template.NewRuntime(ctx, registry)
+	require.NoError(t, err)
+	return r
+}
+
+func TestInlineFunctions(t *testing.T) {
+	r := functionRuntime(t, map[string]string{
+		"greet(name, title)": "{{/* Greets a user */}}\nHello {{ .title }} {{ .name | title }}\n",
+		"price(amount)":      `{{ formatNumber 2 .amount }} {{ .context.currency }}`,
+		"initials":           `{{ range .args }}{{ slice . 0 1 | upper }}{{ end }}`,
+		"banner(text)":       `{{ template "stars" }} {{ .text }} {{ template "stars" }}`,
+		"greeting":           `{{ define "greeting" }}Hi {{ .input.name }}{{ end }}`,
+	}, `{{ define "stars" }}***{{ end }}{{ define "receipt" }}Total: {{ price .input.total }}{{ end }}`)
+
+	for raw, want := range map[string]string{
+		`{{ greet .input.name "Dr." }}`:                           "Hello Dr. Ana",
+		`{{ greet "bob" "Mr." | upper }}`:                         "HELLO MR. BOB",
+		`{{ price .input.total }}`:                                "1,234.50 EUR",
+		`{{ initials "usekuro" "mock" }}`:                         "UM",
+		`{{ banner (greet "eve" "Ms.") }}`:                        "*** Hello Ms. Eve ***",
+		`{{ template "receipt" . }}`:                              "Total: 1,234.50 EUR",
+		`{{ greeting }} / {{ template "greeting" . }}`:            "Hi ana / Hi ana",
+		`{{ $p := price 2 }}{{ if eq $p "2.00 EUR" }}ok{{ end }}`: "ok",
+	} {
+		out, err := r.Render("test", raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, want, out, raw)
+	}
+}
+
+func TestInlineFunctionErrors(t *testing.T) {
+	r := functionRuntime(t, map[string]string{
+		"greet(name)": `Hello {{ .name }}`,
+		"loop(n)":     `{{ loop .n }}`,
+	}, "")
+
+	_, err := r.Render("arity", `{{ greet "a" "b" }}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "greet(name): expected 1 arguments, got 2")
+
+	_, err = r.Render("loop", `{{ loop 1 }}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "loop: more than 32 nested calls")
+
+	for defs, msg := range map[string]string{
+		`{"upper": "x"}`:                        "functions.upper: upper is a built-in function",
+		`{"len(x)": "x"}`:                       "functions.len: len is a built-in function",
+		`{"bad name": "x"}`:                     "invalid name",
+		`{"f(input)": "x"}`:                     `parameter "input" would hide .input`,
+		`{"f(a, a)": "x"}`:                      `duplicate parameter "a"`,
+		`{"f": "{{ .x "}`:                       "functions.f: template: func.f:1: unclosed action",
+		`{"f": "{{ nope }}"}`:                   `function "nope" not defined`,
+		`{"f": "  "}`:                           "functions.f: empty body",
+		`{"f": "{{/* only a doc */}}"}`:         "functions.f: empty body",
+		`{"f": "{{ define \"g\" }}x{{ end }}"}`: `the body must define template "f"`,
+	} {
+		_, err := template.CheckFunctions(decodeDefs(t, defs))
+		require.Error(t, err, defs)
+		assert.Contains(t, err.Error(), msg, defs)
+	}
+}
+
+func TestFunctionSignatures(t *testing.T) {
+	reg, err := template.CheckFunctions(map[string]string{
+		"greet(name, title)": "{{/*\n  Greets a user\n  politely */}}Hi",
+		"now2":               "{{ now }}",
+	})
+	require.NoError(t, err)
+	fns := reg.FunctionList()
+	require.Len(t, fns, 2)
+	assert.Equal(t, "greet(name, title)", fns[0].Signature())
+	assert.Equal(t, "Greets a user politely", fns[0].Doc)
+	assert.Equal(t, "now2(args...)", fns[1].Signature())
+}
+
+func decodeDefs(t *testing.T, raw string) map[string]string {
+	t.Helper()
+	var defs map[string]string
+	require.NoError(t, json.Unmarshal([]byte(raw), &defs))
+	return defs
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func renderWith(t *testing.T, input, global map[string]any, raw string) string {
+	t.Helper()
+	r, err := template.NewRuntime(template.MergeContext(input, nil, global), extensions.NewRegistry())
+	require.NoError(t, err)
+	out, err := r.Render("test", raw)
+	require.NoError(t, err, raw)
+	return out
+}
+
+func users() map[string]any {
+	return map[string]any{
+		"users": []any{
+			map[string]any{"id": float64(1), "name": "admin", "role": "admin", "active": true, "address": map[string]any{"city": "Lima"}},
+			map[string]any{"id": float64(2), "name": "john", "role": "user", "active": true, "address": map[string]any{"city": "Quito"}},
+			map[string]any{"id": float64(3), "name": "jane", "role": "user", "active": false, "address": map[string]any{"city": "Lima"}},
+		},
+	}
+}
+
+func TestJSONParseAndQuery(t *testing.T) {
+	input := map[string]any{"payload": `{"order": {"id": 7, "items": [{"sku": "A"}, {"sku": "B"}]}}`}
+
+	assert.Equal(t, "7", renderWith(t, input, nil, `{{ (fromJSON .input.payload).order.id }}`))
+	assert.Equal(t, "B", renderWith(t, input, nil, `{{ query (fromJSON .input.payload) "$.order.items[-1].sku" }}`))
+	assert.Equal(t, `["A","B"]`, renderWith(t, input, nil, `{{ query (fromJSON .input.payload) ".order.items[*].sku" | toJSON }}`))
+	assert.Equal(t, "jane", renderWith(t, nil, users(), `{{ query .context "users[2].name" }}`))
+	assert.Equal(t, "<no value>", renderWith(t, nil, users(), `{{ query .context "users[9].name" }}`))
+}
+
+func TestJSONMaps(t *testing.T) {
+	ctx := users()
+
+	assert.Equal(t, `{"id":1,"name":"admin"}`,
+		renderWith(t, nil, ctx, `{{ pick (index .context.users 0) "id" "name" | toJSON }}`))
+	assert.Equal(t, `{"id":1,"name":"admin"}`,
+		renderWith(t, nil, ctx, `{{ omit (index .context.users 0) "role" "active" "address" | toJSON }}`))
+	assert.Equal(t, `{"id":1,"name":"root"}`,
+		renderWith(t, nil, ctx, `{{ set (pick (index .context.users 0) "id") "name" "root" | toJSON }}`))
+	assert.Equal(t, `{"a":1,"b":{"x":1,"y":3},"c":true}`,
+		renderWith(t, nil, nil, `{{ merge (dict "a" 1 "b" (dict "x" 1 "y" 2)) (dict "b" (dict "y" 3) "c" true) | toJSON }}`))
+
+	// The context itself is left untouched
+	renderWith(t, nil, ctx, `{{ set (index .context.users 0) "name" "root" }}{{ omit (index .context.users 0) "id" }}`)
+	first := ctx["users"].([]any)[0].(map[string]any)
+	assert.Equal(t, "admin", first["name"])
+	assert.Equal(t, float64(1), first["id"])
+}
+
+func TestJSONLists(t *testing.T) {
+	ctx := users()
+
+	assert.Equal(t, `{"active":true,"address":{"city":"Quito"},"id":2,"name":"john","role":"user"}`,
+		renderWith(t, map[string]any{"id": "2"}, ctx, `{{ find .context.users "id" .input.id | toJSON }}`))
+	assert.Equal(t, "null", renderWith(t, nil, ctx, `{{ find .context.users "id" 9 | toJSON }}`))
+
+	assert.Equal(t, `["john","jane"]`, renderWith(t, nil, ctx, `{{ map (filter .context.users "role" "user") "name" | toJSON }}`))
+	assert.Equal(t, `["admin","john"]`, renderWith(t, nil, ctx, `{{ map (filter .context.users "active") "name" | toJSON }}`))
+	assert.Equal(t, `[1,3]`, renderWith(t, nil, ctx, `{{ map (filter .context.users "address.city" "Lima") "id" | toJSON }}`))
+
+	assert.Equal(t, `["jane","john","admin"]`, renderWith(t, nil, ctx, `{{ map (sort .context.users "-id") "name" | toJSON }}`))
+	assert.Equal(t, `["admin","jane","john"]`, renderWith(t, nil, ctx, `{{ map (sort .context.users "name") "name" | toJSON }}`))
+	assert.Equal(t, `[1,2,10]`, renderWith(t, nil, nil, `{{ sort (list 10 2 1) | toJSON }}`))
+	assert.Equal(t, `["a","b"]`, renderWith(t, map[string]any{"tags": []string{"b", "a"}}, nil, `{{ sort .input.tags | toJSON }}`))
+}
+
+func TestJSONEncoding(t *testing.T) {
+	assert.Equal(t, "{\n  \"a\": [\n    1,\n    2\n  ]\n}", renderWith(t, nil, nil, `{{ dict "a" (list 1 2) | toPrettyJSON }}`))
+	assert.Equal(t, "name: kuro\ntags:\n  - a\n  - b", renderWith(t, nil, nil, `{{ dict "name" "kuro" "tags" (list "a" "b") | toYAML }}`))
+}
+
+func TestJSONErrors(t *testing.T) {
+	r, err := template.NewRuntime(template.MergeContext(nil, nil, users()), extensions.NewRegistry())
+	require.NoError(t, err)
+
+	for _, raw := range []string{
+		`{{ fromJSON "{" }}`,
+		`{{ fromJSON 1 }}`,
+		`{{ query .context "users[" }}`,
+		`{{ dict "a" }}`,
+		`{{ dict 1 2 }}`,
+		`{{ pick "text" "a" }}`,
+		`{{ filter .context "role" "user" }}`,
+		`{{ sort .context.users "a" "b" }}`,
+	} {
+		_, err := r.Render("err", raw)
+		assert.Error(t, err, raw)
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func limitedRuntime(t *testing.T, limits template.Limits, kurof string) *template.Runtime {
+	t.Helper()
+	env := template.NewEnv(nil)
+	env.Limits = limits
+	registry := extensions.NewRegistry()
+	if kurof != "" {
+		registry.Register("helpers.kurof", kurof, "helpers.kurof")
+	}
+	r, err := template.NewRuntimeEnv(template.MergeContext(map[string]any{"n": 5}, nil, nil), registry, env)
+	require.NoError(t, err)
+	return r
+}
+
+func requireLimit(t *testing.T, err error, limit, msg string) {
+	t.Helper()
+	require.Error(t, err)
+	var le *template.LimitError
+	require.ErrorAs(t, err, &le)
+	assert.Equal(t, limit, le.Limit)
+	assert.Contains(t, err.Error(), msg)
+}
+
+func TestRenderTimeout(t *testing.T) {
+	r := limitedRuntime(t, template.Limits{Timeout: 50 * time.Millisecond, MaxDepth: 32}, "")
+
+	start := time.Now()
+	_, err := r.Render("loop", `{{ range 1000000000 }}{{ end }}`)
+	requireLimit(t, err, "timeout", "render exceeded limits.timeout of 50ms")
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	out, err := r.Render("ok", `{{ range 3 }}.{{ end }}`)
+	require.NoError(t, err, "the next render starts a new timeout")
+	assert.Equal(t, "...", out)
+}
+
+func TestRenderMaxOutput(t *testing.T) {
+	r := limitedRuntime(t, template.Limits{MaxOutput: 1024, MaxDepth: 32}, "")
+
+	_, err := r.Render("flood", `{{ range repeat 1000 }}0123456789{{ end }}`)
+	requireLimit(t, err, "maxOutput", "render exceeded limits.maxOutput of 1024 bytes")
+
+	out, err := r.Render("fits", `{{ range repeat 100 }}0123456789{{ end }}`)
+	require.NoError(t, err)
+	assert.Len(t, out, 1000)
+}
+
+func TestRenderMaxDepth(t *testing.T) {
+	r := limitedRuntime(t, template.Limits{MaxDepth: 8}, `
+{{ define "forever" }}{{ template "forever" . }}{{ end }}
+{{ define "countdown" }}{{ . }}{{ if gt . 0 }} {{ template "countdown" (sub . 1) }}{{ end }}{{ end }}
+`)
+
+	_, err := r.Render("rec", `{{ template "forever" . }}`)
+	requireLimit(t, err, "maxDepth", `template "forever": more than 8 nested calls (limits.maxDepth)`)
+
+	out, err := r.Render("ok", `{{ template "countdown" .input.n }}`)
+	require.NoError(t, err, "recursion within the limit works")
+	assert.Equal(t, "5 4 3 2 1 0", out)
+
+	_, err = r.Render("deep", `{{ template "countdown" 20 }}`)
+	requireLimit(t, err, "maxDepth", `template "countdown": more than 8 nested calls`)
+}
+
+func TestRenderAllow(t *testing.T) {
+	r := limitedRuntime(t, template.Limits{MaxDepth: 32, Allow: []string{"publish"}}, "")
+
+	_, err := r.Render("global", `{{ globalStore.Get "flag" }}`)
+	requireLimit(t, err, "allow", "globalStore: not allowed, add it to limits.allow")
+
+	_, err = r.Render("publish", `{{ publish "limits.test" }}`)
+	assert.NoError(t, err)
+
+	r = limitedRuntime(t, template.DefaultLimits, "")
+	_, err = r.Render("global", `{{ globalStore.Has "flag" }}`)
+	assert.NoError(t, err, "without an allow list every function is allowed")
+
+	require.NoError(t, template.CheckAllow([]string{"globalStore", "publish"}))
+	err = template.CheckAllow([]string{"upper"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"upper" is not a risky function, expected one of globalStore, publish`)
+}
+
+func TestRepeatMaximum(t *testing.T) {
+	r := limitedRuntime(t, template.DefaultLimits, "")
+	_, err := r.Render("huge", `{{ range repeat 1000000000 }}{{ end }}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "repeat: 1000000000 is more than the maximum of 1000000")
+}
+
+func TestFakeTextMaximum(t *testing.T) {
+	r := limitedRuntime(t, template.DefaultLimits, "")
+	for fn, max := range map[string]string{"fakeLorem": "10000", "fakeSentence": "10000", "fakeParagraph": "1000"} {
+		_, err := r.Render(fn, `{{ `+fn+` 1000000000 }}`)
+		require.Error(t, err, fn)
+		assert.Contains(t, err.Error(), fn+": 1000000000 is more than the maximum of "+max)
+
+		_, err = r.Render(fn+"-max", `{{ `+fn+` `+max+` }}`)
+		assert.NoError(t, err, fn)
+	}
+}
+
+func TestRandomRange(t *testing.T) {
+	r := limitedRuntime(t, template.DefaultLimits, "")
+	for _, raw := range []string{
+		`{{ random -9223372036854775808 9223372036854775807 }}`,
+		`{{ random 0 9007199254740993 }}`,
+		`{{ random -1e308 1e308 }}`,
+	} {
+		_, err := r.Render("random", raw)
+		require.Error(t, err, raw)
+		assert.Contains(t, err.Error(), "is too large", raw)
+	}
+	out, err := r.Render("random", `{{ random 9007199254740990 9007199254740991 }}`)
+	require.NoError(t, err)
+	assert.Contains(t, []string{"9007199254740990", "9007199254740991"}, out)
+}
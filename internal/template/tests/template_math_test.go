@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func TestMathFunctions(t *testing.T) {
+	input := map[string]any{"id": "41", "price": 19.99, "qty": float64(3)}
+
+	for raw, want := range map[string]string{
+		`{{ add 1 2 3 }}`:                    "6",
+		`{{ add .input.id 1 }}`:              "42",
+		`{{ add 0.5 1 }}`:                    "1.5",
+		`{{ sub 10 4 }}`:                     "6",
+		`{{ mul .input.price .input.qty }}`:  "59.97",
+		`{{ div 10 4 }}`:                     "2.5",
+		`{{ div 10 5 }}`:                     "2",
+		`{{ mod 10 3 }}`:                     "1",
+		`{{ min 3 1 2 }}`:                    "1",
+		`{{ max 3 1.5 }}`:                    "3",
+		`{{ abs -7 }}`:                       "7",
+		`{{ round 2.5 }}`:                    "3",
+		`{{ round 3.14159 2 }}`:              "3.14",
+		`{{ floor 2.7 }}`:                    "2",
+		`{{ ceil 2.1 }}`:                     "3",
+		`{{ toInt "12.9" }}`:                 "12",
+		`{{ atoi .input.id }}`:               "41",
+		`{{ toFloat "1.25" }}`:               "1.25",
+		`{{ 1234567.891 | formatNumber 2 }}`: "1,234,567.89",
+		`{{ formatNumber 0 -999.5 }}`:        "-1,000",
+		`{{ formatNumber 1 12 }}`:            "12.0",
+	} {
+		assert.Equal(t, want, renderWith(t, input, nil, raw), raw)
+	}
+}
+
+func TestMathErrors(t *testing.T) {
+	r, err := template.NewRuntime(template.MergeContext(nil, nil, nil), extensions.NewRegistry())
+	require.NoError(t, err)
+
+	for raw, msg := range map[string]string{
+		`{{ add 1 "x" }}`:         `add: "x" is not a number`,
+		`{{ div 1 0 }}`:           "div: division by zero",
+		`{{ mod 1 0 }}`:           "mod: division by zero",
+		`{{ mod 1.5 1 }}`:         "mod: expected integers",
+		`{{ toInt .input.none }}`: "toInt: <nil> (<nil>) is not a number",
+		`{{ formatNumber -1 2 }}`: "formatNumber: negative decimals",
+	} {
+		_, err := r.Render("err", raw)
+		require.Error(t, err, raw)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func TestSetCompilesOnce(t *testing.T) {
+	set, err := template.NewSet(nil, nil)
+	require.NoError(t, err)
+
+	first, err := set.Compile("body", `{{ .input.name }}`)
+	require.NoError(t, err)
+	again, err := set.Compile("body", `{{ .input.name }}`)
+	require.NoError(t, err)
+	assert.Equal(t, "body", first)
+	assert.Equal(t, first, again, "the same field and text are compiled once")
+	other, err := set.Compile("other", `{{ .input.name }}`)
+	require.NoError(t, err)
+	assert.Equal(t, "other", other, "errors of another field name that field")
+
+	second, err := set.Compile("body", `{{ .input.id }}`)
+	require.NoError(t, err)
+	assert.Equal(t, "body#2", second)
+
+	_, err = set.Compile("broken", `{{ .input.name `)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+	_, err = set.Compile("broken", `{{ .input.name `)
+	require.Error(t, err, "failed templates are not cached")
+
+	r, err := set.Runtime(template.MergeContext(map[string]any{"name": "ana", "id": 7}, nil, nil))
+	require.NoError(t, err)
+	out, err := r.Render("body", `{{ .input.name }}/{{ .input.id }}`)
+	require.NoError(t, err)
+	assert.Equal(t, "ana/7", out)
+}
+
+func TestSetRuntimesKeepTheirContext(t *testing.T) {
+	registry := extensions.NewRegistry()
+	require.NoError(t, registry.RegisterFunctions(map[string]string{
+		"greet(title)": `{{ .title }} {{ .input.name }}`,
+	}))
+	registry.Register("helpers.kurof", `{{ define "wrap" }}[{{ greet "Dr." }}]{{ end }}`, "helpers.kurof")
+	env := template.NewEnv(nil)
+	set, err := env.Templates(registry)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("user%d", i)
+			r, err := template.NewRuntimeEnv(template.MergeContext(map[string]any{"name": name}, nil, nil), registry, env)
+			if !assert.NoError(t, err) {
+				return
+			}
+			out, err := r.Render("body", `{{ greet "Ms." }} {{ template "wrap" . }}`)
+			assert.NoError(t, err)
+			assert.Equal(t, "Ms. "+name+" [Dr. "+name+"]", out)
+
+			// compiled after the runtime was created
+			out, err = r.Render("late", fmt.Sprintf(`{{ greet "Mx." }} %d`, i))
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("Mx. %s %d", name, i), out)
+		}(i)
+	}
+	wg.Wait()
+
+	cached, err := env.Templates(registry)
+	require.NoError(t, err)
+	assert.Same(t, set, cached, "a mock's runtimes share its set")
+
+	other, err := env.Templates(extensions.NewRegistry())
+	require.NoError(t, err)
+	assert.NotSame(t, set, other, "another registry compiles another set")
+}
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/store"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func TestStoreFunctions(t *testing.T) {
+	env := template.NewEnv(nil)
+	env.Global = store.New(nil)
+
+	assert.Equal(t, "1 2", renderEnv(t, env, `{{ store.Incr "hits" }} {{ store.Incr "hits" }}`))
+	assert.Equal(t, "", renderEnv(t, env, `{{ store.Set "user" (dict "name" "Ana") }}`))
+	assert.Equal(t, "Ana", renderEnv(t, env, `{{ (store.Get "user").name }}`))
+	assert.Equal(t, "guest", renderEnv(t, env, `{{ store.Get "role" "guest" }}`))
+	assert.Equal(t, "-", renderEnv(t, env, `{{ store.Append "ids" 1 }}-{{ store.Append "ids" 2 }}`))
+	assert.Equal(t, `[1,2]`, renderEnv(t, env, `{{ store.Get "ids" | toJSON }}`))
+	assert.Equal(t, "false", renderEnv(t, env, `{{ store.Delete "user" }}{{ store.Has "user" }}`))
+
+	// Every mock has its own store, and shares the global one
+	other := template.NewEnv(nil)
+	other.Global = env.Global
+	renderEnv(t, env, `{{ globalStore.Set "maintenance" true }}`)
+	assert.Equal(t, "true", renderEnv(t, other, `{{ globalStore.Get "maintenance" false }}`))
+	assert.Equal(t, "false", renderEnv(t, other, `{{ store.Has "hits" }}`))
+}
+
+func TestStoreFunctionErrors(t *testing.T) {
+	env := template.NewEnv(nil)
+	env.Store.Set("name", "kuro")
+	r, err := template.NewRuntimeEnv(template.MergeContext(nil, nil, nil), extensions.NewRegistry(), env)
+	require.NoError(t, err)
+
+	_, err = r.Render("err", `{{ store.Incr "name" }}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `store.Incr: key "name": kuro (string) is not a number`)
+}
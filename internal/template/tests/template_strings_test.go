@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usekuro/usekuro/internal/extensions"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+func TestStringFunctions(t *testing.T) {
+	input := map[string]any{"name": " Kuro Cat ", "id": float64(7), "tags": []any{"a", "b"}}
+
+	for raw, want := range map[string]string{
+		`{{ lower .input.name }}`:               " kuro cat ",
+		`{{ upper .input.id }}`:                 "7",
+		`{{ trim .input.name | title }}`:        "Kuro Cat",
+		`{{ lower .input.missing }}`:            "",
+		`{{ join .input.tags "," }}`:            "a,b",
+		`{{ join (split "x-y-z" "-") "+" }}`:    "x+y+z",
+		`{{ replace .input.name "Cat" "Dog" }}`: " Kuro Dog ",
+		`{{ contains .input.name "Cat" }}`:      "true",
+		`{{ contains .input.tags "b" }}`:        "true",
+		`{{ contains .input "id" }}`:            "true",
+		`{{ contains .input.missing "x" }}`:     "false",
+		`{{ regexMatch "^[0-9]+$" .input.id }}`: "true",
+	} {
+		assert.Equal(t, want, renderWith(t, input, nil, raw), raw)
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	r, err := template.NewRuntime(template.MergeContext(map[string]any{"tags": []any{"a"}}, nil, nil), extensions.NewRegistry())
+	require.NoError(t, err)
+
+	for raw, msg := range map[string]string{
+		`{{ lower .input }}`:       "lower: expected text, got map[string]interface {}",
+		`{{ trim .input.tags }}`:   "trim: expected text, got []interface {}",
+		`{{ join "a" "," }}`:       "join: expected a list, got string",
+		`{{ regexMatch "(" "x" }}`: "regexMatch: error parsing regexp",
+	} {
+		_, err := r.Render("err", raw)
+		require.Error(t, err, raw)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
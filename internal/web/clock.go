@@ -0,0 +1,134 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/usekuro/usekuro/internal/template"
+)
+
+// templateMock is a running mock whose templates can be made deterministic
+type templateMock interface {
+	TemplateEnv() *template.Env
+}
+
+// clockRequest changes a mock's virtual clock
+type clockRequest struct {
+	Action   string `json:"action"`   // freeze, resume, offset, advance or reset
+	Time     string `json:"time"`     // freeze, optional RFC3339 instant, now when empty
+	Duration string `json:"duration"` // offset and advance, e.g. 90m or -24h
+}
+
+// seedRequest restarts a mock's random functions
+type seedRequest struct {
+	Seed *int64 `json:"seed"` // optional, keeps the current seed when empty
+}
+
+// templateEnv returns the template environment of a running mock, writing
+// the error response when there is none
+func (s *Server) templateEnv(w http.ResponseWriter, r *http.Request) (*template.Env, bool) {
+	mockID := mux.Vars(r)["id"]
+
+	s.mocksMutex.RLock()
+	defer s.mocksMutex.RUnlock()
+
+	mock, exists := s.mocks[mockID]
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Mock not found")
+		return nil, false
+	}
+	handler, ok := mock.Handler.(templateMock)
+	if !ok || !mock.Running || handler.TemplateEnv() == nil {
+		respondWithError(w, http.StatusConflict, "Mock is not running")
+		return nil, false
+	}
+	return handler.TemplateEnv(), true
+}
+
+// clockResponse describes the clock and seed of a mock
+func clockResponse(env *template.Env) map[string]interface{} {
+	resp := map[string]interface{}{"clock": env.Clock.State()}
+	if seed, ok := env.Seed(); ok {
+		resp["seed"] = seed
+	}
+	return resp
+}
+
+// handleGetClock returns the virtual clock of a mock
+func (s *Server) handleGetClock(w http.ResponseWriter, r *http.Request) {
+	env, ok := s.templateEnv(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, clockResponse(env))
+}
+
+// handleSetClock freezes, shifts, advances or resets the virtual clock of a
+// mock, e.g. {"action": "advance", "duration": "24h"}
+func (s *Server) handleSetClock(w http.ResponseWriter, r *http.Request) {
+	env, ok := s.templateEnv(w, r)
+	if !ok {
+		return
+	}
+
+	var req clockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	var d time.Duration
+	if req.Action == "offset" || req.Action == "advance" {
+		var err error
+		if d, err = time.ParseDuration(req.Duration); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid duration: "+req.Duration)
+			return
+		}
+	}
+
+	switch req.Action {
+	case "freeze":
+		var at time.Time
+		if req.Time != "" {
+			var err error
+			if at, err = time.Parse(time.RFC3339, req.Time); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Time must be RFC3339: "+req.Time)
+				return
+			}
+		}
+		env.Clock.Freeze(at)
+	case "resume":
+		env.Clock.Resume()
+	case "offset":
+		env.Clock.SetOffset(d)
+	case "advance":
+		env.Clock.Advance(d)
+	case "reset":
+		env.Clock.Reset()
+	default:
+		respondWithError(w, http.StatusBadRequest, "Action must be freeze, resume, offset, advance or reset")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, clockResponse(env))
+}
+
+// handleReseed restarts the random sequence of a mock, optionally with a new
+// seed
+func (s *Server) handleReseed(w http.ResponseWriter, r *http.Request) {
+	env, ok := s.templateEnv(w, r)
+	if !ok {
+		return
+	}
+
+	var req seedRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+	}
+	env.Reseed(req.Seed)
+	respondWithJSON(w, http.StatusOK, clockResponse(env))
+}
@@ -0,0 +1,35 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/usekuro/usekuro/internal/events"
+)
+
+// publishRequest sends an event to the running mocks
+type publishRequest struct {
+	Name string `json:"name"`
+	Data any    `json:"data"` // optional
+}
+
+// handleListEvents returns the last events published by the running mocks
+func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, events.Default.Recent())
+}
+
+// handlePublishEvent sends an event to the running mocks, e.g.
+// {"name": "orders.shipped", "data": {"id": 7}}
+func (s *Server) handlePublishEvent(w http.ResponseWriter, r *http.Request) {
+	var req publishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Event name is required")
+		return
+	}
+	events.Default.Publish(events.Event{Name: req.Name, Data: req.Data, Source: "api"})
+	respondWithJSON(w, http.StatusAccepted, map[string]interface{}{"published": req.Name})
+}
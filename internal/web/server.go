@@ -246,6 +246,8 @@ func (s *Server) handleToggleMock(w http.ResponseWriter, r *http.Request) {
 				handler.Stop()
 			case *runtime.SFTPHandler:
 				handler.Stop()
+			case *runtime.FTPHandler:
+				handler.Stop()
 			}
 		}
 
@@ -283,8 +285,10 @@ func (s *Server) handleToggleMock(w http.ResponseWriter, r *http.Request) {
 			handler = runtime.NewTCPHandler()
 		case "ws", "websocket":
 			handler = runtime.NewWSHandler()
-		case "sftp":
+		case "sftp", "ssh":
 			handler = runtime.NewSFTPHandler()
+		case "ftp":
+			handler = runtime.NewFTPHandler()
 		default:
 			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported protocol: %s", mock.Protocol))
 			return
@@ -339,6 +343,8 @@ func (s *Server) handleToggleServer(w http.ResponseWriter, r *http.Request) {
 						handler.Stop()
 					case *runtime.SFTPHandler:
 						handler.Stop()
+					case *runtime.FTPHandler:
+						handler.Stop()
 					}
 				}
 				if m.Cancel != nil {
@@ -674,6 +680,8 @@ func (s *Server) handleUserMock(w http.ResponseWriter, r *http.Request) {
 					handler.Stop()
 				case *runtime.SFTPHandler:
 					handler.Stop()
+				case *runtime.FTPHandler:
+					handler.Stop()
 				}
 			}
 		}
@@ -804,6 +812,21 @@ func (s *Server) routes() {
 	api.HandleFunc("/server/toggle", s.handleToggleServer).Methods("POST")
 	api.HandleFunc("/mocks/{id}", s.handleUpdateMock).Methods("PUT")
 
+	// SFTP inspection endpoints
+	api.HandleFunc("/mocks/{id}/sftp/operations", s.handleSFTPOperations).Methods("GET")
+	api.HandleFunc("/mocks/{id}/sftp/uploads", s.handleSFTPUploads).Methods("GET")
+	api.HandleFunc("/mocks/{id}/sftp/file", s.handleSFTPFile).Methods("GET")
+	api.HandleFunc("/mocks/{id}/sftp/hostkeys", s.handleSFTPHostKeys).Methods("GET")
+
+	// Deterministic templates
+	api.HandleFunc("/mocks/{id}/clock", s.handleGetClock).Methods("GET")
+	api.HandleFunc("/mocks/{id}/clock", s.handleSetClock).Methods("POST")
+	api.HandleFunc("/mocks/{id}/seed", s.handleReseed).Methods("POST")
+	api.HandleFunc("/mocks/{id}/store", s.handleGetStore).Methods("GET")
+	api.HandleFunc("/mocks/{id}/store", s.handleClearStore).Methods("DELETE")
+	api.HandleFunc("/events", s.handleListEvents).Methods("GET")
+	api.HandleFunc("/events", s.handlePublishEvent).Methods("POST")
+
 	s.router.HandleFunc("/", s.handleIndex).Methods("GET")
 }
 
@@ -883,7 +906,7 @@ func (s *Server) generateMockDefinition(protocol string, port int, name, descrip
 				},
 			},
 		}
-	case "sftp":
+	case "sftp", "ftp":
 		definition.SFTPAuth = &schema.SFTPAuth{
 			Username: "usekuro",
 			Password: "kuro123",
@@ -891,11 +914,11 @@ func (s *Server) generateMockDefinition(protocol string, port int, name, descrip
 		definition.Files = []schema.FileEntry{
 			{
 				Path:    "/welcome.txt",
-				Content: "Welcome to " + name + " SFTP server!",
+				Content: "Welcome to " + name + " " + strings.ToUpper(protocol) + " server!",
 			},
 			{
 				Path:    "/data/sample.json",
-				Content: `{"server": "` + name + `", "protocol": "sftp"}`,
+				Content: `{"server": "` + name + `", "protocol": "` + strings.ToLower(protocol) + `"}`,
 			},
 		}
 	case "tcp":
@@ -1062,6 +1085,8 @@ func (s *Server) handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
 						handler.Stop()
 					case *runtime.SFTPHandler:
 						handler.Stop()
+					case *runtime.FTPHandler:
+						handler.Stop()
 					}
 				}
 			}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/gorilla/mux"
+	"github.com/usekuro/usekuro/internal/runtime"
+)
+
+// fileMock is a running mock that serves files, over SFTP or FTP
+type fileMock interface {
+	Operations() []runtime.SFTPOperation
+	Uploads() []runtime.SFTPUpload
+	ReadFile(name string) ([]byte, error)
+}
+
+// sftpHandler returns the running SFTP or FTP handler of a mock, writing the
+// error response when there is none
+func (s *Server) sftpHandler(w http.ResponseWriter, r *http.Request) (fileMock, bool) {
+	mockID := mux.Vars(r)["id"]
+
+	s.mocksMutex.RLock()
+	defer s.mocksMutex.RUnlock()
+
+	mock, exists := s.mocks[mockID]
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Mock not found")
+		return nil, false
+	}
+	handler, ok := mock.Handler.(fileMock)
+	if !ok || !mock.Running {
+		respondWithError(w, http.StatusConflict, "Mock is not a running SFTP or FTP mock")
+		return nil, false
+	}
+	return handler, true
+}
+
+// handleSFTPOperations lists the operations recorded by an SFTP or FTP mock
+func (s *Server) handleSFTPOperations(w http.ResponseWriter, r *http.Request) {
+	handler, ok := s.sftpHandler(w, r)
+	if !ok {
+		return
+	}
+	ops := handler.Operations()
+	if ops == nil {
+		ops = []runtime.SFTPOperation{}
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"operations": ops})
+}
+
+// handleSFTPUploads lists the files uploaded to an SFTP or FTP mock
+func (s *Server) handleSFTPUploads(w http.ResponseWriter, r *http.Request) {
+	handler, ok := s.sftpHandler(w, r)
+	if !ok {
+		return
+	}
+	uploads := handler.Uploads()
+	if uploads == nil {
+		uploads = []runtime.SFTPUpload{}
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"uploads": uploads})
+}
+
+// handleSFTPFile downloads a file from an SFTP or FTP mock, e.g. ?path=/inbox/a.csv
+func (s *Server) handleSFTPFile(w http.ResponseWriter, r *http.Request) {
+	handler, ok := s.sftpHandler(w, r)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("path")
+	if name == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+
+	data, err := handler.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			respondWithError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	contentType := getContentType(path.Ext(name))
+	if contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+path.Base(name)+"\"")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// handleSFTPHostKeys returns the host key fingerprints and known_hosts lines
+// of an SFTP mock
+func (s *Server) handleSFTPHostKeys(w http.ResponseWriter, r *http.Request) {
+	handler, ok := s.sftpHandler(w, r)
+	if !ok {
+		return
+	}
+	sftpHandler, ok := handler.(*runtime.SFTPHandler)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Mock does not use SSH host keys")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"hostKeys": sftpHandler.HostKeys()})
+}
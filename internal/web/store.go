@@ -0,0 +1,38 @@
+package web
+
+import (
+	"net/http"
+)
+
+// handleGetStore returns the values of a mock's store
+func (s *Server) handleGetStore(w http.ResponseWriter, r *http.Request) {
+	env, ok := s.templateEnv(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"store":  env.Store.All(),
+		"global": env.Global.All(),
+	})
+}
+
+// handleClearStore removes every value of a mock's store, or of the global
+// store with ?scope=global
+func (s *Server) handleClearStore(w http.ResponseWriter, r *http.Request) {
+	env, ok := s.templateEnv(w, r)
+	if !ok {
+		return
+	}
+	target := env.Store
+	if r.URL.Query().Get("scope") == "global" {
+		target = env.Global
+	}
+	if err := target.Clear(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"store":  env.Store.All(),
+		"global": env.Global.All(),
+	})
+}